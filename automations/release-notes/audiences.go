@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// Audience describes one flavour of release notes built from the same set of PRs.
+type Audience struct {
+	Name  string
+	Title string
+
+	// SystemPrompt is prepended to productContext for every summary request.
+	SystemPrompt string
+	// Instruction closes the user prompt and tells the model what shape of summary to produce.
+	Instruction string
+
+	// Include filters which entries show up for this audience and returns the entry as the
+	// audience sees it, which may leave out some of its PRs. nil includes everything.
+	Include func(PRInfo) (PRInfo, bool)
+	// IncludeInternal keeps changes to internal components in the notes.
+	IncludeInternal bool
+	// Format renders a single bullet from the PR and its generated summary.
+	Format func(PRInfo, string) string
+}
+
+// productContext is shared by every audience so the model understands what SendAuth is
+// and how the repository is laid out. Internal components are only described to audiences
+// that are allowed to see them.
+func productContext(includeInternal bool) string {
+	return `SendAuth enables users to quickly authenticate each other using passkeys that are established out of normal authentication protocols. This means that if someone calls on the phone and says they're Bob, the person answering the phone can send them an authentication request and Bob must verify his identity with a passkey.
+
+	The top-level directories represent Go modules, except the web directory which houses the frontend that's React/Typescript. More details:
+` + componentList(includeInternal)
+}
+
+const internalExclusions = `Do not mention the ops view or core config editor. Those are internal to SendAuth staff.`
+
+var customerAudience = Audience{
+	Name:  "customer",
+	Title: "Release Notes",
+	SystemPrompt: `You are a technical writer creating release notes. Provide a concise, user-focused summary (1-2 sentences) of what changed and why it matters. Focus on the impact to users, not implementation details.
+
+	` + internalExclusions,
+	Instruction: "Provide a brief, clear summary suitable for customer-facing release notes.",
+	Include:     customerVisible,
+	Format: func(info PRInfo, summary string) string {
+		return fmt.Sprintf("- **%s** - %s", info.prRefs(), summary)
+	},
+}
+
+var engineeringAudience = Audience{
+	Name:            "engineering",
+	Title:           "Engineering Digest",
+	SystemPrompt:    `You are a senior engineer writing an internal digest of what merged in this release. Provide a concise technical summary (1-3 sentences) covering what changed in the code, which modules were touched, and anything other engineers should know (migrations, new config, changed interfaces). Internal tooling may be mentioned.`,
+	Instruction:     "Provide a brief technical summary suitable for an internal engineering digest.",
+	IncludeInternal: true,
+	Format: func(info PRInfo, summary string) string {
+		links := make([]string, 0, len(info.Related)+1)
+		for _, p := range info.allPRs() {
+			links = append(links, fmt.Sprintf("[PR #%d](%s) by @%s", p.PR.GetNumber(), p.PR.GetHTMLURL(), p.PR.GetUser().GetLogin()))
+		}
+		return fmt.Sprintf("- **%s** - %s (%s)", info.prRefs(), summary, strings.Join(links, ", "))
+	},
+}
+
+var supportAudience = Audience{
+	Name:  "support",
+	Title: "Support Briefing",
+	SystemPrompt: `You are briefing the customer support team on a new release. Provide a concise summary (1-3 sentences) that highlights behaviour changes customers may notice or ask about: changed workflows, new or removed options, different error messages, and fixed bugs they may have reported. If nothing changes from a customer's point of view, say so plainly.
+
+	` + internalExclusions,
+	Instruction: "Provide a brief summary of behaviour changes suitable for a support-team briefing.",
+	Include: func(info PRInfo) (PRInfo, bool) {
+		info, ok := customerVisible(info)
+		return info, ok && !onlyTestChanges(info)
+	},
+	Format: func(info PRInfo, summary string) string {
+		return fmt.Sprintf("- **%s** %s - %s", info.prRefs(), info.Issue.GetTitle(), summary)
+	},
+}
+
+var audiences = []Audience{customerAudience, engineeringAudience, supportAudience}
+
+// selectAudiences resolves a comma-separated list of audience names. An empty list selects all of them.
+func selectAudiences(names string) ([]Audience, error) {
+	if strings.TrimSpace(names) == "" {
+		return audiences, nil
+	}
+
+	var selected []Audience
+	for name := range strings.SplitSeq(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, a := range audiences {
+			if a.Name == name {
+				selected = append(selected, a)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown audience %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// visible returns the PRs the audience's Include lets through, each looked at on its own.
+func (a Audience) visible(prs []PRInfo) []PRInfo {
+	if a.Include == nil {
+		return prs
+	}
+	var visible []PRInfo
+	for _, info := range prs {
+		if info, ok := a.Include(info); ok {
+			visible = append(visible, info)
+		}
+	}
+	return visible
+}
+
+// customerVisible removes the PRs explicitly labeled as internal-only from a consolidated entry,
+// so their titles, bodies and numbers never reach a customer-facing prompt. The earliest PR left
+// leads the entry; entries with nothing left are dropped.
+func customerVisible(info PRInfo) (PRInfo, bool) {
+	var visible []PRInfo
+	for _, p := range info.allPRs() {
+		if !hasInternalLabel(p.PR) {
+			p.Related = nil
+			visible = append(visible, p)
+		}
+	}
+	if len(visible) == 0 {
+		return PRInfo{}, false
+	}
+	lead := visible[0]
+	lead.Related = visible[1:]
+	if len(lead.Related) == 0 {
+		lead.Related = nil
+	}
+	return lead, true
+}
+
+func hasInternalLabel(pr *github.PullRequest) bool {
+	for _, label := range pr.Labels {
+		switch strings.ToLower(label.GetName()) {
+		case "internal", "no-release-notes":
+			return true
+		}
+	}
+	return false
+}
+
+// onlyTestChanges reports whether every changed file is a test or test fixture.
+func onlyTestChanges(info PRInfo) bool {
+	files := info.allFiles()
+	if len(files) == 0 {
+		return false
+	}
+	for _, f := range files {
+		if !isTestFile(f.GetFilename()) {
+			return false
+		}
+	}
+	return true
+}
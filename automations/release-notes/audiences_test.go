@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func labeledPR(number int, labels ...string) PRInfo {
+	pr := &github.PullRequest{Number: github.Ptr(number)}
+	for _, l := range labels {
+		pr.Labels = append(pr.Labels, &github.Label{Name: github.Ptr(l)})
+	}
+	return PRInfo{PR: pr}
+}
+
+func TestCustomerVisible(t *testing.T) {
+	entry := func(lead PRInfo, related ...PRInfo) PRInfo {
+		lead.Related = related
+		return lead
+	}
+	tests := []struct {
+		name string
+		info PRInfo
+		want []int // PR numbers left, lead first; nil drops the entry
+	}{
+		{"public", entry(labeledPR(1)), []int{1}},
+		{"internal", entry(labeledPR(1, "Internal")), nil},
+		{"all internal", entry(labeledPR(1, "internal"), labeledPR(2, "no-release-notes")), nil},
+		{"internal related", entry(labeledPR(1), labeledPR(2, "internal"), labeledPR(3)), []int{1, 3}},
+		{"internal lead", entry(labeledPR(1, "no-release-notes"), labeledPR(2), labeledPR(3)), []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := customerVisible(tt.info)
+			if !ok {
+				if tt.want != nil {
+					t.Fatalf("entry dropped, want %v", tt.want)
+				}
+				return
+			}
+			var numbers []int
+			for _, p := range got.allPRs() {
+				numbers = append(numbers, p.PR.GetNumber())
+			}
+			if !slices.Equal(numbers, tt.want) {
+				t.Errorf("got %v, want %v", numbers, tt.want)
+			}
+		})
+	}
+}
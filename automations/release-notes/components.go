@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// Component is a top-level area of the repository that release notes are grouped by.
+type Component struct {
+	Name        string
+	Description string
+	// Internal components never show up in customer-facing notes.
+	Internal bool
+}
+
+// knownComponents describes the top-level directories of the web repo. The order here is the
+// order groups appear in the release notes.
+var knownComponents = []Component{
+	{Name: "web", Description: "the React/Typescript frontend"},
+	{Name: "api", Description: "contains serialization logic for API request->app and app->API reply"},
+	{Name: "controllers", Description: "API request handlers"},
+	{Name: "app", Description: "contains the main runloops for the SendAuth webapp"},
+	{Name: "authn", Description: "authentication types"},
+	{Name: "authz", Description: "authorization types"},
+	{Name: "models", Description: "Application domain model logic"},
+	{Name: "data", Description: "main POGOs for SendAuth. Everything is converted to data when incoming, and then converted to some other form for outgoing (eg, API or DTO)"},
+	{Name: "dao", Description: "persistence layer"},
+	{Name: "clients", Description: "Clients of external services, like Dynamo or Postgres or Redis"},
+	{Name: "service", Description: "external service integration layer (eg, sending emails or SMS)"},
+	{Name: "slack", Description: "Slack integration logic"},
+	{Name: "tasks", Description: "holds asynchronous event firing and handlers"},
+	{Name: "ws", Description: "websocket connection logic"},
+	{Name: "middleware", Description: "HTTP middleware for the webapp"},
+	{Name: "server", Description: "contains the app entrypoints"},
+	{Name: "cmd", Description: "app entrypoints"},
+	{Name: "templates", Description: "hold static HTML pages for errors"},
+	{Name: "types", Description: "shared types that aren't data"},
+	{Name: "asynqmon", Description: "queue monitoring for SendAuth staff", Internal: true},
+	{Name: "automation", Description: "SendAuth automation", Internal: true},
+	{Name: "bin", Description: "compiled output", Internal: true},
+	{Name: "deploy", Description: "deployment configuration", Internal: true},
+	{Name: "fake", Description: "test fakes", Internal: true},
+	{Name: "internal", Description: "deployment environment config", Internal: true},
+	{Name: "log", Description: "logging config", Internal: true},
+	{Name: "public", Description: "static assets", Internal: true},
+	{Name: "regressions", Description: "regression tests", Internal: true},
+	{Name: "test", Description: "testing utilities", Internal: true},
+}
+
+// rootComponent collects files that live at the top of the repository.
+const rootComponent = "repository"
+
+// Components maps changed paths to the component that owns them.
+type Components struct {
+	// modules are directories containing a go.mod, longest first so nested modules win
+	modules  []string
+	internal map[string]bool
+}
+
+// loadComponents walks the checkout at root for go.mod files to find module boundaries. Extra
+// internal component names can be supplied on top of the ones marked in knownComponents.
+func loadComponents(root string, extraInternal []string) *Components {
+	c := &Components{internal: make(map[string]bool)}
+	for _, k := range knownComponents {
+		if k.Internal {
+			c.internal[k.Name] = true
+		}
+	}
+	for _, name := range extraInternal {
+		if name = strings.Trim(strings.TrimSpace(name), "/"); name != "" {
+			c.internal[name] = true
+		}
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case ".git", "node_modules", "vendor":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(p))
+		if err == nil && rel != "." {
+			c.modules = append(c.modules, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("error scanning for go modules", "root", root, "err", err)
+	}
+
+	slices.SortFunc(c.modules, func(a, b string) int { return len(b) - len(a) })
+	slog.Info("found go modules", "count", len(c.modules))
+	return c
+}
+
+// componentFor returns the component owning a path: the innermost Go module containing it, or
+// its top-level directory when it isn't inside a module.
+func (c *Components) componentFor(file string) string {
+	for _, m := range c.modules {
+		if strings.HasPrefix(file, m+"/") {
+			return m
+		}
+	}
+	top, _, ok := strings.Cut(file, "/")
+	if !ok {
+		return rootComponent
+	}
+	return top
+}
+
+// isInternal reports whether a component, or the top-level directory it lives in, is internal.
+func (c *Components) isInternal(component string) bool {
+	if c.internal[component] {
+		return true
+	}
+	top, _, _ := strings.Cut(component, "/")
+	return c.internal[top]
+}
+
+// visibleFiles drops files that belong to internal components unless the audience can see them.
+func (c *Components) visibleFiles(files []*github.CommitFile, includeInternal bool) []*github.CommitFile {
+	if includeInternal {
+		return files
+	}
+	var visible []*github.CommitFile
+	for _, f := range files {
+		if !c.isInternal(c.componentFor(f.GetFilename())) {
+			visible = append(visible, f)
+		}
+	}
+	return visible
+}
+
+// primary picks the component with the most changed lines in a PR. It returns "" when every
+// changed file is internal and the audience can't see internal components.
+func (c *Components) primary(info PRInfo, includeInternal bool) string {
+	files := info.allFiles()
+	if len(files) == 0 {
+		return rootComponent
+	}
+
+	changes := make(map[string]int)
+	for _, f := range c.visibleFiles(files, includeInternal) {
+		changes[c.componentFor(f.GetFilename())] += f.GetChanges() + 1
+	}
+
+	best, bestChanges := "", 0
+	for name, n := range changes {
+		if n > bestChanges || (n == bestChanges && componentRank(name) < componentRank(best)) {
+			best, bestChanges = name, n
+		}
+	}
+	return best
+}
+
+// componentRank orders components by their position in knownComponents, unknown ones last.
+func componentRank(name string) int {
+	top, _, _ := strings.Cut(name, "/")
+	for i, k := range knownComponents {
+		if k.Name == top {
+			return i
+		}
+	}
+	return len(knownComponents)
+}
+
+// componentList renders the component descriptions for the model's system prompt.
+func componentList(includeInternal bool) string {
+	var b strings.Builder
+	for _, k := range knownComponents {
+		if k.Internal && !includeInternal {
+			continue
+		}
+		b.WriteString("\t* " + k.Name + " - " + k.Description + "\n")
+	}
+	return b.String()
+}
+
+// workspaceRoot is the checkout release notes are generated from.
+func workspaceRoot() string {
+	if ws := os.Getenv("GITHUB_WORKSPACE"); ws != "" {
+		return ws
+	}
+	return "."
+}
+
+// displayName is the heading used for a component group.
+func displayName(component string) string {
+	if component == rootComponent {
+		return "Repository"
+	}
+	return "`" + component + "`"
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func testComponents(t *testing.T) *Components {
+	t.Helper()
+	root := t.TempDir()
+	for _, path := range []string{"go.mod", "clients/go.mod", "clients/redis/go.mod", "web/node_modules/pkg/go.mod"} {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("module example.com/x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return loadComponents(root, []string{" tools/ ", ""})
+}
+
+func TestComponentFor(t *testing.T) {
+	c := testComponents(t)
+	tests := []struct {
+		file     string
+		want     string
+		internal bool
+	}{
+		{"controllers/passkey.go", "controllers", false},
+		{"clients/dynamo/client.go", "clients", false},
+		{"clients/redis/pool.go", "clients/redis", false},
+		{"web/node_modules/pkg/index.js", "web", false},
+		{"README.md", rootComponent, false},
+		{"deploy/helm/values.yaml", "deploy", true},
+		{"tools/gen/main.go", "tools", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got := c.componentFor(tt.file)
+			if got != tt.want {
+				t.Errorf("componentFor(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+			if internal := c.isInternal(got); internal != tt.internal {
+				t.Errorf("isInternal(%q) = %v, want %v", got, internal, tt.internal)
+			}
+		})
+	}
+}
+
+func TestPrimaryComponent(t *testing.T) {
+	c := testComponents(t)
+	file := func(name string, changes int) *github.CommitFile {
+		return &github.CommitFile{Filename: github.Ptr(name), Changes: github.Ptr(changes)}
+	}
+	tests := []struct {
+		name            string
+		files           []*github.CommitFile
+		includeInternal bool
+		want            string
+	}{
+		{"most changed lines", []*github.CommitFile{file("web/src/App.tsx", 5), file("controllers/passkey.go", 40)}, false, "controllers"},
+		{"tie goes to the earlier component", []*github.CommitFile{file("dao/user.go", 10), file("api/user.go", 10)}, false, "api"},
+		{"internal hidden", []*github.CommitFile{file("deploy/values.yaml", 100), file("web/src/App.tsx", 1)}, false, "web"},
+		{"internal shown", []*github.CommitFile{file("deploy/values.yaml", 100), file("web/src/App.tsx", 1)}, true, "deploy"},
+		{"only internal", []*github.CommitFile{file("deploy/values.yaml", 100)}, false, ""},
+		{"no files", nil, false, rootComponent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := PRInfo{PR: &github.PullRequest{Number: github.Ptr(1)}, Files: tt.files}
+			if got := c.primary(info, tt.includeInternal); got != tt.want {
+				t.Errorf("primary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
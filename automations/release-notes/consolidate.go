@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// titleSimilarityThreshold is the Jaccard similarity two PR titles' words must exceed for the
+// PRs to be treated as parts of the same change. It is exclusive, so "Bump golang.org/x/net" and
+// "Bump golang.org/x/crypto", which share three of five words, stay apart.
+const titleSimilarityThreshold = 0.6
+
+var linkedIssue = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?|part of|relates to|refs?)\s*:?\s+#(\d+)`)
+
+// branchSuffix matches the trailing part/step counters people use when splitting a feature up,
+// e.g. "passkey-reset-2", "passkey-reset/part-3", "passkey-reset_pt2".
+var branchSuffix = regexp.MustCompile(`(?i)[-_/](?:part|pt|step|phase)?[-_]?\d+[a-z]?$`)
+
+var titleWord = regexp.MustCompile(`[a-z0-9]+`)
+
+var titleStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "to": true, "for": true, "of": true, "in": true,
+	"on": true, "with": true, "from": true, "bug": true, "fix": true, "feat": true, "chore": true,
+	"part": true, "pt": true, "wip": true,
+}
+
+// genericBranches are branch stems too common to imply two PRs belong together.
+var genericBranches = map[string]bool{
+	"fix": true, "bugfix": true, "hotfix": true, "feature": true, "feat": true, "chore": true,
+	"update": true, "patch": true,
+}
+
+// botBranches are the branch namespaces of dependency bots, whose branches never imply two PRs
+// belong together: each one bumps a different dependency.
+var botBranches = map[string]bool{
+	"dependabot": true, "renovate": true,
+}
+
+// allPRs returns the lead PR followed by any PRs consolidated into it.
+func (info PRInfo) allPRs() []PRInfo {
+	return append([]PRInfo{info}, info.Related...)
+}
+
+// allFiles returns the files changed across every PR in the entry.
+func (info PRInfo) allFiles() []*github.CommitFile {
+	if len(info.Related) == 0 {
+		return info.Files
+	}
+	files := append([]*github.CommitFile(nil), info.Files...)
+	for _, r := range info.Related {
+		files = append(files, r.Files...)
+	}
+	return files
+}
+
+// prRefs renders "#1, #2, #3" for every PR in the entry.
+func (info PRInfo) prRefs() string {
+	refs := make([]string, 0, len(info.Related)+1)
+	for _, p := range info.allPRs() {
+		refs = append(refs, fmt.Sprintf("#%d", p.PR.GetNumber()))
+	}
+	return strings.Join(refs, ", ")
+}
+
+// linkedIssues extracts the issues a PR says it closes or belongs to.
+func linkedIssues(pr *github.PullRequest) []int {
+	var nums []int
+	for _, m := range linkedIssue.FindAllStringSubmatch(pr.GetBody(), -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n != pr.GetNumber() {
+			nums = append(nums, n)
+		}
+	}
+	return nums
+}
+
+// branchStem reduces a head branch to the stem shared by the PRs of a split-up feature. Trailing
+// counters are dropped ("mdm/passkey-reset-2" -> "mdm/passkey-reset"), as are generic segments
+// ("feature/mdm/passkeys" -> "mdm/passkeys"), and for stems with three or more segments, so is
+// the last one ("mdm/passkeys/api" -> "mdm/passkeys"). Bot branches and stems with nothing but
+// generic segments return "".
+func branchStem(ref string) string {
+	stem := strings.ToLower(ref)
+	for {
+		trimmed := branchSuffix.ReplaceAllString(stem, "")
+		if trimmed == stem {
+			break
+		}
+		stem = trimmed
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(stem, "/") {
+		if botBranches[segment] {
+			return ""
+		}
+		if segment != "" && !genericBranches[segment] {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) >= 3 {
+		segments = segments[:len(segments)-1]
+	}
+	if len(segments) == 0 || len(segments[len(segments)-1]) < 4 {
+		return ""
+	}
+	return strings.Join(segments, "/")
+}
+
+// titleWords returns the set of meaningful lowercase words in a title.
+func titleWords(title string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range titleWord.FindAllString(strings.ToLower(title), -1) {
+		if !titleStopWords[w] && len(w) > 1 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// titleSimilarity is the Jaccard similarity of two titles' word sets.
+func titleSimilarity(a, b map[string]bool) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// consolidate clusters PRs that are parts of the same change — they link the same issue, come
+// from branches with the same stem, or have near-identical titles — into a single entry. The
+// earliest PR leads the entry and the rest are attached as Related. Bot PRs are never clustered:
+// a run of dependency bumps shares titles and branch prefixes without being one change.
+func consolidate(prs []PRInfo) []PRInfo {
+	parent := make([]int, len(prs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) {
+		ra, rb := find(a), find(b)
+		if ra == rb {
+			return
+		}
+		// Keep the lower index as the root so the earliest PR leads the cluster
+		if rb < ra {
+			ra, rb = rb, ra
+		}
+		parent[rb] = ra
+	}
+
+	byIssue := make(map[int]int)
+	byBranch := make(map[string]int)
+	words := make([]map[string]bool, len(prs))
+
+	for i, info := range prs {
+		if isBot(info.PR.GetUser()) {
+			continue
+		}
+		for _, issue := range linkedIssues(info.PR) {
+			if j, ok := byIssue[issue]; ok {
+				union(i, j)
+			} else {
+				byIssue[issue] = i
+			}
+		}
+
+		if stem := branchStem(info.PR.GetHead().GetRef()); stem != "" {
+			if j, ok := byBranch[stem]; ok {
+				union(i, j)
+			} else {
+				byBranch[stem] = i
+			}
+		}
+
+		words[i] = titleWords(info.PR.GetTitle())
+		for j := 0; j < i; j++ {
+			if titleSimilarity(words[i], words[j]) > titleSimilarityThreshold {
+				union(i, j)
+			}
+		}
+	}
+
+	clusters := make(map[int][]int)
+	for i := range prs {
+		root := find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+
+	roots := make([]int, 0, len(clusters))
+	for root := range clusters {
+		roots = append(roots, root)
+	}
+	sort.Ints(roots)
+
+	consolidated := make([]PRInfo, 0, len(roots))
+	for _, root := range roots {
+		members := clusters[root]
+		lead := prs[members[0]]
+		for _, m := range members[1:] {
+			lead.Related = append(lead.Related, prs[m])
+		}
+		if len(lead.Related) > 0 {
+			slog.Info("consolidated related PRs", "lead", lead.PR.GetNumber(), "prs", lead.prRefs())
+		}
+		consolidated = append(consolidated, lead)
+	}
+
+	return consolidated
+}
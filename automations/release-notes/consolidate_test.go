@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestBranchStem(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"mdm/passkey-reset", "mdm/passkey-reset"},
+		{"mdm/passkey-reset-2", "mdm/passkey-reset"},
+		{"mdm/passkey-reset/part-3", "mdm/passkey-reset"},
+		{"mdm/passkey-reset_pt2", "mdm/passkey-reset"},
+		{"mdm/passkeys/api", "mdm/passkeys"},
+		{"feature/mdm/passkeys", "mdm/passkeys"},
+		{"Feature/Passkey-Reset", "passkey-reset"},
+		{"fix", ""},
+		{"fix/hotfix", ""},
+		{"fix/ui", ""},
+		{"dependabot/npm_and_yarn/lodash-4.17.21", ""},
+		{"dependabot/go_modules/golang.org/x/net-0.38.0", ""},
+		{"renovate/react-monorepo", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := branchStem(tt.ref); got != tt.want {
+				t.Errorf("branchStem(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		merge bool
+	}{
+		{"Add passkey reset API", "Add passkey reset UI", false},
+		{"Passkey reset: add API endpoint", "Passkey reset: add API endpoint tests", true},
+		{"Bump golang.org/x/net", "Bump golang.org/x/crypto", false},
+		{"Fix typo", "Fix typo", false}, // too few words to compare
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" / "+tt.b, func(t *testing.T) {
+			got := titleSimilarity(titleWords(tt.a), titleWords(tt.b)) > titleSimilarityThreshold
+			if got != tt.merge {
+				t.Errorf("similar = %v, want %v", got, tt.merge)
+			}
+		})
+	}
+}
+
+func testPR(number int, login, ref, title, body string) PRInfo {
+	return PRInfo{PR: &github.PullRequest{
+		Number: github.Ptr(number),
+		User:   &github.User{Login: github.Ptr(login)},
+		Head:   &github.PullRequestBranch{Ref: github.Ptr(ref)},
+		Title:  github.Ptr(title),
+		Body:   github.Ptr(body),
+	}}
+}
+
+func TestConsolidate(t *testing.T) {
+	tests := []struct {
+		name string
+		prs  []PRInfo
+		want [][]int // PR numbers per entry, lead first
+	}{
+		{
+			name: "same branch stem",
+			prs: []PRInfo{
+				testPR(1, "alice", "mdm/passkey-reset-1", "Passkey reset backend", ""),
+				testPR(2, "bob", "fix/typo", "Typo in README", ""),
+				testPR(3, "alice", "mdm/passkey-reset-2", "Passkey reset screens", ""),
+			},
+			want: [][]int{{1, 3}, {2}},
+		},
+		{
+			name: "linked issue",
+			prs: []PRInfo{
+				testPR(1, "alice", "a-branch", "Backend for exports", "Part of #40"),
+				testPR(2, "bob", "b-branch", "Export button", "Closes #40"),
+			},
+			want: [][]int{{1, 2}},
+		},
+		{
+			name: "bot PRs stay separate",
+			prs: []PRInfo{
+				testPR(1, "dependabot[bot]", "dependabot/go_modules/golang.org/x/net-0.38.0", "Bump golang.org/x/net from 0.37.0 to 0.38.0", "Closes #7"),
+				testPR(2, "dependabot[bot]", "dependabot/go_modules/golang.org/x/crypto-0.36.0", "Bump golang.org/x/crypto from 0.35.0 to 0.36.0", "Closes #7"),
+			},
+			want: [][]int{{1}, {2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := consolidate(tt.prs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, entry := range got {
+				var numbers []int
+				for _, p := range entry.allPRs() {
+					numbers = append(numbers, p.PR.GetNumber())
+				}
+				if !slices.Equal(numbers, tt.want[i]) {
+					t.Errorf("entry %d = %v, want %v", i, numbers, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// releaseStats aggregates numbers across every PR in the release.
+type releaseStats struct {
+	prs          int
+	filesChanged int
+	additions    int
+	deletions    int
+	timeToMerge  []time.Duration
+}
+
+// writeContributors renders the closing "Contributors" section: who authored and reviewed the
+// PRs in this release, who contributed for the first time, and aggregate change statistics.
+func writeContributors(prs []PRInfo) string {
+	if len(prs) == 0 {
+		return ""
+	}
+
+	authors := make(map[string]int)
+	reviewers := make(map[string]int)
+	var stats releaseStats
+
+	for _, info := range prs {
+		pr := info.PR
+		author := pr.GetUser().GetLogin()
+		if author != "" && !isBot(pr.GetUser()) {
+			authors[author]++
+		}
+
+		// Count each reviewer once per PR no matter how many reviews they left
+		seen := make(map[string]bool)
+		for _, review := range info.Reviews {
+			login := review.GetUser().GetLogin()
+			if login == "" || login == author || seen[login] || isBot(review.GetUser()) {
+				continue
+			}
+			seen[login] = true
+			reviewers[login]++
+		}
+
+		stats.prs++
+		stats.filesChanged += pr.GetChangedFiles()
+		stats.additions += pr.GetAdditions()
+		stats.deletions += pr.GetDeletions()
+		if pr.MergedAt != nil && pr.CreatedAt != nil {
+			stats.timeToMerge = append(stats.timeToMerge, pr.GetMergedAt().Sub(pr.GetCreatedAt().Time))
+		}
+	}
+
+	firstTimers := firstTimeContributors(prs, authors)
+
+	var b strings.Builder
+	b.WriteString("## Contributors\n\n")
+
+	if len(authors) > 0 {
+		fmt.Fprintf(&b, "**Authors:** %s\n\n", mentionList(authors))
+	}
+	if len(reviewers) > 0 {
+		fmt.Fprintf(&b, "**Reviewers:** %s\n\n", mentionList(reviewers))
+	}
+	if len(firstTimers) > 0 {
+		mentions := make([]string, len(firstTimers))
+		for i, login := range firstTimers {
+			mentions[i] = "@" + login
+		}
+		fmt.Fprintf(&b, "🎉 **First-time contributors:** %s — welcome!\n\n", strings.Join(mentions, ", "))
+	}
+
+	b.WriteString("| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| **Pull requests** | %d |\n", stats.prs)
+	fmt.Fprintf(&b, "| **Files changed** | %d |\n", stats.filesChanged)
+	fmt.Fprintf(&b, "| **Lines added** | +%d |\n", stats.additions)
+	fmt.Fprintf(&b, "| **Lines removed** | -%d |\n", stats.deletions)
+	if median, ok := medianDuration(stats.timeToMerge); ok {
+		fmt.Fprintf(&b, "| **Median time to merge** | %s |\n", formatDuration(median))
+	}
+	b.WriteString("\n")
+
+	slog.Info("generated contributors section", "authors", len(authors), "reviewers", len(reviewers), "firstTimers", len(firstTimers))
+	return b.String()
+}
+
+// firstTimeContributors returns, sorted, the authors GitHub marks as first-time contributors on
+// one of their PRs or reviews in this release. Only the PRs and reviews already fetched are
+// looked at, so an author whose association has since moved on isn't counted.
+func firstTimeContributors(prs []PRInfo, authors map[string]int) []string {
+	firstTime := func(association string) bool {
+		return association == "FIRST_TIMER" || association == "FIRST_TIME_CONTRIBUTOR"
+	}
+	found := make(map[string]bool)
+	for _, info := range prs {
+		if firstTime(info.PR.GetAuthorAssociation()) {
+			found[info.PR.GetUser().GetLogin()] = true
+		}
+		for _, review := range info.Reviews {
+			if firstTime(review.GetAuthorAssociation()) {
+				found[review.GetUser().GetLogin()] = true
+			}
+		}
+	}
+
+	var firstTimers []string
+	for author := range authors {
+		if found[author] {
+			firstTimers = append(firstTimers, author)
+		}
+	}
+	sort.Strings(firstTimers)
+	return firstTimers
+}
+
+// isBot reports whether a GitHub user is an app or bot account.
+func isBot(user *github.User) bool {
+	return user.GetType() == "Bot" || strings.HasSuffix(user.GetLogin(), "[bot]")
+}
+
+// mentionList renders logins as @-mentions, most active first.
+func mentionList(counts map[string]int) string {
+	logins := make([]string, 0, len(counts))
+	for login := range counts {
+		logins = append(logins, login)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if counts[logins[i]] != counts[logins[j]] {
+			return counts[logins[i]] > counts[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+
+	mentions := make([]string, len(logins))
+	for i, login := range logins {
+		mentions[i] = fmt.Sprintf("@%s (%d)", login, counts[login])
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// medianDuration returns the median of ds, or false if ds is empty.
+func medianDuration(ds []time.Duration) (time.Duration, bool) {
+	if len(ds) == 0 {
+		return 0, false
+	}
+	sorted := slices.Clone(ds)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2, true
+	}
+	return sorted[mid], true
+}
+
+// formatDuration renders a duration in days and hours, or hours and minutes for short ones.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		days := int(d / (24 * time.Hour))
+		hours := int((d % (24 * time.Hour)) / time.Hour)
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d/time.Hour), int((d%time.Hour)/time.Minute))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func contributorPR(number int, author string, association string, reviewers ...string) PRInfo {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := PRInfo{PR: &github.PullRequest{
+		Number:            github.Ptr(number),
+		User:              &github.User{Login: github.Ptr(author)},
+		AuthorAssociation: github.Ptr(association),
+		ChangedFiles:      github.Ptr(2),
+		Additions:         github.Ptr(10),
+		Deletions:         github.Ptr(5),
+		CreatedAt:         &github.Timestamp{Time: created},
+		MergedAt:          &github.Timestamp{Time: created.Add(time.Duration(number) * time.Hour)},
+	}}
+	for _, r := range reviewers {
+		login, association, _ := strings.Cut(r, ":")
+		info.Reviews = append(info.Reviews, &github.PullRequestReview{
+			User:              &github.User{Login: github.Ptr(login)},
+			AuthorAssociation: github.Ptr(association),
+		})
+	}
+	return info
+}
+
+func TestFirstTimeContributors(t *testing.T) {
+	tests := []struct {
+		name string
+		prs  []PRInfo
+		want []string
+	}{
+		{
+			name: "first-time authors",
+			prs: []PRInfo{
+				contributorPR(1, "alice", "FIRST_TIME_CONTRIBUTOR"),
+				contributorPR(2, "bob", "FIRST_TIMER"),
+				contributorPR(3, "carol", "MEMBER"),
+			},
+			want: []string{"alice", "bob"},
+		},
+		{
+			name: "later PR no longer first-time",
+			prs: []PRInfo{
+				contributorPR(1, "alice", "FIRST_TIME_CONTRIBUTOR"),
+				contributorPR(2, "alice", "CONTRIBUTOR"),
+			},
+			want: []string{"alice"},
+		},
+		{
+			name: "first-time reviewer who also authored",
+			prs: []PRInfo{
+				contributorPR(1, "carol", "MEMBER", "dave:FIRST_TIME_CONTRIBUTOR"),
+				contributorPR(2, "dave", "CONTRIBUTOR"),
+			},
+			want: []string{"dave"},
+		},
+		{
+			name: "first-time reviewer who didn't author",
+			prs:  []PRInfo{contributorPR(1, "carol", "MEMBER", "erin:FIRST_TIMER")},
+		},
+		{
+			name: "returning contributors",
+			prs: []PRInfo{
+				contributorPR(1, "alice", "CONTRIBUTOR"),
+				contributorPR(2, "carol", "OWNER", "alice:CONTRIBUTOR"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authors := make(map[string]int)
+			for _, info := range tt.prs {
+				authors[info.PR.GetUser().GetLogin()]++
+			}
+			if got := firstTimeContributors(tt.prs, authors); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteContributors(t *testing.T) {
+	bot := contributorPR(4, "dependabot[bot]", "NONE")
+	bot.PR.User.Type = github.Ptr("Bot")
+	internal := contributorPR(5, "carol", "MEMBER")
+	internal.PR.Labels = []*github.Label{{Name: github.Ptr("internal")}}
+	prs := []PRInfo{
+		contributorPR(1, "alice", "FIRST_TIME_CONTRIBUTOR", "carol:MEMBER", "carol:MEMBER", "alice:FIRST_TIME_CONTRIBUTOR"),
+		contributorPR(2, "carol", "MEMBER", "bob:CONTRIBUTOR"),
+		contributorPR(3, "carol", "MEMBER", "dependabot[bot]:NONE"),
+		bot,
+		internal,
+	}
+
+	tests := []struct {
+		name     string
+		audience Audience
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "engineering sees every PR",
+			audience: engineeringAudience,
+			want: []string{
+				"**Authors:** @carol (3), @alice (1)",
+				"**Reviewers:** @bob (1), @carol (1)",
+				"**First-time contributors:** @alice",
+				"| **Pull requests** | 5 |",
+				"| **Lines added** | +50 |",
+				"| **Median time to merge** | 3h 0m |",
+			},
+			notWant: []string{"dependabot"},
+		},
+		{
+			name:     "customer stats leave out internal PRs",
+			audience: customerAudience,
+			want: []string{
+				"**Authors:** @carol (2), @alice (1)",
+				"| **Pull requests** | 4 |",
+				"| **Lines added** | +40 |",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := writeContributors(tt.audience.visible(prs))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("unexpected %q in:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// hunk is a single "@@" section of a file patch.
+type hunk struct {
+	file  string
+	index int // position within the file, used to restore the original order
+	text  string
+	score float64
+}
+
+// estimateTokens gives a rough token count. ~4 chars per token is close enough for code.
+func estimateTokens(s string) int {
+	return len(s) / 4
+}
+
+// cutAtRune returns at most the first n bytes of s, backing off to the start of a character so
+// a multi-byte one isn't split.
+func cutAtRune(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// isGeneratedFile reports whether a file is a lockfile, vendored dependency or generated source
+// whose diff would only crowd out the interesting changes.
+func isGeneratedFile(f *github.CommitFile) bool {
+	name := f.GetFilename()
+	switch path.Base(name) {
+	case "go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock", "poetry.lock":
+		return true
+	}
+
+	switch {
+	case strings.HasPrefix(name, "vendor/"),
+		strings.Contains(name, "/vendor/"),
+		strings.Contains(name, "node_modules/"),
+		strings.HasPrefix(name, "dist/"),
+		strings.HasPrefix(name, "bin/"),
+		strings.HasSuffix(name, ".pb.go"),
+		strings.HasSuffix(name, "_gen.go"),
+		strings.HasSuffix(name, ".gen.go"),
+		strings.Contains(name, ".generated."),
+		strings.HasSuffix(name, ".min.js"),
+		strings.HasSuffix(name, ".snap"):
+		return true
+	}
+
+	return strings.Contains(f.GetPatch(), "Code generated") && strings.Contains(f.GetPatch(), "DO NOT EDIT")
+}
+
+// isTestFile reports whether a file only contains tests or test fixtures.
+func isTestFile(name string) bool {
+	return strings.HasSuffix(name, "_test.go") ||
+		strings.Contains(name, ".test.") ||
+		strings.Contains(name, ".spec.") ||
+		strings.HasPrefix(name, "test/") ||
+		strings.HasPrefix(name, "regressions/") ||
+		strings.HasPrefix(name, "fake/") ||
+		strings.Contains(name, "/testdata/") ||
+		strings.Contains(name, "__tests__/")
+}
+
+// splitHunks breaks a unified patch into its "@@" sections.
+func splitHunks(file string, patch string) []hunk {
+	var hunks []hunk
+	var current []string
+
+	flush := func() {
+		if len(current) > 0 {
+			hunks = append(hunks, hunk{file: file, index: len(hunks), text: strings.Join(current, "\n")})
+			current = nil
+		}
+	}
+
+	for line := range strings.SplitSeq(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			flush()
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return hunks
+}
+
+// scoreHunk counts the non-trivial added and removed lines in a hunk. Large hunks are capped so
+// a single mechanical rewrite can't outrank several small, meaningful changes.
+func scoreHunk(text string) float64 {
+	changed := 0
+	for line := range strings.SplitSeq(text, "\n") {
+		if len(line) == 0 || (line[0] != '+' && line[0] != '-') {
+			continue
+		}
+		body := strings.TrimSpace(line[1:])
+		if body == "" || body == "{" || body == "}" || body == ")" {
+			continue
+		}
+		changed++
+	}
+	return float64(min(changed, 40))
+}
+
+// buildDiffExcerpt picks the most informative hunks across the PR's files, preferring source over
+// tests and skipping generated files entirely, until the token budget is spent.
+func buildDiffExcerpt(files []*github.CommitFile, budget int) string {
+	if budget <= 0 {
+		return ""
+	}
+
+	var candidates []hunk
+	for _, f := range files {
+		if f.GetPatch() == "" || isGeneratedFile(f) {
+			continue
+		}
+
+		weight := 1.0
+		if isTestFile(f.GetFilename()) {
+			weight = 0.3
+		}
+
+		for _, h := range splitHunks(f.GetFilename(), f.GetPatch()) {
+			h.score = scoreHunk(h.text) * weight
+			if h.score > 0 {
+				candidates = append(candidates, h)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	remaining := budget
+	chosen := make(map[string][]hunk)
+	for _, h := range candidates {
+		cost := estimateTokens(h.text)
+		if cost > remaining {
+			// Keep the head of an oversized hunk if there's still a useful amount of budget left
+			if remaining < 200 {
+				continue
+			}
+			h.text = cutAtRune(h.text, remaining*4) + "\n... (hunk truncated)"
+			cost = remaining
+		}
+		chosen[h.file] = append(chosen[h.file], h)
+		remaining -= cost
+		if remaining <= 0 {
+			break
+		}
+	}
+
+	if len(chosen) == 0 {
+		return ""
+	}
+
+	// Emit files in the PR's order and hunks in their original order within each file
+	var b strings.Builder
+	for _, f := range files {
+		hunks, ok := chosen[f.GetFilename()]
+		if !ok {
+			continue
+		}
+		sort.Slice(hunks, func(i, j int) bool { return hunks[i].index < hunks[j].index })
+
+		fmt.Fprintf(&b, "```diff\n--- %s\n", f.GetFilename())
+		for _, h := range hunks {
+			b.WriteString(h.text)
+			b.WriteString("\n")
+		}
+		b.WriteString("```\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestCutAtRune(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 3, "hel"},
+		{"hello", 10, "hello"},
+		{"héllo", 2, "h"}, // é is two bytes
+		{"héllo", 3, "hé"},
+		{"日本語", 4, "日"},
+		{"日本語", 2, ""},
+		{"", 5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := cutAtRune(tt.s, tt.n); got != tt.want {
+				t.Errorf("cutAtRune(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func commitFile(name string, patch string) *github.CommitFile {
+	return &github.CommitFile{Filename: github.Ptr(name), Patch: github.Ptr(patch)}
+}
+
+func TestBuildDiffExcerpt(t *testing.T) {
+	source := "@@ -1,2 +1,2 @@\n-old := compute()\n+value := computeAll()\n+return value"
+	tests := []struct {
+		name    string
+		files   []*github.CommitFile
+		budget  int
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "skips generated files",
+			files:   []*github.CommitFile{commitFile("go.sum", "@@ -1 +1 @@\n-a v1 h1:x\n+a v2 h1:y"), commitFile("api/handler.go", source)},
+			budget:  1000,
+			want:    []string{"--- api/handler.go", "+value := computeAll()"},
+			notWant: []string{"go.sum"},
+		},
+		{
+			name:    "source before tests when the budget is tight",
+			files:   []*github.CommitFile{commitFile("api/handler_test.go", source), commitFile("api/handler.go", source)},
+			budget:  20,
+			want:    []string{"--- api/handler.go"},
+			notWant: []string{"handler_test.go"},
+		},
+		{
+			name:   "oversized hunk of multi-byte text is cut on a character",
+			files:  []*github.CommitFile{commitFile("web/i18n/ja.json", "@@ -1 +1,300 @@\n"+strings.Repeat("+\"翻訳されたテキスト\"\n", 300))},
+			budget: 201,
+			want:   []string{"--- web/i18n/ja.json", "... (hunk truncated)"},
+		},
+		{
+			name:   "no budget",
+			files:  []*github.CommitFile{commitFile("api/handler.go", source)},
+			budget: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildDiffExcerpt(tt.files, tt.budget)
+			if !utf8.ValidString(got) {
+				t.Errorf("excerpt is not valid UTF-8: %q", got)
+			}
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("got %q, want no excerpt", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("unexpected %q in:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
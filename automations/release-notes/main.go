@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,11 +20,38 @@ import (
 
 type ReleaseNotes struct {
 	github *github.Client
+
+	components *Components
+
+	// diffBudget is the approximate number of tokens of patch excerpt included per PR summary
+	diffBudget int
+	// groupSummaries adds a short overview to component groups with more than one entry
+	groupSummaries bool
 }
 
+const defaultDiffBudget = 4000
+
 func NewReleaseNotes() *ReleaseNotes {
+	diffBudget := defaultDiffBudget
+	if v := os.Getenv("DIFF_TOKEN_BUDGET"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			slog.Warn("invalid DIFF_TOKEN_BUDGET, using default", "value", v, "default", defaultDiffBudget)
+		} else {
+			diffBudget = n
+		}
+	}
+
+	var extraInternal []string
+	if v := os.Getenv("INTERNAL_COMPONENTS"); v != "" {
+		extraInternal = strings.Split(v, ",")
+	}
+
 	return &ReleaseNotes{
-		github: github.NewClient(nil).WithAuthToken(os.Getenv("GITHUB_TOKEN")),
+		github:         github.NewClient(nil).WithAuthToken(os.Getenv("GITHUB_TOKEN")),
+		components:     loadComponents(workspaceRoot(), extraInternal),
+		diffBudget:     diffBudget,
+		groupSummaries: os.Getenv("GROUP_SUMMARIES") != "false",
 	}
 }
 
@@ -84,9 +112,22 @@ func (rn *ReleaseNotes) getPRs(ctx context.Context) ([]PRInfo, error) {
 			continue
 		}
 
+		// Fetch the file list and reviews once here so every audience can share them
+		files, err := rn.getPRFiles(ctx, num)
+		if err != nil {
+			slog.Warn("error fetching PR files", "pr", num, "err", err)
+		}
+
+		reviews, err := rn.getPRReviews(ctx, num)
+		if err != nil {
+			slog.Warn("error fetching PR reviews", "pr", num, "err", err)
+		}
+
 		infos = append(infos, PRInfo{
-			PR:    pr,
-			Issue: issue,
+			PR:      pr,
+			Issue:   issue,
+			Files:   files,
+			Reviews: reviews,
 		})
 
 		slog.Info("found PR for release notes", "pr", num, "title", pr.GetTitle(), "issue", issue.GetHTMLURL())
@@ -96,8 +137,13 @@ func (rn *ReleaseNotes) getPRs(ctx context.Context) ([]PRInfo, error) {
 }
 
 type PRInfo struct {
-	PR    *github.PullRequest
-	Issue *github.Issue
+	PR      *github.PullRequest
+	Issue   *github.Issue
+	Files   []*github.CommitFile
+	Reviews []*github.PullRequestReview
+
+	// Related holds other PRs that were consolidated into this entry
+	Related []PRInfo
 }
 
 type releaseNotesInput struct {
@@ -126,61 +172,68 @@ type GitHubModelsResponse struct {
 
 const modelsURL = "https://models.github.ai/inference/chat/completions"
 
-func (rn *ReleaseNotes) generatePRSummary(ctx context.Context, info PRInfo) string {
-	// Get PR diff/patch
+// getPRFiles lists every file changed by a PR, following pagination.
+func (rn *ReleaseNotes) getPRFiles(ctx context.Context, num int) ([]*github.CommitFile, error) {
+	var all []*github.CommitFile
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := rn.github.PullRequests.ListFiles(ctx, "sendauth", "web", num, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing files for PR #%d: %w", num, err)
+		}
+		all = append(all, files...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// getPRReviews lists every review submitted on a PR, following pagination.
+func (rn *ReleaseNotes) getPRReviews(ctx context.Context, num int) ([]*github.PullRequestReview, error) {
+	var all []*github.PullRequestReview
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := rn.github.PullRequests.ListReviews(ctx, "sendauth", "web", num, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing reviews for PR #%d: %w", num, err)
+		}
+		all = append(all, reviews...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+func (rn *ReleaseNotes) generatePRSummary(ctx context.Context, audience Audience, info PRInfo) string {
 	patch := info.PR.GetBody()
 
-	// Get PR files to understand changes
-	files, _, err := rn.github.PullRequests.ListFiles(ctx, "sendauth", "web", info.PR.GetNumber(), &github.ListOptions{PerPage: 100})
-	if err != nil {
-		slog.Warn("error fetching PR files", "pr", info.PR.GetNumber(), "err", err)
-	} else {
+	files := rn.components.visibleFiles(info.allFiles(), audience.IncludeInternal)
+	if len(files) > 0 {
 		patch += "\n\nFiles changed:\n"
 		for _, file := range files {
 			if file.Filename != nil {
 				patch += fmt.Sprintf("- %s (+%d -%d)\n", file.GetFilename(), file.GetAdditions(), file.GetDeletions())
 			}
 		}
+
+		if excerpt := buildDiffExcerpt(files, rn.diffBudget); excerpt != "" {
+			patch += "\nMost relevant changes:\n" + excerpt
+		}
+	}
+
+	// Consolidated entries describe every PR so the model writes one summary for the whole change
+	if len(info.Related) > 0 {
+		patch += "\nThis change was delivered across several pull requests. Write one summary covering all of them.\n"
+		for _, related := range info.Related {
+			patch += fmt.Sprintf("\nRelated PR #%d: %s\n%s\n", related.PR.GetNumber(), related.PR.GetTitle(), related.PR.GetBody())
+		}
 	}
 
-	// Build the prompt for the AI
-	systemPrompt := `You are a technical writer creating release notes. Provide a concise, user-focused summary (1-2 sentences) of what changed and why it matters. Focus on the impact to users, not implementation details.
-	
-	Do not mention the ops view or core config editor. Those are internal to SendAuth staff.
-
-	SendAuth enables users to quickly authenticate each other using passkeys that are established out of normal authentication protocols. This means that if someone calls on the phone and says they're Bob, the person answering the phone can send them an authentication request and Bob must verify his identity with a passkey.
-
-	The top-level directories represent Go modules, except the web directory which houses the frontend that's React/Typescript. More details:
-	* api - contains serialization logic for API request->app and app->API reply
-	* app - contains the main runloops for the SendAuth webapp
-	* asynqmon - ignore; this is for SendAuth only
-	* authn - authentication types
-	* authz - authorization types
-	* automation - ignore; this is for SendAuth only
-	* bin - ignore; compiled output
-	* clients - Clients of external services, like Dynamo or Postgres or Redis
-	* cmd - app entrypoints
-	* controllers - API request handlers
-	* dao - persistence layer
-	* data - main POGOs for SendAuth. Everything is converted to data when incoming, and then converted to some other form for outgoing (eg, API or DTO)
-	* deploy - ignore; this is for SendAuth only
-	* fake - ignore, this is for testing only
-	* internal - ignore; this is for deployment environment config
-	* log - ignore; this is logging config
-	* middleware - HTTP middleware for the webapp
-	* models - Application domain model logic
-	* public - ignore; static assets
-	* regressions - ignore; this is for testing only
-	* server - contains the app entrypoints
-	* service - external service integration layer (eg, sending emails or SMS)
-	* slack - Slack integration logic
-	* tasks - holds asynchronous event firing and handlers
-	* templates - hold static HTML pages for errors
-	* test - ignore; testing utilities
-	* types - shared types that aren't data
-	* web - the React/Typescript frontend
-	* ws - websocket connection logic
-	`
+	systemPrompt := audience.SystemPrompt + "\n\n" + productContext(audience.IncludeInternal)
 
 	userPrompt := fmt.Sprintf(`Please summarize this change for release notes.
 
@@ -189,17 +242,49 @@ Title: %s
 Description: %s
 
 PR Title: %s
+PR Author: %s
 PR Description:
 %s
 
-Provide a brief, clear summary suitable for customer-facing release notes.`,
+%s`,
 		info.Issue.GetNumber(),
 		info.Issue.GetTitle(),
 		info.Issue.GetBody(),
 		info.PR.GetTitle(),
-		patch)
+		info.PR.GetUser().GetLogin(),
+		patch,
+		audience.Instruction)
+
+	slog.Info("creating release notes for ticket", "audience", audience.Name, "issue", info.Issue.GetNumber(), "summary", info.Issue.GetTitle(), "prs", info.prRefs())
+
+	summary := rn.complete(ctx, systemPrompt, userPrompt)
+	if summary == "" {
+		slog.Warn("empty summary from AI, falling back to title")
+		return info.Issue.GetTitle()
+	}
+
+	return summary
+}
+
+// generateGroupSummary writes a one-sentence overview of the entries in a component group.
+func (rn *ReleaseNotes) generateGroupSummary(ctx context.Context, audience Audience, component string, summaries []string) string {
+	systemPrompt := audience.SystemPrompt + "\n\n" + productContext(audience.IncludeInternal)
+
+	userPrompt := fmt.Sprintf(`The following release note entries all touch the %s component:
+
+- %s
+
+Write a single sentence that summarizes the overall theme of these changes. Do not repeat the individual entries.`,
+		component,
+		strings.Join(summaries, "\n- "))
 
-	// Call GitHub Models API
+	slog.Info("creating group summary", "audience", audience.Name, "component", component, "entries", len(summaries))
+
+	return rn.complete(ctx, systemPrompt, userPrompt)
+}
+
+// complete sends a single system+user prompt to the GitHub Models API, returning "" on failure.
+func (rn *ReleaseNotes) complete(ctx context.Context, systemPrompt string, userPrompt string) string {
 	reqBody := GitHubModelsRequest{
 		Model: "openai/gpt-4o",
 		Messages: []GitHubModelsMessage{
@@ -211,27 +296,19 @@ Provide a brief, clear summary suitable for customer-facing release notes.`,
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		slog.Warn("error marshaling request", "err", err)
-		return info.Issue.GetTitle()
+		return ""
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, modelsURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		slog.Warn("error creating request", "err", err)
-		return info.Issue.GetTitle()
+		return ""
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+os.Getenv("GITHUB_TOKEN"))
 
-	slog.Info("creating release notes for ticket", "issue", info.Issue.GetNumber(), "summary", info.Issue.GetTitle(), "pr", info.PR.GetNumber())
-
-	summary := rn.fire(ctx, req)
-	if summary == "" {
-		slog.Warn("empty summary from AI, falling back to title")
-		return info.Issue.GetTitle()
-	}
-
-	return summary
+	return rn.fire(ctx, req)
 }
 
 func (rn *ReleaseNotes) fire(ctx context.Context, req *http.Request) string {
@@ -279,40 +356,90 @@ func (rn *ReleaseNotes) fire(ctx context.Context, req *http.Request) string {
 	return ""
 }
 
-func (rn *ReleaseNotes) writeReleaseNotes(ctx context.Context, input releaseNotesInput) (string, error) {
-	notes := ""
+// componentGroup holds the entries for one component, split by kind of change.
+type componentGroup struct {
+	name     string
+	sections [][]PRInfo
+}
 
-	if len(input.other) > 0 {
-		notes += "## Security Updates\n\n"
-		for _, item := range input.other {
-			summary := rn.generatePRSummary(ctx, item)
-			notes += fmt.Sprintf("- **#%d** - %s\n", item.Issue.GetNumber(), summary)
+func (rn *ReleaseNotes) writeReleaseNotes(ctx context.Context, audience Audience, input releaseNotesInput) (string, error) {
+	headings := []string{"Security Updates", "Bugfixes", "New Features and Improvements"}
+	kinds := [][]PRInfo{input.other, input.bugfixes, input.newFeatures}
+
+	// Group by the component each PR mostly touches. PRs that only touch internal components
+	// are dropped here for audiences that can't see them, without relying on the model.
+	groups := make(map[string]*componentGroup)
+	for kind, items := range kinds {
+		for _, item := range items {
+			if audience.Include != nil {
+				var ok bool
+				if item, ok = audience.Include(item); !ok {
+					continue
+				}
+			}
+			component := rn.components.primary(item, audience.IncludeInternal)
+			if component == "" {
+				slog.Info("skipping PR that only touches internal components", "audience", audience.Name, "pr", item.PR.GetNumber())
+				continue
+			}
+			g, ok := groups[component]
+			if !ok {
+				g = &componentGroup{name: component, sections: make([][]PRInfo, len(kinds))}
+				groups[component] = g
+			}
+			g.sections[kind] = append(g.sections[kind], item)
 		}
-		notes += "\n"
 	}
 
-	if len(input.bugfixes) > 0 {
-		notes += "## Bugfixes\n\n"
-		for _, item := range input.bugfixes {
-			summary := rn.generatePRSummary(ctx, item)
-			notes += fmt.Sprintf("- **#%d** - %s\n", item.Issue.GetNumber(), summary)
-		}
-		notes += "\n"
+	if len(groups) == 0 {
+		slog.Info("no entries for audience", "audience", audience.Name)
+		return "", nil
 	}
 
-	if len(input.newFeatures) > 0 {
-		notes += "## New Features and Improvements\n\n"
-		for _, item := range input.newFeatures {
-			summary := rn.generatePRSummary(ctx, item)
-			notes += fmt.Sprintf("- **#%d** - %s\n", item.Issue.GetNumber(), summary)
+	ordered := make([]*componentGroup, 0, len(groups))
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		ri, rj := componentRank(ordered[i].name), componentRank(ordered[j].name)
+		if ri != rj {
+			return ri < rj
 		}
-		notes += "\n"
+		return ordered[i].name < ordered[j].name
+	})
+
+	notes := fmt.Sprintf("# %s\n\n", audience.Title)
+
+	for _, g := range ordered {
+		var body string
+		var summaries []string
+		for kind, items := range g.sections {
+			if len(items) == 0 {
+				continue
+			}
+			body += fmt.Sprintf("**%s**\n\n", headings[kind])
+			for _, item := range items {
+				summary := rn.generatePRSummary(ctx, audience, item)
+				summaries = append(summaries, summary)
+				body += audience.Format(item, summary) + "\n"
+			}
+			body += "\n"
+		}
+
+		notes += fmt.Sprintf("## %s\n\n", displayName(g.name))
+		if rn.groupSummaries && len(summaries) > 1 {
+			if overview := rn.generateGroupSummary(ctx, audience, g.name, summaries); overview != "" {
+				notes += fmt.Sprintf("_%s_\n\n", overview)
+			}
+		}
+		notes += body
 	}
 
 	return notes, nil
 }
 
-func (rn *ReleaseNotes) generate(ctx context.Context) (string, error) {
+// generate gathers the PRs once and writes one set of notes per audience.
+func (rn *ReleaseNotes) generate(ctx context.Context, audiences []Audience) ([]string, error) {
 	var other []PRInfo
 	var bugfixes []PRInfo
 	var features []PRInfo
@@ -320,10 +447,11 @@ func (rn *ReleaseNotes) generate(ctx context.Context) (string, error) {
 	prs, err := rn.getPRs(ctx)
 	if err != nil {
 		slog.Error("error getting PRs for release notes", "err", err)
-		return "", fmt.Errorf("getting PRs: %w", err)
+		return nil, fmt.Errorf("getting PRs: %w", err)
 	}
 
-	for _, pr := range prs {
+	// Cluster PRs that are parts of the same change before summarising so each gets one entry
+	for _, pr := range consolidate(prs) {
 		switch {
 		case pr.Issue.Title == nil:
 			other = append(other, pr)
@@ -336,24 +464,38 @@ func (rn *ReleaseNotes) generate(ctx context.Context) (string, error) {
 
 	if len(other) == 0 && len(bugfixes) == 0 && len(features) == 0 {
 		slog.Info("no relevant tickets found for release notes")
-		return "", nil
+		return nil, nil
 	}
 
 	slog.Info("generating summaries", "other", len(other), "bugs", len(bugfixes), "features", len(features), "customer_requests", len(other))
 
-	notes, err := rn.writeReleaseNotes(ctx, releaseNotesInput{
+	input := releaseNotesInput{
 		other:       other,
 		bugfixes:    bugfixes,
 		newFeatures: features,
-	})
-	if err != nil {
-		slog.Error("error writing release notes", "err", err)
-		return "", fmt.Errorf("writing release notes: %w", err)
 	}
 
-	slog.Info("done generating release notes")
+	var variants []string
+	for _, audience := range audiences {
+		notes, err := rn.writeReleaseNotes(ctx, audience, input)
+		if err != nil {
+			slog.Error("error writing release notes", "audience", audience.Name, "err", err)
+			return nil, fmt.Errorf("writing %s release notes: %w", audience.Name, err)
+		}
+		if notes == "" {
+			continue
+		}
+		// Only the first set of notes gets the contributors section, counting the PRs its
+		// audience can see, one by one before they are consolidated
+		if len(variants) == 0 {
+			notes += writeContributors(audience.visible(prs))
+		}
+		variants = append(variants, notes)
+	}
+
+	slog.Info("done generating release notes", "variants", len(variants))
 
-	return notes, nil
+	return variants, nil
 }
 
 func (rn *ReleaseNotes) publish(ctx context.Context, notes string) error {
@@ -378,13 +520,20 @@ func (rn *ReleaseNotes) publish(ctx context.Context, notes string) error {
 func main() {
 	rn := NewReleaseNotes()
 	ctx := context.Background()
-	notes, err := rn.generate(ctx)
+	audiences, err := selectAudiences(os.Getenv("RELEASE_NOTES_AUDIENCES"))
 	if err != nil {
-		slog.Error("error generating release notes", "err", err)
+		slog.Error("invalid audiences", "err", err)
 		os.Exit(1)
 	}
-	if err := rn.publish(ctx, notes); err != nil {
-		slog.Error("error publishing release notes", "err", err)
+	variants, err := rn.generate(ctx, audiences)
+	if err != nil {
+		slog.Error("error generating release notes", "err", err)
 		os.Exit(1)
 	}
+	for _, notes := range variants {
+		if err := rn.publish(ctx, notes); err != nil {
+			slog.Error("error publishing release notes", "err", err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileRename moves a file, keeping its contents and executable bit.
+type FileRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// fileChanges is a fix resolved against the workspace: final contents for every added or changed
+// file, every deleted file, and every executable-bit change.
+type fileChanges struct {
+	files      map[string]string
+	deletes    map[string]bool
+	executable map[string]bool
+}
+
+func newFileChanges() *fileChanges {
+	return &fileChanges{
+		files:      make(map[string]string),
+		deletes:    make(map[string]bool),
+		executable: make(map[string]bool),
+	}
+}
+
+// isEmpty reports whether a proposed fix contains no changes of any kind.
+func (f *FixResult) isEmpty() bool {
+	return len(f.Files) == 0 && len(f.Edits) == 0 && len(f.Patches) == 0 &&
+		len(f.Deletes) == 0 && len(f.Renames) == 0 && len(f.Executable) == 0
+}
+
+// changedPaths returns every path a resolved fix touches.
+func (f *FixResult) changedPaths() []string {
+	var paths []string
+	for path := range f.Files {
+		paths = append(paths, path)
+	}
+	paths = append(paths, f.Deletes...)
+	for path := range f.Executable {
+		if _, ok := f.Files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// merge folds a later round's resolved changes into the accumulated fix, so that a file deleted
+// in one round and recreated in the next ends up as a plain change.
+func (f *FixResult) merge(changes *fileChanges) {
+	deleted := make(map[string]bool)
+	for _, path := range f.Deletes {
+		deleted[path] = true
+	}
+	for path, content := range changes.files {
+		f.Files[path] = content
+		delete(deleted, path)
+	}
+	for path := range changes.deletes {
+		delete(f.Files, path)
+		delete(f.Executable, path)
+		deleted[path] = true
+	}
+	for path, exec := range changes.executable {
+		if f.Executable == nil {
+			f.Executable = make(map[string]bool)
+		}
+		f.Executable[path] = exec
+	}
+
+	f.Deletes = f.Deletes[:0]
+	for path := range deleted {
+		f.Deletes = append(f.Deletes, path)
+	}
+}
+
+// isExecutable reports whether a workspace file has any execute bit set.
+func isExecutable(workspace string, path string) bool {
+	info, err := os.Stat(filepath.Join(workspace, path))
+	return err == nil && info.Mode()&0111 != 0
+}
+
+// checkSymlinks returns an error if path, or a directory on the way to it from the workspace, is
+// a symlink: following one could reach files outside the checkout. Checking stops at the first
+// part that doesn't exist yet.
+func checkSymlinks(workspace string, path string) error {
+	var parts []string
+	for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		parts = append(parts, p)
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		info, err := os.Lstat(filepath.Join(workspace, parts[i]))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", parts[i])
+		}
+	}
+	return nil
+}
+
+// writeChanges applies resolved changes to the workspace, recording originals in snapshot.
+// Parent directories of new files are created as needed. Paths inside .git or through a symlink
+// are refused.
+func writeChanges(workspace string, snapshot *workspaceSnapshot, changes *fileChanges) error {
+	for filePath, content := range changes.files {
+		cleanPath, ok := cleanRelPath(filePath)
+		if !ok {
+			slog.Warn("ignoring unsafe file path from AI", "path", filePath)
+			continue
+		}
+		if err := checkSymlinks(workspace, cleanPath); err != nil {
+			return fmt.Errorf("refusing to write %s: %w", cleanPath, err)
+		}
+
+		// Known placeholders were restored by the tool loop; anything left is one the model made up
+		if p := placeholderPattern.FindString(content); p != "" {
+			return fmt.Errorf("corrected file %s contains unresolved placeholder %s", cleanPath, p)
+		}
+
+		snapshot.save(cleanPath)
+		if err := snapshot.mkdirAll(filepath.Dir(cleanPath)); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", cleanPath, err)
+		}
+
+		fullPath := filepath.Join(workspace, cleanPath)
+		mode := os.FileMode(0644)
+		if isExecutable(workspace, cleanPath) {
+			mode = 0755
+		}
+		if err := os.WriteFile(fullPath, []byte(content), mode); err != nil {
+			return fmt.Errorf("writing corrected file %s: %w", cleanPath, err)
+		}
+		slog.Info("wrote corrected file", "path", cleanPath)
+	}
+
+	for path := range changes.deletes {
+		if _, ok := cleanRelPath(path); !ok {
+			return fmt.Errorf("refusing to delete %s: path must be relative and within the repository", path)
+		}
+		if err := checkSymlinks(workspace, path); err != nil {
+			return fmt.Errorf("refusing to delete %s: %w", path, err)
+		}
+		snapshot.save(path)
+		if err := os.Remove(filepath.Join(workspace, path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting %s: %w", path, err)
+		}
+		slog.Info("deleted file", "path", path)
+	}
+
+	for path, exec := range changes.executable {
+		if _, ok := cleanRelPath(path); !ok {
+			return fmt.Errorf("refusing to change the mode of %s: path must be relative and within the repository", path)
+		}
+		if err := checkSymlinks(workspace, path); err != nil {
+			return fmt.Errorf("refusing to change the mode of %s: %w", path, err)
+		}
+		snapshot.save(path)
+		mode := os.FileMode(0644)
+		if exec {
+			mode = 0755
+		}
+		if err := os.Chmod(filepath.Join(workspace, path), mode); err != nil {
+			return fmt.Errorf("changing mode of %s: %w", path, err)
+		}
+		slog.Info("changed file mode", "path", path, "executable", exec)
+	}
+
+	return nil
+}
+
+// resolveRenames records each rename as a delete of the old path plus an add of the new one,
+// carrying over the executable bit.
+func resolveRenames(workspace string, renames []FileRename, changes *fileChanges, current func(string) (string, bool)) []HunkFailure {
+	var failures []HunkFailure
+
+	for _, r := range renames {
+		from, okFrom := cleanRelPath(r.From)
+		to, okTo := cleanRelPath(r.To)
+		if !okFrom || !okTo {
+			failures = append(failures, HunkFailure{Path: r.From + " -> " + r.To, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		content, ok := current(from)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: from, Reason: "cannot rename a file that does not exist"})
+			continue
+		}
+		if _, exists := current(to); exists {
+			failures = append(failures, HunkFailure{Path: to, Reason: "rename target already exists"})
+			continue
+		}
+		changes.files[to] = content
+		delete(changes.files, from)
+		changes.deletes[from] = true
+		if isExecutable(workspace, from) {
+			changes.executable[to] = true
+		}
+	}
+
+	return failures
+}
+
+// resolveDeletesAndModes applies deletes and executable-bit changes on top of the content
+// changes already resolved into changes.
+func resolveDeletesAndModes(deletes []string, executable map[string]bool, changes *fileChanges, current func(string) (string, bool)) []HunkFailure {
+	var failures []HunkFailure
+
+	for _, filePath := range deletes {
+		path, ok := cleanRelPath(filePath)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: filePath, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		if _, ok := current(path); !ok {
+			failures = append(failures, HunkFailure{Path: path, Reason: "cannot delete a file that does not exist"})
+			continue
+		}
+		delete(changes.files, path)
+		changes.deletes[path] = true
+	}
+
+	for filePath, exec := range executable {
+		path, ok := cleanRelPath(filePath)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: filePath, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		if _, ok := current(path); !ok || changes.deletes[path] {
+			failures = append(failures, HunkFailure{Path: path, Reason: "cannot change the mode of a file that does not exist"})
+			continue
+		}
+		changes.executable[path] = exec
+	}
+
+	return failures
+}
+
+// describeChanges summarises a resolved fix for logs and the PR body, e.g. "2 changed, 1 deleted".
+func describeChanges(f *FixResult) string {
+	var parts []string
+	if n := len(f.Files); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", n))
+	}
+	if n := len(f.Deletes); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d deleted", n))
+	}
+	modeOnly := 0
+	for path := range f.Executable {
+		if _, ok := f.Files[path]; !ok {
+			modeOnly++
+		}
+	}
+	if modeOnly > 0 {
+		parts = append(parts, fmt.Sprintf("%d mode change(s)", modeOnly))
+	}
+	return strings.Join(parts, ", ")
+}
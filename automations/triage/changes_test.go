@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanRelPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"pkg/server.go", "pkg/server.go", true},
+		{"./pkg/../cmd/main.go", "cmd/main.go", true},
+		{".github/workflows/ci.yml", ".github/workflows/ci.yml", true},
+		{".gitignore", ".gitignore", true},
+		{"../outside.go", "", false},
+		{"pkg/../../outside.go", "", false},
+		{"/etc/passwd", "", false},
+		{".git/config", "", false},
+		{".git/hooks/pre-commit", "", false},
+		{"./.git/config", "", false},
+		{"pkg/../.git/HEAD", "", false},
+		{".GIT/config", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := cleanRelPath(tt.path)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("cleanRelPath(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWriteChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes func(*fileChanges)
+		wantErr bool
+		want    map[string]string // workspace file contents afterwards, "" for absent
+		outside string            // expected content of the file outside the workspace
+	}{
+		{
+			name: "write, create and delete",
+			changes: func(c *fileChanges) {
+				c.files["src/app.go"] = "new"
+				c.files["src/new/file.go"] = "created"
+				c.deletes["src/old.go"] = true
+			},
+			want:    map[string]string{"src/app.go": "new", "src/new/file.go": "created", "src/old.go": ""},
+			outside: "secret",
+		},
+		{
+			name:    "git directory is never written",
+			changes: func(c *fileChanges) { c.files[".git/config"] = "[diff]\n\texternal = evil" },
+			want:    map[string]string{".git/config": "[core]"},
+			outside: "secret",
+		},
+		{
+			name:    "git directory is never deleted",
+			changes: func(c *fileChanges) { c.deletes[".git/config"] = true },
+			wantErr: true,
+			want:    map[string]string{".git/config": "[core]"},
+			outside: "secret",
+		},
+		{
+			name:    "write through a symlinked directory",
+			changes: func(c *fileChanges) { c.files["linkdir/target.txt"] = "overwritten" },
+			wantErr: true,
+			outside: "secret",
+		},
+		{
+			name:    "write to a symlinked file",
+			changes: func(c *fileChanges) { c.files["linkfile"] = "overwritten" },
+			wantErr: true,
+			outside: "secret",
+		},
+		{
+			name:    "delete through a symlinked directory",
+			changes: func(c *fileChanges) { c.deletes["linkdir/target.txt"] = true },
+			wantErr: true,
+			outside: "secret",
+		},
+		{
+			name:    "mode change on a symlinked file",
+			changes: func(c *fileChanges) { c.executable["linkfile"] = true },
+			wantErr: true,
+			outside: "secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			workspace := filepath.Join(root, "workspace")
+			outsideDir := filepath.Join(root, "outside")
+			for path, content := range map[string]string{
+				"workspace/src/app.go":  "old",
+				"workspace/src/old.go":  "old",
+				"workspace/.git/config": "[core]",
+				"outside/target.txt":    "secret",
+			} {
+				full := filepath.Join(root, path)
+				if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(outsideDir, filepath.Join(workspace, "linkdir")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(outsideDir, "target.txt"), filepath.Join(workspace, "linkfile")); err != nil {
+				t.Fatal(err)
+			}
+
+			changes := newFileChanges()
+			tt.changes(changes)
+			err := writeChanges(workspace, newWorkspaceSnapshot(workspace), changes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for path, want := range tt.want {
+				data, err := os.ReadFile(filepath.Join(workspace, path))
+				if got := string(data); got != want || (want == "" && err == nil) {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+			if data, _ := os.ReadFile(filepath.Join(outsideDir, "target.txt")); string(data) != tt.outside {
+				t.Errorf("file outside the workspace = %q, want %q", data, tt.outside)
+			}
+			if info, err := os.Stat(filepath.Join(outsideDir, "target.txt")); err == nil && info.Mode()&0o111 != 0 {
+				t.Errorf("file outside the workspace became executable")
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	checkRunName = "CI Triage"
+	// annotationsPerRequest is the most annotations the Checks API accepts in one request.
+	annotationsPerRequest = 50
+	// maxAnnotations caps the annotations on one check run; past this they stop being readable.
+	maxAnnotations = 150
+	// maxCheckText stays under the Checks API's 65535 character limit for summary and text.
+	maxCheckText = 60_000
+)
+
+var (
+	// compilerLocation matches "path:line[:col]: message", the format of Go, gcc, rustc's short
+	// output, ESLint's unix formatter, ruff, mypy and most linters.
+	compilerLocation = regexp.MustCompile(`^\s*(?:\./)?([\w@.+/-]+\.[A-Za-z]\w*):(\d+)(?::(\d+))?:?\s+(.+)$`)
+	// tscLocation matches TypeScript's "path(line,col): error TS1234: message".
+	tscLocation = regexp.MustCompile(`^\s*([\w@.+/-]+\.[A-Za-z]\w*)\((\d+),(\d+)\):\s+(.+)$`)
+	// warningMessage marks a log location as a warning rather than an error.
+	warningMessage = regexp.MustCompile(`(?i)^\s*warning\b`)
+)
+
+// CreateCheckRun records the diagnosis as a "CI Triage" check run on the failing commit. Test
+// failures, file:line references from the failed jobs' logs and the affected files become line
+// annotations, so the diagnosis shows up inline in the pull request's diff view. The check is
+// neutral: the failing CI jobs already block the merge.
+func (t *Triage) CreateCheckRun(ctx context.Context, triageResult *TriageResult, prURL string) error {
+	if os.Getenv("CHECK_RUN") == "false" {
+		slog.Info("check run disabled, skipping")
+		return nil
+	}
+
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return fmt.Errorf("getting workflow run: %w", err)
+	}
+
+	annotations := t.checkAnnotations(ctx, triageResult)
+	if len(annotations) > maxAnnotations {
+		slog.Info("too many annotations, keeping the first ones", "found", len(annotations), "kept", maxAnnotations)
+		annotations = annotations[:maxAnnotations]
+	}
+	first := annotations[:min(len(annotations), annotationsPerRequest)]
+
+	output := t.checkOutput(triageResult, prURL)
+	output.Annotations = first
+	runURL := fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d", t.owner, t.repo, t.runID)
+	check, _, err := t.github.Checks.CreateCheckRun(ctx, t.owner, t.repo, github.CreateCheckRunOptions{
+		Name:        checkRunName,
+		HeadSHA:     run.GetHeadSHA(),
+		DetailsURL:  github.Ptr(runURL),
+		ExternalID:  github.Ptr(strconv.FormatInt(t.runID, 10)),
+		Status:      github.Ptr("completed"),
+		Conclusion:  github.Ptr("neutral"),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output:      output,
+	})
+	if err != nil {
+		return fmt.Errorf("creating check run (the token needs checks: write): %w", err)
+	}
+
+	// Annotations beyond the first batch are appended by updating the run with the same output
+	for start := len(first); start < len(annotations); start += annotationsPerRequest {
+		batch := t.checkOutput(triageResult, prURL)
+		batch.Annotations = annotations[start:min(len(annotations), start+annotationsPerRequest)]
+		_, _, err := t.github.Checks.UpdateCheckRun(ctx, t.owner, t.repo, check.GetID(), github.UpdateCheckRunOptions{
+			Name:   checkRunName,
+			Output: batch,
+		})
+		if err != nil {
+			return fmt.Errorf("adding annotations to check run: %w", err)
+		}
+	}
+
+	slog.Info("created check run", "id", check.GetID(), "sha", run.GetHeadSHA(), "annotations", len(annotations))
+	return nil
+}
+
+// checkOutput renders the check run's title, summary and details.
+func (t *Triage) checkOutput(triageResult *TriageResult, prURL string) *github.CheckRunOutput {
+	title := fmt.Sprintf("%s failure (%s confidence)", triageResult.Category, triageResult.Confidence)
+
+	var summary strings.Builder
+	summary.WriteString(fmt.Sprintf("| | |\n|---|---|\n| **Category** | `%s` |\n| **Confidence** | %s |\n| **Auto-fixable** | %v |\n\n",
+		triageResult.Category,
+		triageResult.Confidence,
+		triageResult.Fixable,
+	))
+	if triageResult.Rule != "" {
+		summary.WriteString(fmt.Sprintf("📚 Matched known-failure rule `%s`\n\n", triageResult.Rule))
+	}
+	if triageResult.Escalation != "" {
+		summary.WriteString(fmt.Sprintf("⬆️ Diagnosed by `%s` after escalating from `%s`\n\n", triageResult.Model, triageResult.Escalation))
+	}
+	summary.WriteString(fmt.Sprintf("### Root Cause\n\n%s\n", triageResult.RootCause))
+	if prURL != "" {
+		summary.WriteString(fmt.Sprintf("\n%s\n", t.fixLink(prURL)))
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("### Suggested Fix\n\n%s\n", triageResult.SuggestedFix))
+	if len(triageResult.Evidence) > 0 {
+		text.WriteString("\n### Flakiness Evidence\n\n")
+		for _, e := range triageResult.Evidence {
+			text.WriteString(fmt.Sprintf("- %s\n", e))
+		}
+	}
+	if len(t.testFailures) > 0 {
+		text.WriteString("\n### Failing Tests\n\n")
+		text.WriteString(failureTable(t.testFailures))
+	}
+
+	return &github.CheckRunOutput{
+		Title:   github.Ptr(title),
+		Summary: github.Ptr(truncateResult(summary.String(), maxCheckText)),
+		Text:    github.Ptr(truncateResult(text.String(), maxCheckText)),
+	}
+}
+
+// checkAnnotations collects line annotations, most specific first: parsed test failures, then
+// error locations in the failed jobs' logs, then affected files the model named. Only paths that
+// exist in the checkout are kept, and each location is annotated once.
+func (t *Triage) checkAnnotations(ctx context.Context, triageResult *TriageResult) []*github.CheckRunAnnotation {
+	var annotations []*github.CheckRunAnnotation
+	seen := make(map[string]bool)
+	annotatedFiles := make(map[string]bool)
+	add := func(path string, line int, level string, title string, message string) {
+		path, ok := repoPath(path)
+		if !ok {
+			return
+		}
+		line = max(line, 1)
+		key := fmt.Sprintf("%s:%d", path, line)
+		if seen[key] || strings.TrimSpace(message) == "" {
+			return
+		}
+		seen[key] = true
+		annotatedFiles[path] = true
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.Ptr(path),
+			StartLine:       github.Ptr(line),
+			EndLine:         github.Ptr(line),
+			AnnotationLevel: github.Ptr(level),
+			Title:           github.Ptr(title),
+			Message:         github.Ptr(truncateResult(message, 4_000)),
+		})
+	}
+
+	for _, f := range t.TestFailures(ctx) {
+		if f.File == "" || f.Line == 0 {
+			continue
+		}
+		message := f.Message
+		if message == "" {
+			message = "Test failed"
+		}
+		add(f.File, f.Line, "failure", fmt.Sprintf("%s failed", f.Test), message)
+	}
+
+	for _, loc := range t.logLocations(ctx) {
+		add(loc.path, loc.line, loc.level, fmt.Sprintf("%s (%s)", strings.ToUpper(loc.level[:1])+loc.level[1:], loc.job), loc.message)
+	}
+
+	for _, f := range triageResult.AffectedFiles {
+		path, line := splitFileLine(f)
+		if clean, ok := repoPath(path); ok && annotatedFiles[clean] && line == 0 {
+			continue // the file already carries a more precise annotation
+		}
+		add(path, line, "notice", fmt.Sprintf("CI triage: %s", triageResult.Category), triageResult.RootCause)
+	}
+
+	return annotations
+}
+
+// logLocation is an error or warning location found in a failed job's log.
+type logLocation struct {
+	job     string
+	path    string
+	line    int
+	level   string // "failure" or "warning", as the Checks API names them
+	message string
+}
+
+// logLocations scans the failed jobs' logs for compiler and linter style file:line messages.
+func (t *Triage) logLocations(ctx context.Context) []logLocation {
+	jobs, err := t.failedJobs(ctx)
+	if err != nil {
+		slog.Warn("error listing failed jobs for annotations", "err", err)
+		return nil
+	}
+
+	var locations []logLocation
+	for _, job := range jobs {
+		content, err := t.downloadJobLogs(ctx, job.GetID())
+		if err != nil {
+			slog.Warn("error downloading logs for annotations", "job", job.GetName(), "err", err)
+			continue
+		}
+		for _, line := range stripLogTimestamps(content) {
+			m := compilerLocation.FindStringSubmatch(line)
+			if m == nil {
+				m = tscLocation.FindStringSubmatch(line)
+			}
+			if m == nil {
+				continue
+			}
+			n, _ := strconv.Atoi(m[2])
+			level := "failure"
+			if warningMessage.MatchString(m[4]) {
+				level = "warning"
+			}
+			locations = append(locations, logLocation{job: job.GetName(), path: m[1], line: n, level: level, message: m[4]})
+		}
+	}
+	return locations
+}
+
+// splitFileLine splits "path:line" as the model sometimes writes affected files. Line is 0 when
+// there is none.
+func splitFileLine(s string) (string, int) {
+	path, after, ok := strings.Cut(s, ":")
+	if !ok {
+		return s, 0
+	}
+	digits, _, _ := strings.Cut(after, ":")
+	line, err := strconv.Atoi(digits)
+	if err != nil {
+		return s, 0
+	}
+	return path, line
+}
+
+// runnerWorkspace matches a GitHub-hosted runner's checkout prefix, /home/runner/work/<repo>/<repo>/,
+// which logs from other jobs contain even when this job's workspace differs.
+var runnerWorkspace = regexp.MustCompile(`^/home/runner/work/[^/]+/[^/]+/`)
+
+// repoPath turns a path from a log or the model into one relative to the repository root, and
+// reports whether that file exists in the checkout. Without a checkout every relative path is
+// accepted, since there is nothing to check against.
+func repoPath(path string) (string, bool) {
+	workspace := workspaceDir()
+	if abs, err := filepath.Abs(workspace); err == nil {
+		path = strings.TrimPrefix(path, abs+string(filepath.Separator))
+	}
+	path = runnerWorkspace.ReplaceAllString(path, "")
+	path, ok := cleanRelPath(path)
+	if !ok || path == "." {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(workspace, ".git")); err != nil {
+		return filepath.ToSlash(path), true
+	}
+	if info, err := os.Stat(filepath.Join(workspace, path)); err != nil || info.IsDir() {
+		return "", false
+	}
+	return filepath.ToSlash(path), true
+}
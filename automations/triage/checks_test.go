@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestSplitFileLine(t *testing.T) {
+	tests := []struct {
+		s    string
+		path string
+		line int
+	}{
+		{"pkg/server.go", "pkg/server.go", 0},
+		{"pkg/server.go:42", "pkg/server.go", 42},
+		{"pkg/server.go:42:7", "pkg/server.go", 42},
+		{"C:notaline", "C:notaline", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if path, line := splitFileLine(tt.s); path != tt.path || line != tt.line {
+				t.Errorf("splitFileLine(%q) = %q, %d, want %q, %d", tt.s, path, line, tt.path, tt.line)
+			}
+		})
+	}
+}
+
+// checkWorkspace creates a checkout with a few source files and makes it the workspace.
+func checkWorkspace(t *testing.T) string {
+	t.Helper()
+	workspace := t.TempDir()
+	for _, path := range []string{".git/HEAD", "pkg/server.go", "pkg/server_test.go", "web/src/app.ts"} {
+		full := filepath.Join(workspace, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GITHUB_WORKSPACE", workspace)
+	return workspace
+}
+
+func TestRepoPath(t *testing.T) {
+	workspace := checkWorkspace(t)
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"pkg/server.go", "pkg/server.go", true},
+		{"./pkg/server.go", "pkg/server.go", true},
+		{filepath.Join(workspace, "pkg/server.go"), "pkg/server.go", true},
+		{"/home/runner/work/app/app/web/src/app.ts", "web/src/app.ts", true},
+		{"pkg/missing.go", "", false},
+		{"pkg", "", false},
+		{"../etc/passwd", "", false},
+		{"/usr/local/go/src/testing/testing.go", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got, ok := repoPath(tt.path); got != tt.want || ok != tt.ok {
+				t.Errorf("repoPath(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	checkWorkspace(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"total_count":1,"jobs":[{"id":7,"name":"build","conclusion":"failure"}]}`))
+	}))
+	defer srv.Close()
+	client := github.NewClient(srv.Client())
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	logs := "2026-01-01T00:00:00.0000000Z ./pkg/server.go:10:2: undefined: handler\n" +
+		"2026-01-01T00:00:00.0000000Z web/src/app.ts(3,5): error TS2322: Type 'string' is not assignable\n" +
+		"2026-01-01T00:00:00.0000000Z pkg/server.go:20: warning: unused variable\n" +
+		"2026-01-01T00:00:00.0000000Z /usr/local/go/src/runtime/panic.go:770: panic\n" +
+		"2026-01-01T00:00:00.0000000Z pkg/server_test.go:5: duplicate of the test failure\n"
+	triage := &Triage{
+		github: client, owner: "o", repo: "r", runID: 1,
+		logCache:           map[int64]string{7: logs},
+		testFailures:       []TestFailure{{Test: "TestServe", File: "pkg/server_test.go", Line: 5, Message: "got 500"}},
+		testFailuresLoaded: true,
+	}
+	result := &TriageResult{Category: "code", RootCause: "handler was renamed", AffectedFiles: []string{"pkg/server.go", "web/src/app.ts:9", "docs/missing.md"}}
+
+	want := []string{
+		"pkg/server_test.go:5 failure TestServe failed: got 500",
+		"pkg/server.go:10 failure Failure (build): undefined: handler",
+		"web/src/app.ts:3 failure Failure (build): error TS2322: Type 'string' is not assignable",
+		"pkg/server.go:20 warning Warning (build): warning: unused variable",
+		"web/src/app.ts:9 notice CI triage: code: handler was renamed",
+	}
+	got := triage.checkAnnotations(context.Background(), result)
+	if len(got) != len(want) {
+		t.Errorf("got %d annotations, want %d", len(got), len(want))
+	}
+	for i, a := range got {
+		s := fmt.Sprintf("%s:%d %s %s: %s", a.GetPath(), a.GetStartLine(), a.GetAnnotationLevel(), a.GetTitle(), a.GetMessage())
+		if i >= len(want) || s != want[i] {
+			t.Errorf("annotation %d = %q", i, s)
+		} else if a.GetEndLine() != a.GetStartLine() {
+			t.Errorf("annotation %d spans lines %d-%d", i, a.GetStartLine(), a.GetEndLine())
+		}
+	}
+}
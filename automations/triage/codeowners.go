@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// codeownersLocations are where GitHub looks for CODEOWNERS, in the order it looks.
+var codeownersLocations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeownersRule is one CODEOWNERS line: a gitignore-style pattern and its owners.
+type codeownersRule struct {
+	pattern string
+	re      *regexp.Regexp
+	owners  []string
+}
+
+// CodeOwners maps paths to owners the way GitHub does: the last matching rule wins.
+type CodeOwners struct {
+	path  string
+	rules []codeownersRule
+}
+
+// loadCodeOwners reads the repository's CODEOWNERS file. It returns nil when there is none.
+func loadCodeOwners() (*CodeOwners, error) {
+	for _, location := range codeownersLocations {
+		path := filepath.Join(workspaceDir(), location)
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", location, err)
+		}
+		defer f.Close()
+
+		c := &CodeOwners{path: location}
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			re, err := pathPattern(fields[0])
+			if err != nil {
+				// GitHub ignores lines it can't parse, so one bad line shouldn't disable the rest
+				slog.Warn("skipping invalid CODEOWNERS pattern", "file", location, "line", line, "pattern", fields[0], "err", err)
+				continue
+			}
+			c.rules = append(c.rules, codeownersRule{pattern: fields[0], re: re, owners: fields[1:]})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", location, err)
+		}
+
+		slog.Info("loaded CODEOWNERS", "file", location, "rules", len(c.rules))
+		return c, nil
+	}
+	return nil, nil
+}
+
+// Owners returns the owners of a path, or nil if no rule assigns any.
+func (c *CodeOwners) Owners(path string) []string {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if c.rules[i].re.MatchString(path) {
+			return c.rules[i].owners
+		}
+	}
+	return nil
+}
+
+// affectedOwners returns the code owners of a result's affected files, in order of first
+// appearance.
+func (t *Triage) affectedOwners(triageResult *TriageResult) []string {
+	if t.codeowners == nil {
+		return nil
+	}
+	var owners []string
+	for _, f := range triageResult.AffectedFiles {
+		path, _ := splitFileLine(f)
+		if clean, ok := repoPath(path); ok {
+			path = clean
+		}
+		for _, owner := range t.codeowners.Owners(path) {
+			owners = appendUnique(owners, owner)
+		}
+	}
+	return owners
+}
+
+// slackMention turns a Slack handle from the owner map into message syntax: user IDs (U…, W…)
+// become <@ID>, user group IDs (S…) become <!subteam^ID>, and anything else is used as written.
+func slackMention(handle string) string {
+	switch {
+	case strings.HasPrefix(handle, "<"):
+		return handle
+	case slackID.MatchString(handle) && (handle[0] == 'U' || handle[0] == 'W'):
+		return "<@" + handle + ">"
+	case slackID.MatchString(handle) && handle[0] == 'S':
+		return "<!subteam^" + handle + ">"
+	default:
+		return handle
+	}
+}
+
+// slackID matches Slack user, workspace user and user group IDs.
+var slackID = regexp.MustCompile(`^[A-Z][A-Z0-9]{6,}$`)
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.js", "app.js", true},
+		{"*.js", "web/src/app.js", true},
+		{"*.js", "app.jsx", false},
+		{"docs/", "docs/guide.md", true},
+		{"docs/", "web/docs/guide.md", true}, // a trailing slash alone doesn't anchor the pattern
+		{"docs", "web/docs/guide.md", true},
+		{"web/docs", "site/web/docs/guide.md", false}, // a slash in the middle does
+		{"/build/logs/", "build/logs/out.log", true},
+		{"/build/logs/", "src/build/logs/out.log", false},
+		{"docs/*", "docs/guide.md", true},
+		{"docs/*", "docs/api/index.md", false},
+		{"**/logs", "logs/today.log", true},
+		{"**/logs", "deep/down/logs/today.log", true},
+		{"pkg/**/x.go", "pkg/x.go", true},
+		{"pkg/**/x.go", "pkg/a/b/x.go", true},
+		{"pkg/**/x.go", "other/pkg/x.go", false},
+		{"apps/**", "apps/web/main.go", true},
+		{"config?.yml", "config1.yml", true},
+		{"config?.yml", "config/.yml", false},
+		{".env.*", "services/api/.env.production", true},
+		{".env.*", "services/api/.env", false},
+		{"a+b.txt", "a+b.txt", true},
+		{"a+b.txt", "aab.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			re, err := pathPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("pathPattern(%q): %v", tt.pattern, err)
+			}
+			if got := re.MatchString(tt.path); got != tt.match {
+				t.Errorf("pathPattern(%q) matches %q = %v, want %v", tt.pattern, tt.path, got, tt.match)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"", "/"} {
+		if _, err := pathPattern(pattern); err == nil {
+			t.Errorf("pathPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestCodeOwnersLastMatchWins(t *testing.T) {
+	c := &CodeOwners{}
+	for _, line := range [][]string{
+		{"*", "@org/everyone"},
+		{"*.go", "@org/go"},
+		{"/web/", "@org/frontend"},
+		{"/web/legacy/"}, // no owners
+	} {
+		re, err := pathPattern(line[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.rules = append(c.rules, codeownersRule{pattern: line[0], re: re, owners: line[1:]})
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"README.md", []string{"@org/everyone"}},
+		{"./cmd/main.go", []string{"@org/go"}},
+		{"web/tools/gen.go", []string{"@org/frontend"}},
+		{"web/legacy/app.js", []string{}},
+	}
+	for _, tt := range tests {
+		if got := c.Owners(tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("Owners(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
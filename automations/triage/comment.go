@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	// stickyMarker identifies the triage comment on a pull request. It is invisible when rendered.
+	stickyMarker = "<!-- yo-go-triage -->"
+	// maxStickyRuns caps the run history table; the oldest runs drop off first.
+	maxStickyRuns = 30
+	// actionsBot is the login of comments written with a workflow's GITHUB_TOKEN.
+	actionsBot = "github-actions[bot]"
+)
+
+var (
+	stickyState   = regexp.MustCompile(`<!-- yo-go-triage-state: (.*?) -->`)
+	stickySection = regexp.MustCompile(`(?s)<!-- yo-go-triage-section:(\d+) -->\n(.*?)\n<!-- /yo-go-triage-section:(\d+) -->`)
+)
+
+// Run statuses in the sticky comment's history table.
+const (
+	runFailing = "failing"
+	runFixed   = "fixed"
+)
+
+// stickyRun is one triaged workflow run in the sticky comment's history.
+type stickyRun struct {
+	RunID      int64     `json:"runId"`
+	RunNumber  int       `json:"runNumber"`
+	RunURL     string    `json:"runUrl"`
+	Attempt    int       `json:"attempt,omitempty"`
+	WorkflowID int64     `json:"workflowId"`
+	Workflow   string    `json:"workflow"`
+	SHA        string    `json:"sha"`
+	Category   string    `json:"category"`
+	FixURL     string    `json:"fixUrl,omitempty"`
+	Status     string    `json:"status"`
+	FixedBy    int       `json:"fixedBy,omitempty"` // run number of the run that passed
+	FixedByURL string    `json:"fixedByUrl,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// stickyComment is the single triage comment on a pull request: one diagnosis section per workflow,
+// updated as that workflow fails or recovers, and a history table of every triaged run. The
+// history is kept as JSON in a hidden comment so each update can rebuild the table.
+type stickyComment struct {
+	id       int64
+	runs     []stickyRun
+	order    []int64 // workflow IDs in section order
+	sections map[int64]string
+}
+
+func parseStickyComment(id int64, body string) *stickyComment {
+	c := &stickyComment{id: id, sections: make(map[int64]string)}
+	if m := stickyState.FindStringSubmatch(body); m != nil {
+		if err := json.Unmarshal([]byte(m[1]), &c.runs); err != nil {
+			slog.Warn("ignoring unreadable triage comment history", "comment", id, "err", err)
+		}
+	}
+	for _, m := range stickySection.FindAllStringSubmatch(body, -1) {
+		if m[1] != m[3] {
+			continue
+		}
+		var workflowID int64
+		fmt.Sscan(m[1], &workflowID)
+		c.setSection(workflowID, m[2])
+	}
+	return c
+}
+
+func (c *stickyComment) setSection(workflowID int64, content string) {
+	if _, ok := c.sections[workflowID]; !ok {
+		c.order = append(c.order, workflowID)
+	}
+	c.sections[workflowID] = content
+}
+
+// addRun records a run, replacing an earlier attempt of the same run.
+func (c *stickyComment) addRun(r stickyRun) {
+	runs := []stickyRun{r}
+	for _, existing := range c.runs {
+		if existing.RunID != r.RunID {
+			runs = append(runs, existing)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	c.runs = runs[:min(len(runs), maxStickyRuns)]
+}
+
+// markFixed marks a workflow's failing runs up to the passing run as fixed, including earlier
+// attempts of the same run, and collapses its diagnosis. It reports whether anything changed.
+func (c *stickyComment) markFixed(workflowID int64, runID int64, runNumber int, runURL string, sha string) bool {
+	changed := false
+	for i, r := range c.runs {
+		if r.WorkflowID == workflowID && r.Status == runFailing && (r.RunNumber < runNumber || r.RunID == runID) {
+			c.runs[i].Status = runFixed
+			c.runs[i].FixedBy = runNumber
+			c.runs[i].FixedByURL = runURL
+			changed = true
+		}
+	}
+	if !changed || c.failing(workflowID) {
+		return changed
+	}
+
+	header, diagnosis, _ := strings.Cut(c.sections[workflowID], "\n\n")
+	name := strings.TrimPrefix(header, "### ❌ ")
+	name, _, _ = strings.Cut(name, " · ")
+	c.setSection(workflowID, fmt.Sprintf("### ✅ %s\n\nFixed in [run #%d](%s) at commit `%s`.\n\n<details><summary>Earlier diagnosis</summary>\n\n%s\n\n</details>",
+		name, runNumber, runURL, shortSHA(sha), strings.TrimSpace(diagnosis)))
+	return true
+}
+
+// failing reports whether a workflow's latest triaged run is still failing.
+func (c *stickyComment) failing(workflowID int64) bool {
+	for _, r := range c.runs {
+		if r.WorkflowID == workflowID {
+			return r.Status == runFailing
+		}
+	}
+	return false
+}
+
+// render builds the comment body, kept under maxCheckText since GitHub rejects comments over
+// 65536 characters. When the full comment is too long, fixed workflows lose their earlier
+// diagnosis, then the remaining sections are shortened evenly, then the oldest runs drop off.
+func (c *stickyComment) render() string {
+	body := c.renderBody(c.sections, c.runs)
+	if len(body) <= maxCheckText {
+		return body
+	}
+
+	sections := make(map[int64]string, len(c.sections))
+	for id, section := range c.sections {
+		if !c.failing(id) {
+			section, _, _ = strings.Cut(section, "\n\n<details>")
+		}
+		sections[id] = section
+	}
+	body = c.renderBody(sections, c.runs)
+	if len(body) <= maxCheckText || len(sections) == 0 {
+		return c.trimRuns(body, sections)
+	}
+
+	sectionChars := 0
+	for _, section := range sections {
+		sectionChars += len(section)
+	}
+	// truncateResult adds a note to each section it cuts
+	budget := (maxCheckText-(len(body)-sectionChars))/len(sections) - len("\n... (truncated)")
+	for id, section := range sections {
+		sections[id] = truncateResult(section, max(budget, 0))
+	}
+	return c.trimRuns(c.renderBody(sections, c.runs), sections)
+}
+
+// trimRuns drops the oldest runs from the history until the body fits, keeping at least one.
+func (c *stickyComment) trimRuns(body string, sections map[int64]string) string {
+	runs := c.runs
+	for len(body) > maxCheckText && len(runs) > 1 {
+		runs = runs[:len(runs)-1]
+		body = c.renderBody(sections, runs)
+	}
+	return body
+}
+
+func (c *stickyComment) renderBody(sections map[int64]string, runs []stickyRun) string {
+	var b strings.Builder
+	b.WriteString(stickyMarker + "\n## 🔍 CI Failure Triage\n\n")
+
+	allFixed := len(runs) > 0
+	for _, r := range runs {
+		if r.Status == runFailing {
+			allFixed = false
+		}
+	}
+	if allFixed {
+		b.WriteString("✅ All triaged failures on this pull request have been fixed.\n\n")
+	}
+
+	for _, id := range c.order {
+		fmt.Fprintf(&b, "<!-- yo-go-triage-section:%d -->\n%s\n<!-- /yo-go-triage-section:%d -->\n\n", id, strings.TrimSpace(sections[id]), id)
+	}
+
+	b.WriteString("### Run History\n\n| Run | Workflow | Commit | Category | Status |\n|---|---|---|---|---|\n")
+	for _, r := range runs {
+		run := fmt.Sprintf("[#%d](%s)", r.RunNumber, r.RunURL)
+		if r.Attempt > 1 {
+			run += fmt.Sprintf(" (attempt %d)", r.Attempt)
+		}
+		status := "❌ Failing"
+		if r.Status == runFixed {
+			status = fmt.Sprintf("✅ Fixed in [#%d](%s)", r.FixedBy, r.FixedByURL)
+		}
+		if r.FixURL != "" {
+			status += fmt.Sprintf(" · [fix](%s)", r.FixURL)
+		}
+		fmt.Fprintf(&b, "| %s | %s | `%s` | `%s` | %s |\n", run, tableCell(r.Workflow), shortSHA(r.SHA), r.Category, status)
+	}
+
+	// encoding/json escapes < and >, so the history can't close the HTML comment early
+	state, _ := json.Marshal(runs)
+	fmt.Fprintf(&b, "\n---\n*Triaged by yo-go · updated %s*\n<!-- yo-go-triage-state: %s -->\n", time.Now().UTC().Format("2006-01-02 15:04 UTC"), state)
+	return b.String()
+}
+
+// runPullRequests returns the open pull requests a run belongs to. Runs from forks have no
+// pull requests attached, so those are looked up by the run's head commit instead.
+func (t *Triage) runPullRequests(ctx context.Context, run *github.WorkflowRun) ([]*github.PullRequest, error) {
+	if len(run.PullRequests) > 0 {
+		return run.PullRequests, nil
+	}
+	prs, _, err := t.github.PullRequests.ListPullRequestsWithCommit(ctx, t.owner, t.repo, run.GetHeadSHA(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing pull requests for commit %s: %w", shortSHA(run.GetHeadSHA()), err)
+	}
+	var open []*github.PullRequest
+	for _, pr := range prs {
+		if pr.GetState() == "open" && pr.GetHead().GetSHA() == run.GetHeadSHA() {
+			open = append(open, pr)
+		}
+	}
+	return open, nil
+}
+
+// stickyAuthors returns the logins whose comments may be the triage comment: the workflow's
+// GITHUB_TOKEN bot and, for a personal token, its user. Installation tokens can't read their own
+// user, so that lookup failing is expected.
+func (t *Triage) stickyAuthors(ctx context.Context) map[string]bool {
+	authors := map[string]bool{actionsBot: true}
+	if user, _, err := t.github.Users.Get(ctx, ""); err == nil && user.GetLogin() != "" {
+		authors[user.GetLogin()] = true
+	}
+	return authors
+}
+
+// findStickyComment returns the pull request's triage comment, or nil if there is none yet. Only
+// comments written by this action's token count: anyone can post the marker, and a comment we
+// can't edit, or whose history someone else wrote, would break or forge the comment's state.
+func (t *Triage) findStickyComment(ctx context.Context, prNumber int) (*stickyComment, error) {
+	authors := t.stickyAuthors(ctx)
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := t.github.Issues.ListComments(ctx, t.owner, t.repo, prNumber, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing comments on #%d: %w", prNumber, err)
+		}
+		for _, c := range comments {
+			if strings.HasPrefix(c.GetBody(), stickyMarker) && authors[c.GetUser().GetLogin()] {
+				return parseStickyComment(c.GetID(), c.GetBody()), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// saveStickyComment creates the comment or updates it in place.
+func (t *Triage) saveStickyComment(ctx context.Context, prNumber int, c *stickyComment) error {
+	comment := &github.IssueComment{Body: github.Ptr(c.render())}
+	if c.id == 0 {
+		if _, _, err := t.github.Issues.CreateComment(ctx, t.owner, t.repo, prNumber, comment); err != nil {
+			return fmt.Errorf("creating PR comment: %w", err)
+		}
+		slog.Info("posted triage comment on PR", "pr", prNumber)
+		return nil
+	}
+	if _, _, err := t.github.Issues.EditComment(ctx, t.owner, t.repo, c.id, comment); err != nil {
+		return fmt.Errorf("updating PR comment: %w", err)
+	}
+	slog.Info("updated triage comment on PR", "pr", prNumber, "comment", c.id)
+	return nil
+}
+
+// resolveRecovered marks other workflows' failures fixed when a later run of that workflow has
+// passed on the pull request's branch since they were triaged.
+func (t *Triage) resolveRecovered(ctx context.Context, c *stickyComment, pr *github.PullRequest, current int64) {
+	latest := make(map[int64]int)
+	for _, r := range c.runs {
+		if r.WorkflowID != current && r.Status == runFailing && r.RunNumber > latest[r.WorkflowID] {
+			latest[r.WorkflowID] = r.RunNumber
+		}
+	}
+	for workflowID, failedNumber := range latest {
+		runs, _, err := t.github.Actions.ListWorkflowRunsByID(ctx, t.owner, t.repo, workflowID, &github.ListWorkflowRunsOptions{
+			Branch:      pr.GetHead().GetRef(),
+			Status:      "success",
+			ListOptions: github.ListOptions{PerPage: 10},
+		})
+		if err != nil {
+			slog.Warn("error checking whether a workflow has recovered", "workflow", workflowID, "err", err)
+			continue
+		}
+		for _, run := range runs.WorkflowRuns {
+			// A run with the failed run's number is a re-run attempt that passed
+			if run.GetRunNumber() >= failedNumber {
+				c.markFixed(workflowID, run.GetID(), run.GetRunNumber(), run.GetHTMLURL(), run.GetHeadSHA())
+				break
+			}
+		}
+	}
+}
+
+// ResolveOnPR updates the triage comment on the run's pull requests after the workflow passed,
+// marking its earlier failures as fixed. Pull requests without a triage comment are left alone.
+func (t *Triage) ResolveOnPR(ctx context.Context) error {
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return fmt.Errorf("getting workflow run: %w", err)
+	}
+	prs, err := t.runPullRequests(ctx, run)
+	if err != nil {
+		return err
+	}
+
+	for _, pr := range prs {
+		c, err := t.findStickyComment(ctx, pr.GetNumber())
+		if err != nil {
+			return err
+		}
+		if c == nil || !c.markFixed(run.GetWorkflowID(), run.GetID(), run.GetRunNumber(), run.GetHTMLURL(), run.GetHeadSHA()) {
+			continue
+		}
+		if err := t.saveStickyComment(ctx, pr.GetNumber(), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPassed reports whether this invocation is for a passing run: MARK_RESOLVED is set by a step
+// that only runs on success, or a workflow_run trigger handed over a run that concluded green.
+func (t *Triage) runPassed(ctx context.Context) bool {
+	if os.Getenv("MARK_RESOLVED") == "true" {
+		return true
+	}
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		slog.Warn("error checking the run's conclusion", "err", err)
+		return false
+	}
+	return run.GetStatus() == "completed" && run.GetConclusion() == "success"
+}
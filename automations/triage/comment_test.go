@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStickyCommentRender(t *testing.T) {
+	longDiagnosis := strings.Repeat("a very long diagnosis line\n", 2_000)
+	tests := []struct {
+		name     string
+		sections map[int64]string
+		runs     int
+		failing  map[int64]bool
+	}{
+		{"small", map[int64]string{1: "### ❌ build\n\nshort"}, 3, map[int64]bool{1: true}},
+		{"long failing section", map[int64]string{1: "### ❌ build\n\n" + longDiagnosis}, 3, map[int64]bool{1: true}},
+		{"long fixed sections", map[int64]string{
+			1: "### ✅ build\n\nFixed.\n\n<details><summary>Earlier diagnosis</summary>\n\n" + longDiagnosis + "\n\n</details>",
+			2: "### ✅ test\n\nFixed.\n\n<details><summary>Earlier diagnosis</summary>\n\n" + longDiagnosis + "\n\n</details>",
+		}, 3, nil},
+		{"many long sections", map[int64]string{
+			1: "### ❌ build\n\n" + longDiagnosis,
+			2: "### ❌ test\n\n" + longDiagnosis,
+			3: "### ❌ lint\n\n" + longDiagnosis,
+		}, maxStickyRuns, map[int64]bool{1: true, 2: true, 3: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &stickyComment{sections: make(map[int64]string)}
+			for id := int64(1); id <= int64(len(tt.sections)); id++ {
+				c.setSection(id, tt.sections[id])
+			}
+			for i := range tt.runs {
+				status := runFixed
+				workflowID := int64(i%len(tt.sections) + 1)
+				if tt.failing[workflowID] {
+					status = runFailing
+				}
+				c.addRun(stickyRun{RunID: int64(i + 1), RunNumber: i + 1, WorkflowID: workflowID, Workflow: "ci", Status: status, Time: time.Unix(int64(i), 0)})
+			}
+
+			body := c.render()
+			if len(body) > maxCheckText {
+				t.Fatalf("rendered %d characters, more than %d", len(body), maxCheckText)
+			}
+			parsed := parseStickyComment(1, body)
+			if len(parsed.order) != len(tt.sections) {
+				t.Errorf("parsed %d sections, want %d", len(parsed.order), len(tt.sections))
+			}
+			if len(parsed.runs) == 0 {
+				t.Errorf("parsed no runs")
+			}
+			for id, section := range tt.sections {
+				header, _, _ := strings.Cut(section, "\n")
+				if !strings.HasPrefix(parsed.sections[id], header) {
+					t.Errorf("section %d lost its header %q", id, header)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	// contextHeadroom leaves room for tokenizer differences between model families and for
+	// request framing we don't count exactly.
+	contextHeadroom = 0.9
+	// messageOverhead is the per-message framing cost in the chat format.
+	messageOverhead = 4
+	// minSummaryTokens is the smallest summary worth asking for.
+	minSummaryTokens = 150
+)
+
+func init() {
+	// The BPE ranks are embedded so counting tokens never needs network access
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// tokenCounter counts tokens with the model's BPE encoding, falling back to cl100k_base for
+// models tiktoken doesn't know, and to a character estimate if no encoding loads at all.
+type tokenCounter struct {
+	enc *tiktoken.Tiktoken
+}
+
+func newTokenCounter(model string) *tokenCounter {
+	_, name, _ := strings.Cut(model, "/")
+	if name == "" {
+		name = model
+	}
+	enc, err := tiktoken.EncodingForModel(name)
+	if err != nil {
+		encoding := "cl100k_base"
+		if strings.HasPrefix(name, "gpt-5") || strings.HasPrefix(name, "o1") || strings.HasPrefix(name, "o3") || strings.HasPrefix(name, "o4") {
+			encoding = "o200k_base"
+		}
+		if enc, err = tiktoken.GetEncoding(encoding); err != nil {
+			slog.Warn("no tokenizer available, estimating tokens from length", "model", model, "err", err)
+		}
+	}
+	return &tokenCounter{enc: enc}
+}
+
+func (c *tokenCounter) count(s string) int {
+	if c.enc == nil {
+		return len(s) / 4
+	}
+	return len(c.enc.EncodeOrdinary(s))
+}
+
+func (c *tokenCounter) message(m Message) int {
+	n := messageOverhead + c.count(m.Role) + c.count(m.Content)
+	for _, tc := range m.ToolCalls {
+		n += c.count(tc.Function.Name) + c.count(tc.Function.Arguments) + messageOverhead
+	}
+	return n
+}
+
+// request counts a whole request: every message plus the tool schemas and the response format,
+// which the API bills as prompt tokens too.
+func (c *tokenCounter) request(messages []Message, tools []ToolDef, format *ResponseFormat) int {
+	n := 3 // reply priming
+	for _, m := range messages {
+		n += c.message(m)
+	}
+	if len(tools) > 0 {
+		b, _ := json.Marshal(tools)
+		n += c.count(string(b))
+	}
+	if format != nil {
+		b, _ := json.Marshal(format)
+		n += c.count(string(b))
+	}
+	return n
+}
+
+// contextManager keeps a tool conversation under the model's prompt limit. Once the history no
+// longer fits, the oldest tool results are replaced, one at a time, by pinned excerpts (error
+// lines and file:line references, kept verbatim) plus a model-written summary of the rest.
+type contextManager struct {
+	t          *Triage
+	mask       *masker
+	counter    *tokenCounter
+	budget     int
+	summarised map[int]bool // indexes of tool messages already compacted
+}
+
+func (t *Triage) newContextManager(mask *masker) *contextManager {
+	return &contextManager{
+		t:          t,
+		mask:       mask,
+		counter:    newTokenCounter(t.model.name),
+		budget:     int(float64(t.model.ContextWindow) * contextHeadroom),
+		summarised: make(map[int]bool),
+	}
+}
+
+// shrink lowers the budget after the API rejected a request we counted as fitting.
+func (c *contextManager) shrink() {
+	c.budget = c.budget * 3 / 4
+	slog.Warn("prompt rejected as too large, lowering context budget", "budget", c.budget)
+}
+
+// fit compacts messages until the request fits the budget. Tool results are compacted oldest
+// first; the newest round is only touched when compacting older ones wasn't enough.
+func (c *contextManager) fit(ctx context.Context, messages []Message, tools []ToolDef, format *ResponseFormat) []Message {
+	used := c.counter.request(messages, tools, format)
+	if used <= c.budget {
+		return messages
+	}
+	slog.Info("conversation exceeds context budget, compacting tool results", "tokens", used, "budget", c.budget)
+
+	for i := range messages {
+		if used <= c.budget {
+			break
+		}
+		if messages[i].Role != "tool" || c.summarised[i] {
+			continue
+		}
+		before := c.counter.message(messages[i])
+		if before <= 2*minSummaryTokens {
+			continue // too small for a summary to save anything
+		}
+		// Aim to bring this message down by the overshoot, but never below a useful summary
+		target := max(minSummaryTokens, min(before/4, before-(used-c.budget)))
+		messages[i].Content = c.compact(ctx, messages[i].Content, target)
+		c.summarised[i] = true
+		used += c.counter.message(messages[i]) - before
+	}
+
+	if used > c.budget {
+		// Everything is already compacted; cut what's left proportionally as a last resort
+		slog.Warn("conversation still exceeds context budget after summarising", "tokens", used, "budget", c.budget)
+		for i := range messages {
+			if messages[i].Role == "tool" {
+				messages[i].Content = c.truncateTokens(messages[i].Content, c.counter.count(messages[i].Content)*c.budget/used)
+			}
+		}
+	}
+
+	slog.Info("compacted conversation", "tokens", c.counter.request(messages, tools, format), "budget", c.budget)
+	return messages
+}
+
+// maxPinnedLines caps how many lines of one tool result are kept verbatim.
+const maxPinnedLines = 40
+
+var (
+	pinnedLine  = regexp.MustCompile(`(?i)\b(error|fatal|failed|failure|panic|exception|assert)|^\s*--- FAIL|^\s*FAIL\b`)
+	fileLineRef = regexp.MustCompile(`[\w./-]+\.[A-Za-z]\w*:\d+`)
+)
+
+// pinnedExcerpts returns the lines of a tool result that must survive compaction verbatim.
+func pinnedExcerpts(content string) []string {
+	var pinned []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || seen[trimmed] {
+			continue
+		}
+		if pinnedLine.MatchString(trimmed) || fileLineRef.MatchString(trimmed) {
+			seen[trimmed] = true
+			pinned = append(pinned, line)
+			if len(pinned) == maxPinnedLines {
+				break
+			}
+		}
+	}
+	return pinned
+}
+
+// compact replaces a tool result with its pinned excerpts and a model summary, in about target
+// tokens. If the summary request fails the excerpts are kept with a truncated tail instead.
+func (c *contextManager) compact(ctx context.Context, content string, target int) string {
+	pinned := c.truncateTokens(strings.Join(pinnedExcerpts(content), "\n"), target/2)
+	summaryTokens := max(minSummaryTokens/2, target-c.counter.count(pinned))
+
+	summary, err := c.summarise(ctx, content, summaryTokens)
+	if err != nil {
+		slog.Warn("error summarising tool result, truncating instead", "err", err)
+		summary = "(summary unavailable; last lines)\n" + c.truncateTokens(truncateLogs(content, 40), summaryTokens)
+	}
+
+	var b strings.Builder
+	b.WriteString("[Compacted tool result to fit the context window]\n")
+	if pinned != "" {
+		fmt.Fprintf(&b, "Key lines (verbatim):\n%s\n", pinned)
+	}
+	fmt.Fprintf(&b, "Summary: %s", summary)
+	return b.String()
+}
+
+// summarise asks the model for a summary of a tool result in at most maxTokens tokens. The
+// input is cut to what fits in a single request.
+func (c *contextManager) summarise(ctx context.Context, content string, maxTokens int) (string, error) {
+	instructions := fmt.Sprintf("Summarise this output from a CI failure investigation tool in at most %d tokens. "+
+		"Keep exact error messages, test names, file paths with line numbers, versions and commands. "+
+		"Drop progress output, timestamps and repeated lines. Reply with the summary only.", maxTokens)
+	input := c.truncateTokens(content, c.budget-c.counter.count(instructions)-maxTokens-50)
+
+	req := ChatRequest{
+		Model: c.t.model.name,
+		Messages: c.mask.maskMessages([]Message{
+			{Role: "system", Content: instructions + maskNotice},
+			{Role: "user", Content: input},
+		}),
+	}
+	resp, err := c.t.chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("summary response has no choices")
+	}
+	summary := strings.TrimSpace(c.mask.unmaskMessage(resp.Choices[0].Message).Content)
+	return c.truncateTokens(summary, maxTokens), nil
+}
+
+// truncateTokens keeps the head and tail of s within maxTokens, eliding the middle. Errors tend to
+// sit at the end of output and context at the start, so both ends are worth keeping.
+func (c *contextManager) truncateTokens(s string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	n := c.counter.count(s)
+	if n <= maxTokens {
+		return s
+	}
+	// Scale characters by the observed chars-per-token ratio, leaving room for the marker
+	keep := len(s) * (maxTokens - 10) / n
+	if keep <= 0 {
+		return ""
+	}
+	// Both cuts land on character boundaries so no multi-byte character is split
+	head := cutAtRune(s, keep/3)
+	tailStart := len(s) - (keep - len(head))
+	for tailStart < len(s) && !utf8.RuneStart(s[tailStart]) {
+		tailStart++
+	}
+	return head + "\n... (truncated) ...\n" + s[tailStart:]
+}
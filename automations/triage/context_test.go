@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateTokens(t *testing.T) {
+	c := &contextManager{counter: newTokenCounter("openai/gpt-4o")}
+	log := "first line of context\n" + strings.Repeat("progress output 42%\n", 500) + "error: the real failure\n"
+
+	tests := []struct {
+		name      string
+		s         string
+		maxTokens int
+		want      []string // must survive, in order
+	}{
+		{"fits", "short output", 100, []string{"short output"}},
+		{"no budget", "short output", 0, nil},
+		{"keeps both ends", log, 100, []string{"first line of context", "... (truncated) ...", "error: the real failure"}},
+		{"multi-byte text", strings.Repeat("エラー: テストが失敗しました\n", 300), 60, []string{"エラー", "... (truncated) ...", "しました\n"}},
+		{"emoji", strings.Repeat("🔥✅💥", 400), 50, []string{"🔥", "... (truncated) ..."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.truncateTokens(tt.s, tt.maxTokens)
+			if !utf8.ValidString(got) {
+				t.Errorf("result is not valid UTF-8: %q", got)
+			}
+			if n := c.counter.count(got); n > max(tt.maxTokens, 0) {
+				t.Errorf("result is %d tokens, more than %d", n, tt.maxTokens)
+			}
+			rest := got
+			for _, want := range tt.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("%q missing or out of order in %q", want, got)
+				}
+				rest = rest[i+len(want):]
+			}
+			if tt.want == nil && got != "" {
+				t.Errorf("got %q, want nothing", got)
+			}
+		})
+	}
+}
+
+func TestPinnedExcerpts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "errors and file references",
+			content: "downloading modules\n--- FAIL: TestLogin (0.02s)\n    login_test.go:42: got 401\nok  \tother/pkg\nFAIL\nBuild error: exit status 1",
+			want:    []string{"--- FAIL: TestLogin (0.02s)", "    login_test.go:42: got 401", "FAIL", "Build error: exit status 1"},
+		},
+		{
+			name:    "repeated lines once",
+			content: "panic: boom\npanic: boom\n  panic: boom",
+			want:    []string{"panic: boom"},
+		},
+		{
+			name:    "nothing to pin",
+			content: "compiling\nlinking\ndone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pinnedExcerpts(tt.content); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenCounterRequest(t *testing.T) {
+	c := newTokenCounter("openai/gpt-4o")
+	messages := []Message{{Role: "system", Content: "You triage CI failures."}, {Role: "user", Content: "The build failed."}}
+	base := c.request(messages, nil, nil)
+
+	tests := []struct {
+		name   string
+		tools  []ToolDef
+		format *ResponseFormat
+	}{
+		{"tool schemas", []ToolDef{{Type: "function", Function: FunctionDef{Name: "read_file", Description: "Read a file from the repository"}}}, nil},
+		{"response format", nil, triageResultFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.request(messages, tt.tools, tt.format); got <= base {
+				t.Errorf("request = %d tokens, want more than the %d of the messages alone", got, base)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	// maxFlakyRuns caps how many other runs of the workflow on the same commit are inspected.
+	maxFlakyRuns = 10
+	// maxRerunAttempts stops a run that keeps flaking from being re-run forever.
+	maxRerunAttempts = 3
+)
+
+// jobOutcome is how a job with a given name ended in some run or attempt on the same commit.
+type jobOutcome struct {
+	runID      int64
+	attempt    int64
+	jobID      int64
+	conclusion string
+	url        string
+}
+
+// DetectFlaky looks at other runs and earlier attempts of the same workflow on the same commit.
+// A failed job that passed elsewhere on identical code, or failed elsewhere on a disjoint set of
+// tests, is treated as flaky. The run is only classified as flaky when every failed job is; it
+// returns nil when any of them has no evidence, so the model diagnoses the run.
+func (t *Triage) DetectFlaky(ctx context.Context) (*TriageResult, error) {
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return nil, fmt.Errorf("getting workflow run: %w", err)
+	}
+
+	failed, err := t.failedJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+
+	outcomes, err := t.sameCommitOutcomes(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	if len(outcomes) == 0 {
+		slog.Info("no other runs on the same commit, skipping flaky detection")
+		return nil, nil
+	}
+
+	current := t.TestFailures(ctx)
+
+	names := make([]string, 0, len(failed))
+	for _, job := range failed {
+		names = append(names, job.GetName())
+	}
+	evidence, passedElsewhere, unexplained := flakyEvidence(names, outcomes, func(jobName string, o jobOutcome) string {
+		return t.disjointFailures(ctx, jobName, current, o)
+	})
+	if len(unexplained) > 0 {
+		if len(evidence) > 0 {
+			slog.Info("some failed jobs look flaky but others don't, falling back to analysis", "jobs", strings.Join(unexplained, ", "))
+		}
+		return nil, nil
+	}
+
+	confidence := "medium"
+	if passedElsewhere > 0 {
+		confidence = "high"
+	}
+
+	result := &TriageResult{
+		Category:     "flaky",
+		RootCause:    fmt.Sprintf("The failure did not reproduce consistently on commit %s: the same jobs or tests both passed and failed on identical code.", shortSHA(run.GetHeadSHA())),
+		SuggestedFix: "Re-run the failed jobs. If the test keeps flaking, quarantine it and look for timing, ordering or shared-state dependencies.",
+		Confidence:   confidence,
+		Fixable:      false,
+		Evidence:     evidence,
+	}
+	for _, f := range current {
+		if f.File != "" {
+			result.AffectedFiles = appendUnique(result.AffectedFiles, f.File)
+		}
+	}
+
+	slog.Info("classified failure as flaky", "evidence", len(evidence), "confidence", confidence)
+	return result, nil
+}
+
+// flakyEvidence gathers the evidence that each failed job is flaky from its outcomes elsewhere,
+// using disjoint to compare test failures when the job failed there too. It returns the
+// evidence, how many of its entries are passes, and the failed jobs that have none.
+func flakyEvidence(failed []string, outcomes map[string][]jobOutcome, disjoint func(jobName string, o jobOutcome) string) (evidence []string, passedElsewhere int, unexplained []string) {
+	for _, name := range failed {
+		found := false
+		for _, o := range outcomes[name] {
+			switch o.conclusion {
+			case "success":
+				passedElsewhere++
+				found = true
+				evidence = append(evidence, fmt.Sprintf("Job `%s` passed in [run %d attempt %d](%s) on the same commit", name, o.runID, o.attempt, o.url))
+			case "failure":
+				if e := disjoint(name, o); e != "" {
+					found = true
+					evidence = append(evidence, e)
+				}
+			}
+		}
+		if !found {
+			unexplained = append(unexplained, name)
+		}
+	}
+	return evidence, passedElsewhere, unexplained
+}
+
+// sameCommitOutcomes collects job outcomes, keyed by job name, from earlier attempts of this run
+// and from other runs of the same workflow that tested the same code, see sameCode.
+func (t *Triage) sameCommitOutcomes(ctx context.Context, run *github.WorkflowRun) (map[string][]jobOutcome, error) {
+	outcomes := make(map[string][]jobOutcome)
+	add := func(runID int64, attempt int64, url string, jobs []*github.WorkflowJob) {
+		for _, job := range jobs {
+			outcomes[job.GetName()] = append(outcomes[job.GetName()], jobOutcome{
+				runID:      runID,
+				attempt:    attempt,
+				jobID:      job.GetID(),
+				conclusion: job.GetConclusion(),
+				url:        url,
+			})
+		}
+	}
+
+	// Earlier attempts of this run re-ran the exact same code
+	for attempt := int64(1); attempt < int64(run.GetRunAttempt()); attempt++ {
+		jobs, _, err := t.github.Actions.ListWorkflowJobsAttempt(ctx, t.owner, t.repo, t.runID, attempt, &github.ListOptions{PerPage: 100})
+		if err != nil {
+			slog.Warn("error listing jobs for earlier attempt", "attempt", attempt, "err", err)
+			continue
+		}
+		add(t.runID, attempt, fmt.Sprintf("%s/attempts/%d", run.GetHTMLURL(), attempt), jobs.Jobs)
+	}
+
+	runs, _, err := t.github.Actions.ListWorkflowRunsByID(ctx, t.owner, t.repo, run.GetWorkflowID(), &github.ListWorkflowRunsOptions{
+		HeadSHA:     run.GetHeadSHA(),
+		Event:       run.GetEvent(),
+		Status:      "completed",
+		ListOptions: github.ListOptions{PerPage: maxFlakyRuns},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing runs for the same commit: %w", err)
+	}
+	for _, other := range runs.WorkflowRuns {
+		if other.GetID() == t.runID || !sameCode(run, other) {
+			continue
+		}
+		jobs, _, err := t.github.Actions.ListWorkflowJobs(ctx, t.owner, t.repo, other.GetID(), &github.ListWorkflowJobsOptions{
+			Filter: "latest",
+		})
+		if err != nil {
+			slog.Warn("error listing jobs for other run", "run", other.GetID(), "err", err)
+			continue
+		}
+		add(other.GetID(), int64(other.GetRunAttempt()), other.GetHTMLURL(), jobs.Jobs)
+	}
+
+	return outcomes, nil
+}
+
+// sameCode reports whether other ran the same code as run. The head SHA alone isn't enough: a
+// pull_request run tests the head merged into the base, and a push run tests the head alone.
+func sameCode(run *github.WorkflowRun, other *github.WorkflowRun) bool {
+	return other.GetEvent() == run.GetEvent() && prBaseSHA(other) == prBaseSHA(run)
+}
+
+// prBaseSHA is the base commit of the run's pull request, or "" when it has none.
+func prBaseSHA(run *github.WorkflowRun) string {
+	if len(run.PullRequests) == 0 {
+		return ""
+	}
+	return run.PullRequests[0].GetBase().GetSHA()
+}
+
+// disjointFailures compares the failing tests of a job in another run on the same commit with
+// this run's. If none of them overlap, the tests that failed here passed there.
+func (t *Triage) disjointFailures(ctx context.Context, jobName string, current []TestFailure, other jobOutcome) string {
+	here := make(map[string]bool)
+	for _, f := range current {
+		if f.Job == jobName && f.Test != "(panic)" {
+			here[f.Test] = true
+		}
+	}
+	if len(here) == 0 {
+		return ""
+	}
+
+	logs, err := t.downloadJobLogs(ctx, other.jobID)
+	if err != nil {
+		slog.Warn("error downloading logs for other run", "run", other.runID, "job", jobName, "err", err)
+		return ""
+	}
+	there := parseTestFailures(logs)
+	if len(there) == 0 {
+		return ""
+	}
+	for _, f := range there {
+		if here[f.Test] {
+			return ""
+		}
+	}
+
+	names := make([]string, 0, len(here))
+	for name := range here {
+		names = append(names, "`"+name+"`")
+	}
+	return fmt.Sprintf("Job `%s` also failed in [run %d attempt %d](%s) on the same commit, but %s passed there", jobName, other.runID, other.attempt, other.url, strings.Join(names, ", "))
+}
+
+// RerunFailedJobs asks GitHub to re-run the failed jobs of the current run. GitHub only accepts
+// this once the run has completed, so it works when triage runs from a separate workflow_run
+// workflow; from a step inside the failing run the request is rejected and reported.
+// The token needs the actions: write permission.
+func (t *Triage) RerunFailedJobs(ctx context.Context) error {
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return fmt.Errorf("getting workflow run: %w", err)
+	}
+	if run.GetRunAttempt() >= maxRerunAttempts {
+		return fmt.Errorf("run has already been attempted %d times", run.GetRunAttempt())
+	}
+	if run.GetStatus() != "completed" {
+		return fmt.Errorf("run is still %s; GitHub can only re-run completed runs", run.GetStatus())
+	}
+
+	if _, err := t.github.Actions.RerunFailedJobsByID(ctx, t.owner, t.repo, t.runID); err != nil {
+		return fmt.Errorf("re-running failed jobs: %w", err)
+	}
+	slog.Info("requested re-run of failed jobs", "run", t.runID)
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
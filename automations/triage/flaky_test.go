@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestFlakyEvidence(t *testing.T) {
+	passed := jobOutcome{runID: 2, attempt: 1, conclusion: "success"}
+	failedSame := jobOutcome{runID: 3, attempt: 1, conclusion: "failure"}
+	failedOther := jobOutcome{runID: 4, attempt: 1, conclusion: "failure"}
+	// Only run 4 failed on different tests
+	disjoint := func(jobName string, o jobOutcome) string {
+		if o.runID == 4 {
+			return "different tests failed in run 4"
+		}
+		return ""
+	}
+
+	tests := []struct {
+		name            string
+		failed          []string
+		outcomes        map[string][]jobOutcome
+		wantEvidence    int
+		wantPassed      int
+		wantUnexplained []string
+	}{
+		{
+			name:         "passed elsewhere",
+			failed:       []string{"test"},
+			outcomes:     map[string][]jobOutcome{"test": {passed}},
+			wantEvidence: 1,
+			wantPassed:   1,
+		},
+		{
+			name:         "failed elsewhere on other tests",
+			failed:       []string{"test"},
+			outcomes:     map[string][]jobOutcome{"test": {failedOther}},
+			wantEvidence: 1,
+		},
+		{
+			name:            "failed elsewhere on the same tests",
+			failed:          []string{"test"},
+			outcomes:        map[string][]jobOutcome{"test": {failedSame}},
+			wantUnexplained: []string{"test"},
+		},
+		{
+			name:            "never ran elsewhere",
+			failed:          []string{"test"},
+			outcomes:        map[string][]jobOutcome{"lint": {passed}},
+			wantUnexplained: []string{"test"},
+		},
+		{
+			name:            "one of two jobs flaky",
+			failed:          []string{"test", "lint"},
+			outcomes:        map[string][]jobOutcome{"test": {passed}, "lint": {failedSame}},
+			wantEvidence:    1,
+			wantPassed:      1,
+			wantUnexplained: []string{"lint"},
+		},
+		{
+			name:         "both jobs flaky",
+			failed:       []string{"test", "lint"},
+			outcomes:     map[string][]jobOutcome{"test": {passed, failedSame}, "lint": {failedOther}},
+			wantEvidence: 2,
+			wantPassed:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evidence, passedElsewhere, unexplained := flakyEvidence(tt.failed, tt.outcomes, disjoint)
+			if len(evidence) != tt.wantEvidence {
+				t.Errorf("got %d evidence, want %d: %v", len(evidence), tt.wantEvidence, evidence)
+			}
+			if passedElsewhere != tt.wantPassed {
+				t.Errorf("passedElsewhere = %d, want %d", passedElsewhere, tt.wantPassed)
+			}
+			if !slices.Equal(unexplained, tt.wantUnexplained) {
+				t.Errorf("unexplained = %v, want %v", unexplained, tt.wantUnexplained)
+			}
+		})
+	}
+}
+
+func TestSameCode(t *testing.T) {
+	run := func(event string, baseSHA string) *github.WorkflowRun {
+		r := &github.WorkflowRun{Event: github.Ptr(event)}
+		if baseSHA != "" {
+			r.PullRequests = []*github.PullRequest{{Base: &github.PullRequestBranch{SHA: github.Ptr(baseSHA)}}}
+		}
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		run   *github.WorkflowRun
+		other *github.WorkflowRun
+		want  bool
+	}{
+		{"both pushes", run("push", ""), run("push", ""), true},
+		{"same pull request base", run("pull_request", "base1"), run("pull_request", "base1"), true},
+		{"base moved", run("pull_request", "base1"), run("pull_request", "base2"), false},
+		{"push and pull request", run("pull_request", "base1"), run("push", ""), false},
+		{"schedule and push", run("schedule", ""), run("push", ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameCode(tt.run, tt.other); got != tt.want {
+				t.Errorf("sameCode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
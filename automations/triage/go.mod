@@ -2,6 +2,14 @@ module github.com/mdmagnuson-creator/yo-go/automations/triage
 
 go 1.24.0
 
-require github.com/google/go-github/v79 v79.0.0
+require (
+	github.com/google/go-github/v79 v79.0.0
+	github.com/pkoukk/tiktoken-go v0.1.8
+	github.com/pkoukk/tiktoken-go-loader v0.0.2
+)
 
-require github.com/google/go-querystring v1.1.0 // indirect
+require (
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+)
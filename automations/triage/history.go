@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	// historyWindow is how recently a signature must have been seen for a failure to count as a repeat.
+	historyWindow = 7 * 24 * time.Hour
+	// historyRetention is how long entries are kept before being pruned.
+	historyRetention = 30 * 24 * time.Hour
+	// maxHistoryEntries bounds how many signatures are tracked.
+	maxHistoryEntries = 500
+	// maxHistoryBytes keeps history.json under the 1MB the contents API returns inline. Entries
+	// carry whole diagnoses, so the entry cap alone doesn't bound the file's size.
+	maxHistoryBytes      = 768 * 1024
+	historyFileName      = "history.json"
+	defaultHistoryBranch = "triage-history"
+	historyUpdateTries   = 3
+)
+
+// HistoryEntry is the first diagnosis of a failure signature plus how often it has recurred since.
+type HistoryEntry struct {
+	Signature string       `json:"signature"`
+	FirstSeen time.Time    `json:"firstSeen"`
+	LastSeen  time.Time    `json:"lastSeen"`
+	Count     int          `json:"count"`
+	RunID     int64        `json:"runId"`
+	RunURL    string       `json:"runUrl"`
+	LastRunID int64        `json:"lastRunId"`
+	Branch    string       `json:"branch,omitempty"`
+	PRURL     string       `json:"prUrl,omitempty"`
+	Result    TriageResult `json:"result"`
+	// Threads maps notification channels to the thread of the first notification, see notify.go
+	Threads map[string]string `json:"threads,omitempty"`
+}
+
+// History is the persisted set of known failure signatures.
+type History struct {
+	Entries map[string]*HistoryEntry `json:"entries"`
+}
+
+// lookup returns the entry for a signature if it was seen within historyWindow.
+func (h *History) lookup(signature string, now time.Time) *HistoryEntry {
+	e, ok := h.Entries[signature]
+	if !ok || now.Sub(e.LastSeen) > historyWindow {
+		return nil
+	}
+	return e
+}
+
+// prune drops entries past historyRetention, then the least recently seen beyond maxHistoryEntries.
+func (h *History) prune(now time.Time) {
+	for sig, e := range h.Entries {
+		if now.Sub(e.LastSeen) > historyRetention {
+			delete(h.Entries, sig)
+		}
+	}
+	if len(h.Entries) <= maxHistoryEntries {
+		return
+	}
+	for _, e := range h.byLastSeen()[maxHistoryEntries:] {
+		delete(h.Entries, e.Signature)
+	}
+}
+
+// byLastSeen returns the entries, most recently seen first.
+func (h *History) byLastSeen() []*HistoryEntry {
+	entries := make([]*HistoryEntry, 0, len(h.Entries))
+	for _, e := range h.Entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastSeen.After(entries[j].LastSeen) })
+	return entries
+}
+
+// encode serialises the history, dropping the least recently seen tenth of the entries at a time
+// until it fits in maxHistoryBytes.
+func (h *History) encode() []byte {
+	data, _ := json.MarshalIndent(h, "", "  ")
+	for len(data) > maxHistoryBytes && len(h.Entries) > 0 {
+		entries := h.byLastSeen()
+		drop := max(len(entries)/10, 1)
+		for _, e := range entries[len(entries)-drop:] {
+			delete(h.Entries, e.Signature)
+		}
+		slog.Info("triage history too large, dropped the oldest entries", "dropped", drop, "bytes", len(data))
+		data, _ = json.MarshalIndent(h, "", "  ")
+	}
+	return data
+}
+
+func decodeHistory(data []byte) (*History, error) {
+	h := &History{Entries: make(map[string]*HistoryEntry)}
+	if len(data) == 0 {
+		return h, nil
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("parsing triage history: %w", err)
+	}
+	if h.Entries == nil {
+		h.Entries = make(map[string]*HistoryEntry)
+	}
+	return h, nil
+}
+
+// historyStore loads and updates the triage history. update re-reads the latest state before
+// applying fn, so concurrent triage runs don't overwrite each other's entries.
+type historyStore interface {
+	load(ctx context.Context) (*History, error)
+	update(ctx context.Context, fn func(*History)) error
+}
+
+// newHistoryStore picks a store from HISTORY_STORE: "branch" keeps history.json on a dedicated
+// branch (HISTORY_BRANCH, default triage-history), "file" keeps it at HISTORY_FILE on the runner,
+// which suits self-hosted runners with a persistent disk. Empty disables history.
+func newHistoryStore(t *Triage) (historyStore, error) {
+	switch store := os.Getenv("HISTORY_STORE"); store {
+	case "", "off":
+		return nil, nil
+	case "branch":
+		branch := os.Getenv("HISTORY_BRANCH")
+		if branch == "" {
+			branch = defaultHistoryBranch
+		}
+		return &branchHistoryStore{client: t.fixClient, owner: t.owner, repo: t.repo, branch: branch}, nil
+	case "file":
+		path := os.Getenv("HISTORY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("HISTORY_FILE must be set when HISTORY_STORE=file")
+		}
+		return &fileHistoryStore{path: path}, nil
+	default:
+		return nil, fmt.Errorf("HISTORY_STORE must be branch, file or off, got: %s", store)
+	}
+}
+
+// branchHistoryStore keeps history.json on an orphan branch, written through the contents API.
+type branchHistoryStore struct {
+	client *github.Client
+	owner  string
+	repo   string
+	branch string
+}
+
+// read returns the history and the blob SHA needed to update it; an empty SHA means the file or
+// branch doesn't exist yet.
+func (s *branchHistoryStore) read(ctx context.Context) (*History, string, error) {
+	file, _, resp, err := s.client.Repositories.GetContents(ctx, s.owner, s.repo, historyFileName, &github.RepositoryContentGetOptions{Ref: s.branch})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		h, _ := decodeHistory(nil)
+		return h, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("reading triage history from %s: %w", s.branch, err)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding triage history: %w", err)
+	}
+	h, err := decodeHistory([]byte(content))
+	if err != nil {
+		return nil, "", err
+	}
+	return h, file.GetSHA(), nil
+}
+
+func (s *branchHistoryStore) load(ctx context.Context) (*History, error) {
+	h, _, err := s.read(ctx)
+	return h, err
+}
+
+func (s *branchHistoryStore) update(ctx context.Context, fn func(*History)) error {
+	var err error
+	for try := 0; try < historyUpdateTries; try++ {
+		var h *History
+		var sha string
+		if h, sha, err = s.read(ctx); err != nil {
+			return err
+		}
+		fn(h)
+		data := h.encode()
+
+		if sha == "" {
+			err = s.create(ctx, data)
+		} else {
+			_, _, err = s.client.Repositories.UpdateFile(ctx, s.owner, s.repo, historyFileName, &github.RepositoryContentFileOptions{
+				Message: github.Ptr("Update triage history"),
+				Content: data,
+				SHA:     github.Ptr(sha),
+				Branch:  github.Ptr(s.branch),
+			})
+		}
+		if err == nil {
+			return nil
+		}
+
+		// Another run wrote in between; re-read and apply fn again
+		var ghErr *github.ErrorResponse
+		if !errors.As(err, &ghErr) || (ghErr.Response.StatusCode != http.StatusConflict && ghErr.Response.StatusCode != http.StatusUnprocessableEntity) {
+			break
+		}
+		slog.Info("triage history changed concurrently, retrying", "try", try+1)
+	}
+	return fmt.Errorf("writing triage history to %s: %w", s.branch, err)
+}
+
+// create writes the first history.json. The branch is started as an orphan commit holding only
+// that file, so it shares no files with the default branch; if the branch already exists without
+// the file, the file is added to it instead, since creating the ref again would fail every time.
+func (s *branchHistoryStore) create(ctx context.Context, data []byte) error {
+	_, resp, err := s.client.Git.GetRef(ctx, s.owner, s.repo, "heads/"+s.branch)
+	switch {
+	case err == nil:
+		_, _, err = s.client.Repositories.CreateFile(ctx, s.owner, s.repo, historyFileName, &github.RepositoryContentFileOptions{
+			Message: github.Ptr("Start triage history"),
+			Content: data,
+			Branch:  github.Ptr(s.branch),
+		})
+		if err != nil {
+			return fmt.Errorf("creating %s on %s: %w", historyFileName, s.branch, err)
+		}
+		return nil
+	case resp == nil || resp.StatusCode != http.StatusNotFound:
+		return fmt.Errorf("looking up history branch: %w", err)
+	}
+
+	tree, _, err := s.client.Git.CreateTree(ctx, s.owner, s.repo, "", []*github.TreeEntry{{
+		Path:    github.Ptr(historyFileName),
+		Mode:    github.Ptr("100644"),
+		Type:    github.Ptr("blob"),
+		Content: github.Ptr(string(data)),
+	}})
+	if err != nil {
+		return fmt.Errorf("creating history tree: %w", err)
+	}
+	commit, _, err := s.client.Git.CreateCommit(ctx, s.owner, s.repo, github.Commit{
+		Message: github.Ptr("Start triage history"),
+		Tree:    tree,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("creating history commit: %w", err)
+	}
+	_, _, err = s.client.Git.CreateRef(ctx, s.owner, s.repo, github.CreateRef{
+		Ref: "refs/heads/" + s.branch,
+		SHA: commit.GetSHA(),
+	})
+	if err != nil {
+		return fmt.Errorf("creating history branch: %w", err)
+	}
+	slog.Info("created triage history branch", "branch", s.branch)
+	return nil
+}
+
+// fileHistoryStore keeps history in a local JSON file, replaced atomically on every update.
+type fileHistoryStore struct {
+	path string
+}
+
+func (s *fileHistoryStore) load(ctx context.Context) (*History, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading triage history: %w", err)
+	}
+	return decodeHistory(data)
+}
+
+func (s *fileHistoryStore) update(ctx context.Context, fn func(*History)) error {
+	h, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	fn(h)
+	data := h.encode()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("creating triage history directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".history-*")
+	if err != nil {
+		return fmt.Errorf("writing triage history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing triage history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing triage history: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing triage history: %w", err)
+	}
+	return nil
+}
+
+var (
+	sigUUID      = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	sigHex       = regexp.MustCompile(`(?i)\b(?:0x[0-9a-f]+|[0-9a-f]{7,})\b`)
+	sigTimestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`)
+	sigTempPath  = regexp.MustCompile(`(?:/tmp|/var/folders|/home/runner/work/_temp)/\S*`)
+	sigNumber    = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	sigErrorLine = regexp.MustCompile(`(?i)\b(error|fatal|failed|exception)\b`)
+)
+
+// normalizeForSignature strips the parts of an error message that change from run to run: ids,
+// addresses, timestamps, temp paths, durations and other numbers.
+func normalizeForSignature(s string) string {
+	s = sigUUID.ReplaceAllString(s, "<uuid>")
+	s = sigTimestamp.ReplaceAllString(s, "<time>")
+	s = sigTempPath.ReplaceAllString(s, "<tmp>")
+	s = sigHex.ReplaceAllString(s, "<hex>")
+	s = sigNumber.ReplaceAllString(s, "N")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// FailureSignature identifies a failure independently of the run it happened in. It is built
+// from each failing test's name, file and first message line, or when no tests were recognised,
+// from the failed jobs' names and their first error lines. The same failure in another workflow
+// or on another branch gets a different signature, so a fix or notification for one isn't
+// skipped as a repeat of the other.
+func (t *Triage) FailureSignature(ctx context.Context) string {
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		slog.Warn("error getting workflow run for failure signature", "err", err)
+		return ""
+	}
+
+	var parts []string
+	for _, f := range t.TestFailures(ctx) {
+		message, _, _ := strings.Cut(f.Message, "\n")
+		parts = append(parts, strings.Join([]string{f.Framework, f.Test, f.File, normalizeForSignature(message)}, "|"))
+	}
+
+	if len(parts) == 0 {
+		jobs, err := t.failedJobs(ctx)
+		if err != nil {
+			slog.Warn("error listing failed jobs for failure signature", "err", err)
+		}
+		for _, job := range jobs {
+			logs, err := t.downloadJobLogs(ctx, job.GetID())
+			if err != nil {
+				slog.Warn("error downloading logs for failure signature", "job", job.GetName(), "err", err)
+				continue
+			}
+			parts = append(parts, normalizeForSignature(job.GetName())+"|"+strings.Join(errorLines(logs, 5), "|"))
+		}
+	}
+
+	return failureSignature(signatureScope(run), parts)
+}
+
+// signatureScope is the workflow and branch a failure signature is kept to. A pull request's
+// failures are scoped to the branch it merges into, since its own branch is new every time.
+func signatureScope(run *github.WorkflowRun) string {
+	branch := run.GetHeadBranch()
+	if len(run.PullRequests) > 0 {
+		branch = run.PullRequests[0].GetBase().GetRef()
+	}
+	return fmt.Sprintf("%d|%s", run.GetWorkflowID(), branch)
+}
+
+// failureSignature hashes the scope and the failure's parts, in any order, into a signature.
+// It returns "" when there are no parts.
+func failureSignature(scope string, parts []string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	sort.Strings(parts)
+	sum := sha256.Sum256([]byte(scope + "\n" + strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// errorLines returns up to n normalised, distinct lines from a job log that look like errors.
+func errorLines(logs string, n int) []string {
+	var lines []string
+	seen := make(map[string]bool)
+	for _, line := range stripLogTimestamps(logs) {
+		if strings.HasPrefix(line, "##[") || !sigErrorLine.MatchString(line) {
+			continue
+		}
+		norm := normalizeForSignature(line)
+		if seen[norm] {
+			continue
+		}
+		seen[norm] = true
+		lines = append(lines, norm)
+		if len(lines) == n {
+			break
+		}
+	}
+	return lines
+}
+
+// RecallFailure computes the failure signature and returns the earlier diagnosis of the same
+// failure, with its count and last-seen time already including this run. It returns nil when
+// history is disabled or the failure is new.
+func (t *Triage) RecallFailure(ctx context.Context) *HistoryEntry {
+	if t.history == nil {
+		return nil
+	}
+	t.signature = t.FailureSignature(ctx)
+	if t.signature == "" {
+		slog.Info("no failure signature could be computed, skipping history")
+		return nil
+	}
+
+	h, err := t.history.load(ctx)
+	if err != nil {
+		slog.Warn("error loading triage history", "err", err)
+		return nil
+	}
+	prev := h.lookup(t.signature, time.Now())
+	if prev == nil {
+		slog.Info("failure not seen before", "signature", t.signature)
+		return nil
+	}
+
+	seen := *prev
+	seen.Count++
+	seen.LastSeen = time.Now()
+	seen.LastRunID = t.runID
+	slog.Info("failure seen before, reusing diagnosis", "signature", t.signature, "count", seen.Count, "firstRun", seen.RunID)
+	return &seen
+}
+
+// RecordFailure adds this run to the history: a repeat bumps the existing entry, a new failure
+// stores its diagnosis and fix PR for later runs to link to.
+func (t *Triage) RecordFailure(ctx context.Context, result *TriageResult, prURL string) {
+	if t.history == nil || t.signature == "" {
+		return
+	}
+	now := time.Now()
+	err := t.history.update(ctx, func(h *History) {
+		if e := h.lookup(t.signature, now); e != nil {
+			e.Count++
+			e.LastSeen = now
+			e.LastRunID = t.runID
+			for channel, thread := range t.threads {
+				if e.Threads == nil {
+					e.Threads = make(map[string]string)
+				}
+				if e.Threads[channel] == "" {
+					e.Threads[channel] = thread
+				}
+			}
+		} else {
+			h.Entries[t.signature] = &HistoryEntry{
+				Signature: t.signature,
+				FirstSeen: now,
+				LastSeen:  now,
+				Count:     1,
+				RunID:     t.runID,
+				RunURL:    fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d", t.owner, t.repo, t.runID),
+				LastRunID: t.runID,
+				Branch:    os.Getenv("GITHUB_REF_NAME"),
+				PRURL:     prURL,
+				Result:    *result,
+				Threads:   t.threads,
+			}
+		}
+		h.prune(now)
+	})
+	if err != nil {
+		slog.Warn("error recording triage history", "err", err)
+		return
+	}
+	slog.Info("recorded failure in triage history", "signature", t.signature)
+}
+
+// repeatSummary renders the "seen before" section of the PR comment for a repeated failure.
+func repeatSummary(e *HistoryEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n#### Seen Before\n\nThis failure (signature `%s`) has occurred %d time(s) since %s. It was first diagnosed in [run %d](%s); that diagnosis is reused above and no new fix was attempted.\n",
+		e.Signature, e.Count, e.FirstSeen.Format("2006-01-02"), e.RunID, e.RunURL)
+	if e.PRURL != "" {
+		fmt.Fprintf(&b, "\n🔧 Proposed fix from the first occurrence: %s\n", e.PRURL)
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestHistoryPruneAndEncode(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		entries   int
+		age       time.Duration // between consecutive entries
+		rootCause int           // characters of diagnosis per entry
+		keepMax   int
+	}{
+		{"small", 10, time.Hour, 100, 10},
+		{"past retention", 10, 5 * 24 * time.Hour, 100, 7},
+		{"too many entries", maxHistoryEntries + 50, time.Minute, 100, maxHistoryEntries},
+		{"too large", 300, time.Minute, 10_000, maxHistoryBytes / 10_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := decodeHistory(nil)
+			for i := range tt.entries {
+				sig := fmt.Sprintf("sig-%d", i)
+				h.Entries[sig] = &HistoryEntry{
+					Signature: sig,
+					LastSeen:  now.Add(-time.Duration(i) * tt.age),
+					Result:    TriageResult{RootCause: strings.Repeat("x", tt.rootCause)},
+				}
+			}
+			h.prune(now)
+			data := h.encode()
+
+			if len(data) > maxHistoryBytes {
+				t.Errorf("encoded %d bytes, more than %d", len(data), maxHistoryBytes)
+			}
+			if len(h.Entries) == 0 || len(h.Entries) > tt.keepMax {
+				t.Errorf("kept %d entries, want 1 to %d", len(h.Entries), tt.keepMax)
+			}
+			if h.Entries["sig-0"] == nil {
+				t.Errorf("dropped the most recent entry")
+			}
+			decoded, err := decodeHistory(data)
+			if err != nil || len(decoded.Entries) != len(h.Entries) {
+				t.Errorf("decoded %d entries (err %v), want %d", len(decoded.Entries), err, len(h.Entries))
+			}
+		})
+	}
+}
+
+func TestFailureSignature(t *testing.T) {
+	run := func(workflowID int64, headBranch string, baseBranch string) *github.WorkflowRun {
+		r := &github.WorkflowRun{WorkflowID: github.Ptr(workflowID), HeadBranch: github.Ptr(headBranch)}
+		if baseBranch != "" {
+			r.PullRequests = []*github.PullRequest{{Base: &github.PullRequestBranch{Ref: github.Ptr(baseBranch)}}}
+		}
+		return r
+	}
+	parts := []string{"go|TestA|a_test.go|got N, want N", "go|TestB|b_test.go|timeout"}
+	base := failureSignature(signatureScope(run(1, "main", "")), slices.Clone(parts))
+
+	tests := []struct {
+		name  string
+		run   *github.WorkflowRun
+		parts []string
+		same  bool
+	}{
+		{"same failure", run(1, "main", ""), parts, true},
+		{"parts in another order", run(1, "main", ""), []string{parts[1], parts[0]}, true},
+		{"other failure", run(1, "main", ""), parts[:1], false},
+		{"other workflow", run(2, "main", ""), parts, false},
+		{"other branch", run(1, "release-1.2", ""), parts, false},
+		{"pull request into the branch", run(1, "feature/x", "main"), parts, true},
+		{"pull request into another branch", run(1, "feature/x", "release-1.2"), parts, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := failureSignature(signatureScope(tt.run), slices.Clone(tt.parts))
+			if (got == base) != tt.same {
+				t.Errorf("signature %s, base %s: same = %v, want %v", got, base, got == base, tt.same)
+			}
+		})
+	}
+
+	if got := failureSignature("1|main", nil); got != "" {
+		t.Errorf("signature without parts = %q, want empty", got)
+	}
+}
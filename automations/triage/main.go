@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,9 +13,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "embed"
 
@@ -35,11 +38,35 @@ type TriageResult struct {
 	Confidence    string   `json:"confidence"`
 	Fixable       bool     `json:"fixable"`
 	AffectedFiles []string `json:"affectedFiles"`
+
+	// Rule names the known-failure rule that produced this result without a model call, see rules.go
+	Rule string `json:"rule,omitempty"`
+	// Model is the model that produced the diagnosis. Escalation names the diagnosis model and
+	// the reason when its answer was re-run with the fix model, see models.go
+	Model      string `json:"model,omitempty"`
+	Escalation string `json:"escalation,omitempty"`
+	// Evidence backs a "flaky" classification, see flaky.go
+	Evidence []string `json:"evidence,omitempty"`
+	// RerunStatus describes the outcome of re-running failed jobs, empty when no re-run was tried
+	RerunStatus string `json:"-"`
+	// Previous is the earlier occurrence of the same failure when the diagnosis was reused from history
+	Previous *HistoryEntry `json:"-"`
 }
 
-// FixResult represents the corrected files from the AI fix
+// FixResult represents the changes from the AI fix. The model may send search/replace edits,
+// unified diffs, whole files (small or new files only), deletes, renames and executable-bit
+// changes. Once applied, Files holds the final contents of every added or changed file, Deletes
+// every removed file and Executable every mode change; renames become a delete plus an add.
 type FixResult struct {
-	Files map[string]string `json:"files"`
+	Files      map[string]string `json:"files"`
+	Edits      []EditBlock       `json:"edits,omitempty"`
+	Patches    []FilePatch       `json:"patches,omitempty"`
+	Deletes    []string          `json:"deletes,omitempty"`
+	Renames    []FileRename      `json:"renames,omitempty"`
+	Executable map[string]bool   `json:"executable,omitempty"`
+
+	// Verification is the result of the last verification run, nil when none is configured
+	Verification *Verification `json:"-"`
 }
 
 // Message represents a chat message with optional tool calls
@@ -78,9 +105,10 @@ type FunctionDef struct {
 
 // ChatRequest represents the request to GitHub Models API
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Tools    []ToolDef `json:"tools,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Tools          []ToolDef       `json:"tools,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
 // ChatResponse represents the response from GitHub Models API
@@ -112,19 +140,63 @@ func (e *contentFilterError) Error() string {
 
 // Triage handles fetching failed job logs and AI analysis
 type Triage struct {
-	github         *github.Client
-	fixClient      *github.Client // client for creating fix PRs, may use a separate token
-	token          string
-	owner          string
-	repo           string
-	runID          int64
-	failedJobNames []string
-
-	// Model-dependent limits, set by resolveModel()
-	maxResultChars int
-	defaultTail    int
-	maxTail        int
-	model          string
+	github             *github.Client
+	fixClient          *github.Client // client for creating fix PRs, may use a separate token
+	token              string
+	owner              string
+	repo               string
+	runID              int64
+	failedJobNames     []string
+	testFailures       []TestFailure
+	testFailuresLoaded bool             // TestFailures has run; testFailures may be nil when none were found
+	junitReports       []*regexp.Regexp // JUNIT_REPORTS, see testfailures.go
+	logCache           map[int64]string
+
+	// Fix verification, see verify.go
+	verifyCommand string
+	verifyRepairs int
+	verifyTimeout time.Duration
+
+	// Re-run failed jobs instead of fixing when the failure is flaky
+	flakyRerun bool
+
+	// Notification channels and routing, see notify.go. threads holds the thread references of
+	// notifications sent for this failure, kept in its history entry
+	notify  *NotifyConfig
+	threads map[string]string
+
+	// How fixes are published: fixModePR or fixModeReview, see review.go. fixSuggested is set
+	// once a fix has been posted as review suggestions
+	fixMode      string
+	fixSuggested bool
+
+	// Tools offered to the model, see tools.go
+	tools *ToolRegistry
+
+	// Known-failure rules checked before the model, see rules.go
+	rules []*Rule
+
+	// Removes secrets and personal data from tool results, see redact.go
+	redactor *Redactor
+
+	// The repository's CODEOWNERS, see codeowners.go. nil when it has none
+	codeowners *CodeOwners
+
+	// What an auto-fix may change, see policy.go. policyViolations collects every change the
+	// policy refused, for the PR comment
+	fixPolicy        *FixPolicy
+	policyViolations []policyViolation
+
+	// Failure history, see history.go. history is nil when disabled
+	history   historyStore
+	signature string
+
+	// Models and their limits, set by resolveModel(), see models.go. model is the one requests
+	// currently go to: diagnosisModel for Analyze, fixModel for AttemptFix and escalations
+	model          *modelSettings
+	diagnosisModel *modelSettings
+	fixModel       *modelSettings
+	escalateOn     []string
 }
 
 // NewTriage creates a new Triage instance from environment variables
@@ -158,7 +230,49 @@ func NewTriage() (*Triage, error) {
 	if model == "" {
 		model = defaultModel
 	}
-	maxResultChars, defaultTail, maxTail := modelLimits(model)
+	limits, err := loadModelLimits()
+	if err != nil {
+		return nil, err
+	}
+	diagnosisModel, err := resolveModel(cmp.Or(os.Getenv("DIAGNOSIS_MODEL"), model), limits)
+	if err != nil {
+		return nil, err
+	}
+	fixModel := diagnosisModel
+	if name := cmp.Or(os.Getenv("FIX_MODEL"), model); name != diagnosisModel.name {
+		if fixModel, err = resolveModel(name, limits); err != nil {
+			return nil, err
+		}
+	}
+	escalateOn, err := parseEscalateOn(os.Getenv("ESCALATE_ON"))
+	if err != nil {
+		return nil, err
+	}
+
+	verifyRepairs := defaultVerifyRepairs
+	if v := os.Getenv("VERIFY_MAX_REPAIRS"); v != "" {
+		verifyRepairs, err = strconv.Atoi(v)
+		if err != nil || verifyRepairs < 0 {
+			return nil, fmt.Errorf("VERIFY_MAX_REPAIRS must be a non-negative integer, got: %s", v)
+		}
+	}
+
+	verifyTimeout := defaultVerifyTimeout
+	if v := os.Getenv("VERIFY_TIMEOUT"); v != "" {
+		verifyTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("VERIFY_TIMEOUT must be a duration like 10m: %w", err)
+		}
+	}
+
+	fixMode := os.Getenv("FIX_MODE")
+	switch fixMode {
+	case "":
+		fixMode = fixModePR
+	case fixModePR, fixModeReview:
+	default:
+		return nil, fmt.Errorf("FIX_MODE must be %q or %q, got: %s", fixModePR, fixModeReview, fixMode)
+	}
 
 	client := github.NewClient(nil).WithAuthToken(token)
 
@@ -168,102 +282,80 @@ func NewTriage() (*Triage, error) {
 		fixClient = github.NewClient(nil).WithAuthToken(fixToken)
 	}
 
-	return &Triage{
+	t := &Triage{
 		github:         client,
 		fixClient:      fixClient,
 		token:          token,
 		owner:          owner,
 		repo:           repo,
 		runID:          runID,
-		model:          model,
-		maxResultChars: maxResultChars,
-		defaultTail:    defaultTail,
-		maxTail:        maxTail,
-	}, nil
+		logCache:       make(map[int64]string),
+		model:          diagnosisModel,
+		diagnosisModel: diagnosisModel,
+		fixModel:       fixModel,
+		escalateOn:     escalateOn,
+		verifyCommand:  os.Getenv("VERIFY_COMMAND"),
+		verifyRepairs:  verifyRepairs,
+		verifyTimeout:  verifyTimeout,
+		flakyRerun:     os.Getenv("FLAKY_RERUN") == "true",
+		fixMode:        fixMode,
+		threads:        make(map[string]string),
+	}
+
+	t.history, err = newHistoryStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	t.rules, err = loadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	t.redactor, err = newRedactor()
+	if err != nil {
+		return nil, err
+	}
+
+	t.notify, err = loadNotifyConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	t.codeowners, err = loadCodeOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	t.junitReports, err = junitReportPatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	t.fixPolicy, err = t.loadFixPolicy(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	toolsConfig, err := loadToolsConfig()
+	if err != nil {
+		return nil, err
+	}
+	t.tools, err = t.newToolRegistry(toolsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
 }
 
 const (
-	modelsURL     = "https://models.github.ai/inference/chat/completions"
 	defaultModel  = "openai/gpt-4o"
 	maxToolRounds = 20
 )
 
-// modelLimits returns (maxToolResultChars, defaultTailLines, maxTailLines) based on model.
-// Small-context models like gpt-5 get aggressive truncation; large-context models get generous limits.
-func modelLimits(model string) (maxResultChars int, defaultTail int, maxTail int) {
-	switch {
-	case strings.Contains(model, "gpt-5"):
-		return 2_000, 50, 200
-	default:
-		return 50_000, 500, 2000
-	}
-}
-
-// triageTools returns the tool definitions for the triage conversation
-func (t *Triage) triageTools() []ToolDef {
-	return []ToolDef{
-		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "list_failed_jobs",
-				Description: "List all failed jobs in the current workflow run. Returns job names and IDs.",
-				Parameters: map[string]interface{}{
-					"type":       "object",
-					"properties": map[string]interface{}{},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "get_job_logs",
-				Description: "Get the last N lines of logs for a specific failed job. Use list_failed_jobs first to get job IDs.",
-				Parameters: map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"job_id": map[string]interface{}{
-							"type":        "integer",
-							"description": "The job ID to fetch logs for",
-						},
-						"tail_lines": map[string]interface{}{
-							"type":        "integer",
-							"description": "Number of lines from the end to return (default 200, max 1000)",
-						},
-					},
-					"required": []string{"job_id"},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "read_file",
-				Description: "Read the contents of a file in the repository checkout. Use this to inspect source files mentioned in error messages.",
-				Parameters: map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"path": map[string]interface{}{
-							"type":        "string",
-							"description": "Relative file path from the repository root",
-						},
-					},
-					"required": []string{"path"},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "get_workflow_run_info",
-				Description: "Get metadata about the current workflow run: branch, commit SHA, event type, workflow name.",
-				Parameters: map[string]interface{}{
-					"type":       "object",
-					"properties": map[string]interface{}{},
-				},
-			},
-		},
-	}
-}
+// modelsURL is the chat completions endpoint; tests point it at a local server.
+var modelsURL = "https://models.github.ai/inference/chat/completions"
 
 // truncateResult caps a tool result string to maxToolResultChars.
 func truncateResult(s string, maxChars int) string {
@@ -273,109 +365,38 @@ func truncateResult(s string, maxChars int) string {
 	return s[:maxChars] + "\n... (truncated)"
 }
 
-// estimateTokens gives a rough token count for the message history.
-// ~4 chars per token is a reasonable approximation for English/code.
-func estimateTokens(messages []Message) int {
-	total := 0
-	for _, m := range messages {
-		total += len(m.Content) / 4
-		for _, tc := range m.ToolCalls {
-			total += len(tc.Function.Arguments) / 4
-		}
-		// Per-message overhead
-		total += 4
+// cutAtRune returns at most the first n bytes of s, backing off to the start of a character so
+// a multi-byte one isn't split.
+func cutAtRune(s string, n int) string {
+	if n >= len(s) {
+		return s
 	}
-	return total
-}
-
-// compressMessages aggressively truncates tool result messages to reduce token count.
-// It keeps the system and user messages intact, and truncates tool results to 500 chars.
-func compressMessages(messages []Message) []Message {
-	const compressedMaxChars = 500
-	compressed := make([]Message, len(messages))
-	for i, m := range messages {
-		compressed[i] = m
-		if m.Role == "tool" && len(m.Content) > compressedMaxChars {
-			compressed[i].Content = m.Content[:compressedMaxChars] + "\n... (truncated to fit token limit)"
-		}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return compressed
+	return s[:n]
 }
 
-// sanitizeMessages replaces words in tool results that commonly trigger Azure's content filter.
-// CI logs frequently contain terms like "kill", "fatal", "panic", "abort" in normal software
-// contexts (e.g. SIGKILL, fatal error, panic stack trace) that trip the self-harm filter.
-func sanitizeMessages(messages []Message) []Message {
-	replacer := strings.NewReplacer(
-		"kill", "end",
-		"Kill", "End",
-		"KILL", "END",
-		"killed", "ended",
-		"Killed", "Ended",
-		"KILLED", "ENDED",
-		"suicide", "shutdown",
-		"abort", "stop",
-		"Abort", "Stop",
-		"ABORT", "STOP",
-		"aborted", "stopped",
-		"Aborted", "Stopped",
-		"ABORTED", "STOPPED",
-		"hang", "stall",
-		"Hang", "Stall",
-		"HANG", "STALL",
-		"hanging", "stalling",
-		"fatal", "critical",
-		"Fatal", "Critical",
-		"FATAL", "CRITICAL",
-		"dead", "inactive",
-		"Dead", "Inactive",
-		"DEAD", "INACTIVE",
-		"deadlock", "lockup",
-		"Deadlock", "Lockup",
-		"DEADLOCK", "LOCKUP",
-		"panic", "crash",
-		"Panic", "Crash",
-		"PANIC", "CRASH",
-		"die", "fail",
-		"Die", "Fail",
-		"DIE", "FAIL",
-		"dying", "failing",
-		"death", "failure",
-		"destroy", "remove",
-		"Destroy", "Remove",
-		"DESTROY", "REMOVE",
-	)
-
-	sanitized := make([]Message, len(messages))
-	for i, m := range messages {
-		sanitized[i] = m
-		if m.Role == "tool" || m.Role == "user" || m.Role == "system" {
-			sanitized[i].Content = replacer.Replace(m.Content)
-		}
+// failedJobs returns the jobs in the current run that concluded with a failure.
+func (t *Triage) failedJobs(ctx context.Context) ([]*github.WorkflowJob, error) {
+	jobs, _, err := t.github.Actions.ListWorkflowJobs(ctx, t.owner, t.repo, t.runID, &github.ListWorkflowJobsOptions{
+		Filter: "all",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
 	}
-	return sanitized
-}
 
-// executeTool runs a tool call and returns the result string
-func (t *Triage) executeTool(ctx context.Context, name string, argsJSON string) string {
-	switch name {
-	case "list_failed_jobs":
-		return t.toolListFailedJobs(ctx)
-	case "get_job_logs":
-		return t.toolGetJobLogs(ctx, argsJSON)
-	case "read_file":
-		return t.toolReadFile(argsJSON)
-	case "get_workflow_run_info":
-		return t.toolGetWorkflowRunInfo(ctx)
-	default:
-		return fmt.Sprintf("unknown tool: %s", name)
+	var failed []*github.WorkflowJob
+	for _, job := range jobs.Jobs {
+		if job.GetConclusion() == "failure" {
+			failed = append(failed, job)
+		}
 	}
+	return failed, nil
 }
 
 func (t *Triage) toolListFailedJobs(ctx context.Context) string {
-	jobs, _, err := t.github.Actions.ListWorkflowJobs(ctx, t.owner, t.repo, t.runID, &github.ListWorkflowJobsOptions{
-		Filter: "all",
-	})
+	jobs, err := t.failedJobs(ctx)
 	if err != nil {
 		return fmt.Sprintf("error listing jobs: %v", err)
 	}
@@ -388,15 +409,13 @@ func (t *Triage) toolListFailedJobs(ctx context.Context) string {
 	}
 
 	var failed []jobInfo
-	for _, job := range jobs.Jobs {
-		if job.GetConclusion() == "failure" {
-			failed = append(failed, jobInfo{
-				ID:         job.GetID(),
-				Name:       job.GetName(),
-				Conclusion: job.GetConclusion(),
-				Status:     job.GetStatus(),
-			})
-		}
+	for _, job := range jobs {
+		failed = append(failed, jobInfo{
+			ID:         job.GetID(),
+			Name:       job.GetName(),
+			Conclusion: job.GetConclusion(),
+			Status:     job.GetStatus(),
+		})
 	}
 
 	// Track names for Slack notification
@@ -423,10 +442,10 @@ func (t *Triage) toolGetJobLogs(ctx context.Context, argsJSON string) string {
 		return fmt.Sprintf("error parsing arguments: %v", err)
 	}
 	if args.TailLines <= 0 {
-		args.TailLines = t.defaultTail
+		args.TailLines = t.model.DefaultTailLines
 	}
-	if args.TailLines > t.maxTail {
-		args.TailLines = t.maxTail
+	if args.TailLines > t.model.MaxTailLines {
+		args.TailLines = t.model.MaxTailLines
 	}
 
 	logs, err := t.downloadJobLogs(ctx, args.JobID)
@@ -437,6 +456,27 @@ func (t *Triage) toolGetJobLogs(ctx context.Context, argsJSON string) string {
 	return truncateLogs(logs, args.TailLines)
 }
 
+// cleanRelPath cleans a model-supplied path and reports whether it stays inside the repository
+// and out of its .git directory.
+func cleanRelPath(path string) (string, bool) {
+	cleanPath := filepath.Clean(path)
+	if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
+		return "", false
+	}
+	if first, _, _ := strings.Cut(filepath.ToSlash(cleanPath), "/"); strings.EqualFold(first, ".git") {
+		return "", false
+	}
+	return cleanPath, true
+}
+
+// workspaceDir is the repository checkout: GITHUB_WORKSPACE or the current directory.
+func workspaceDir() string {
+	if workspace := os.Getenv("GITHUB_WORKSPACE"); workspace != "" {
+		return workspace
+	}
+	return "."
+}
+
 func (t *Triage) toolReadFile(argsJSON string) string {
 	var args struct {
 		Path string `json:"path"`
@@ -445,16 +485,15 @@ func (t *Triage) toolReadFile(argsJSON string) string {
 		return fmt.Sprintf("error parsing arguments: %v", err)
 	}
 
-	// Workspace is the GITHUB_WORKSPACE or current directory
-	workspace := os.Getenv("GITHUB_WORKSPACE")
-	if workspace == "" {
-		workspace = "."
-	}
+	workspace := workspaceDir()
 
-	cleanPath := filepath.Clean(args.Path)
-	if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
+	cleanPath, ok := cleanRelPath(args.Path)
+	if !ok {
 		return "error: path must be relative and within the repository"
 	}
+	if err := checkSymlinks(workspace, cleanPath); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
 
 	fullPath := filepath.Join(workspace, cleanPath)
 	content, err := os.ReadFile(fullPath)
@@ -539,6 +578,9 @@ func (t *Triage) chat(ctx context.Context, req ChatRequest) (*ChatResponse, erro
 			if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "content_filter") {
 				return nil, &contentFilterError{body: string(body)}
 			}
+			if resp.StatusCode == http.StatusBadRequest && req.ResponseFormat != nil && strings.Contains(string(body), "response_format") {
+				return nil, &responseFormatError{body: string(body)}
+			}
 			return nil, fmt.Errorf("models API error (status %d): %s", resp.StatusCode, string(body))
 		}
 
@@ -559,31 +601,56 @@ func (t *Triage) chat(ctx context.Context, req ChatRequest) (*ChatResponse, erro
 }
 
 // runToolLoop runs a multi-turn conversation with tool calls until the model
-// returns a final text response or we hit the max rounds.
-func (t *Triage) runToolLoop(ctx context.Context, systemPrompt string, userPrompt string, tools []ToolDef) (string, error) {
+// returns a final text response or we hit the max rounds. With structured output enabled the
+// request carries format; accept parses the final answer, and when it fails the model is asked
+// to correct it, up to formatRepairs times.
+func (t *Triage) runToolLoop(ctx context.Context, systemPrompt string, userPrompt string, tools []ToolDef, format *ResponseFormat, accept func(string) error) (string, error) {
+	// The history is kept with original text; every request masks content-filter trigger words
+	// and every reply is unmasked before it's used, so tools and callers never see placeholders.
+	mask := newMasker()
+	window := t.newContextManager(mask)
+	repairs := 0
 	messages := []Message{
-		{Role: "system", Content: systemPrompt},
+		{Role: "system", Content: systemPrompt + maskNotice},
 		{Role: "user", Content: userPrompt},
 	}
 
 	for round := 0; round < maxToolRounds; round++ {
+		// Older tool results are summarised before the request would exceed the prompt limit
+		var responseFormat *ResponseFormat
+		if t.model.structuredOutput {
+			responseFormat = format
+		}
+		messages = window.fit(ctx, messages, tools, responseFormat)
 		req := ChatRequest{
-			Model:    t.model,
-			Messages: sanitizeMessages(messages),
-			Tools:    tools,
+			Model:          t.model.name,
+			Messages:       mask.maskMessages(messages),
+			Tools:          tools,
+			ResponseFormat: responseFormat,
 		}
 
 		resp, err := t.chat(ctx, req)
+		var rfe *responseFormatError
+		if errors.As(err, &rfe) {
+			// Fall back to prompt-only JSON for the rest of the run. The retry's own errors are
+			// handled below like any other request's.
+			slog.Warn("model does not support structured output, falling back to prompt-only JSON", "model", t.model.name)
+			t.model.structuredOutput = false
+			req.ResponseFormat = nil
+			resp, err = t.chat(ctx, req)
+		}
 		if err != nil {
 			var tle *tokenLimitError
 			var cfe *contentFilterError
 			if errors.As(err, &tle) {
-				slog.Warn("token limit exceeded, compressing conversation history", "round", round)
-				messages = compressMessages(messages)
-				req.Messages = sanitizeMessages(messages)
+				// Our count was under the real limit; compact harder and retry once
+				slog.Warn("token limit exceeded, compacting conversation history", "round", round)
+				window.shrink()
+				messages = window.fit(ctx, messages, tools, req.ResponseFormat)
+				req.Messages = mask.maskMessages(messages)
 				resp, err = t.chat(ctx, req)
 				if err != nil {
-					return "", fmt.Errorf("chat round %d (after compress): %w", round, err)
+					return "", fmt.Errorf("chat round %d (after compaction): %w", round, err)
 				}
 			} else if errors.As(err, &cfe) {
 				// Content filter is non-deterministic; retry up to 2 times
@@ -604,7 +671,7 @@ func (t *Triage) runToolLoop(ctx context.Context, systemPrompt string, userPromp
 			}
 		}
 
-		msg := resp.Choices[0].Message
+		msg := mask.unmaskMessage(resp.Choices[0].Message)
 		finishReason := resp.Choices[0].FinishReason
 
 		// Append the assistant message to the conversation
@@ -612,15 +679,27 @@ func (t *Triage) runToolLoop(ctx context.Context, systemPrompt string, userPromp
 
 		// If the model didn't make tool calls, we're done
 		if finishReason != "tool_calls" || len(msg.ToolCalls) == 0 {
-			slog.Info("tool loop complete", "rounds", round+1, "finishReason", finishReason)
-			return strings.TrimSpace(msg.Content), nil
+			answer := strings.TrimSpace(msg.Content)
+			if accept != nil {
+				if err := accept(answer); err != nil {
+					if repairs >= formatRepairs {
+						return "", fmt.Errorf("%w (response: %s)", err, truncateResult(answer, 500))
+					}
+					repairs++
+					slog.Warn("final answer rejected, asking the model to correct it", "repair", repairs, "err", err)
+					messages = append(messages, Message{Role: "user", Content: formatRepairPrompt(err)})
+					continue
+				}
+			}
+			slog.Info("tool loop complete", "rounds", round+1, "finishReason", finishReason, "repairs", repairs, "maskedWords", mask.Count())
+			return answer, nil
 		}
 
 		// Execute each tool call and append results
 		for _, tc := range msg.ToolCalls {
 			slog.Info("executing tool call", "tool", tc.Function.Name, "id", tc.ID)
-			result := t.executeTool(ctx, tc.Function.Name, tc.Function.Arguments)
-			result = truncateResult(result, t.maxResultChars)
+			result := t.tools.Execute(ctx, tc.Function.Name, tc.Function.Arguments)
+			result = truncateResult(t.redactor.Redact(result), t.model.MaxResultChars)
 			messages = append(messages, Message{
 				Role:       "tool",
 				Content:    result,
@@ -685,7 +764,35 @@ func extractJSON(s string) string {
 
 // Analyze runs the AI triage with tool calling
 func (t *Triage) Analyze(ctx context.Context) (*TriageResult, error) {
-	slog.Info("starting triage analysis with tool calling")
+	// Well-known failures get a deterministic diagnosis without a model call
+	if result := t.MatchRules(ctx); result != nil {
+		return result, nil
+	}
+
+	result, err := t.diagnose(ctx, t.diagnosisModel)
+	reason := t.escalation(result, err)
+	if reason == "" {
+		return result, err
+	}
+
+	// The cheaper diagnosis model wasn't sure enough; ask the fix model for a second opinion
+	slog.Info("escalating diagnosis to the fix model", "from", t.diagnosisModel.name, "to", t.fixModel.name, "reason", reason)
+	escalated, escalationErr := t.diagnose(ctx, t.fixModel)
+	if escalationErr != nil {
+		if err != nil {
+			return nil, escalationErr
+		}
+		slog.Warn("escalated diagnosis failed, keeping the first one", "err", escalationErr)
+		return result, nil
+	}
+	escalated.Escalation = fmt.Sprintf("%s (%s)", t.diagnosisModel.name, reason)
+	return escalated, nil
+}
+
+// diagnose runs the triage conversation with one model.
+func (t *Triage) diagnose(ctx context.Context, model *modelSettings) (*TriageResult, error) {
+	t.useModel(model)
+	slog.Info("starting triage analysis with tool calling", "model", model.name)
 
 	userPrompt := fmt.Sprintf(
 		"Triage the CI failure for workflow run %d in %s/%s. "+
@@ -694,24 +801,19 @@ func (t *Triage) Analyze(ctx context.Context) (*TriageResult, error) {
 		t.runID, t.owner, t.repo,
 	)
 
-	response, err := t.runToolLoop(ctx, triagePrompt, userPrompt, t.triageTools())
+	var result *TriageResult
+	_, err := t.runToolLoop(ctx, triagePrompt, userPrompt, t.tools.Definitions(scopeTriage), triageResultFormat, func(response string) error {
+		var err error
+		result, err = parseTriageResult(response)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("triage tool loop: %w", err)
 	}
+	result.Model = model.name
 
-	response = extractJSON(response)
-
-	var result TriageResult
-	if err := json.Unmarshal([]byte(response), &result); err != nil {
-		truncatedResp := response
-		if len(truncatedResp) > 500 {
-			truncatedResp = truncatedResp[:500] + "... (truncated)"
-		}
-		return nil, fmt.Errorf("parsing triage result: %w (response: %s)", err, truncatedResp)
-	}
-
-	slog.Info("triage analysis complete", "category", result.Category, "confidence", result.Confidence, "fixable", result.Fixable)
-	return &result, nil
+	slog.Info("triage analysis complete", "model", model.name, "category", result.Category, "confidence", result.Confidence, "fixable", result.Fixable)
+	return result, nil
 }
 
 // AttemptFix attempts to generate corrected file contents for the triage result
@@ -721,7 +823,8 @@ func (t *Triage) AttemptFix(ctx context.Context, triageResult *TriageResult) (*F
 		return nil, nil
 	}
 
-	slog.Info("attempting auto-fix", "affectedFiles", triageResult.AffectedFiles)
+	t.useModel(t.fixModel)
+	slog.Info("attempting auto-fix", "model", t.fixModel.name, "affectedFiles", triageResult.AffectedFiles)
 
 	var filesHint string
 	if len(triageResult.AffectedFiles) > 0 {
@@ -729,79 +832,117 @@ func (t *Triage) AttemptFix(ctx context.Context, triageResult *TriageResult) (*F
 	}
 
 	userPrompt := fmt.Sprintf(
-		"Fix the CI failure.\n\n**Root Cause:**\n%s\n\n**Suggested Fix:**\n%s%s\n\n"+
-			"Use the read_file tool to examine the relevant files, then respond with your final JSON containing the corrected file contents.",
+		"Fix the CI failure.\n\n**Root Cause:**\n%s\n\n**Suggested Fix:**\n%s%s%s\n\n"+
+			"Use the read_file tool to examine the relevant files, then respond with your final JSON containing your changes.",
 		triageResult.RootCause,
 		triageResult.SuggestedFix,
 		filesHint,
+		t.fixPolicy.describe(triageResult.AffectedFiles),
 	)
 
-	// Fix only needs read_file
-	tools := []ToolDef{
-		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "read_file",
-				Description: "Read the contents of a file in the repository checkout.",
-				Parameters: map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"path": map[string]interface{}{
-							"type":        "string",
-							"description": "Relative file path from the repository root",
-						},
-					},
-					"required": []string{"path"},
-				},
-			},
-		},
-	}
-
-	response, err := t.runToolLoop(ctx, fixPrompt, userPrompt, tools)
-	if err != nil {
-		return nil, fmt.Errorf("fix tool loop: %w", err)
-	}
-
-	response = extractJSON(response)
+	workspace := workspaceDir()
+	snapshot := newWorkspaceSnapshot(workspace)
+	fixResult := &FixResult{Files: make(map[string]string)}
+	prompt := userPrompt
 
-	var fixResult FixResult
-	if err := json.Unmarshal([]byte(response), &fixResult); err != nil {
-		return nil, fmt.Errorf("parsing fix result: %w", err)
-	}
-
-	if len(fixResult.Files) == 0 {
-		slog.Warn("AI returned no file changes")
-		return nil, nil
-	}
+	// Propose a fix, write it, and verify it. When verification fails the output goes back to
+	// the model for a bounded number of repair rounds; a fix that never passes is rolled back.
+	// Changes the fix policy refuses are never written, and go back to the model the same way.
+	for attempt := 1; ; attempt++ {
+		proposed, err := t.proposeFix(ctx, prompt, t.tools.Definitions(scopeFix))
+		if err != nil {
+			snapshot.restore()
+			return nil, err
+		}
+		if proposed.isEmpty() {
+			snapshot.restore()
+			if fixResult.Verification != nil {
+				return nil, &verificationError{verification: fixResult.Verification}
+			}
+			if len(t.policyViolations) > 0 {
+				return nil, &policyError{violations: t.policyViolations}
+			}
+			slog.Warn("AI returned no file changes")
+			return nil, nil
+		}
 
-	// Resolve workspace for writing files
-	workspace := os.Getenv("GITHUB_WORKSPACE")
-	if workspace == "" {
-		workspace = "."
-	}
+		changes, failures := applyFix(workspace, proposed)
+		refused := t.fixPolicy.filterChanges(changes, triageResult.AffectedFiles)
+		t.policyViolations = appendViolations(t.policyViolations, refused...)
+		if err := writeChanges(workspace, snapshot, changes); err != nil {
+			snapshot.restore()
+			return nil, err
+		}
+		fixResult.merge(changes)
+
+		// A fix over the size limits is abandoned rather than repaired; it's a sign the
+		// diagnosis needs a human, not a smaller patch
+		if oversized := t.fixPolicy.sizeViolations(fixResult, snapshot); len(oversized) > 0 {
+			t.policyViolations = appendViolations(t.policyViolations, oversized...)
+			snapshot.restore()
+			return nil, &policyError{violations: oversized}
+		}
 
-	// Write corrected files to disk
-	for filePath, content := range fixResult.Files {
-		cleanPath := filepath.Clean(filePath)
-		if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
-			slog.Warn("ignoring unsafe file path from AI", "path", filePath)
+		if len(failures) > 0 || len(refused) > 0 {
+			if attempt > t.verifyRepairs {
+				snapshot.restore()
+				if len(refused) > 0 {
+					return nil, &policyError{violations: refused}
+				}
+				return nil, &patchError{failures: failures}
+			}
+			slog.Warn("some fix changes failed to apply or were refused by the fix policy, asking the model to redo them",
+				"attempt", attempt, "failed", len(failures), "refused", len(refused))
+			prompt = userPrompt
+			if len(failures) > 0 {
+				prompt = patchRepairPrompt(prompt, failures)
+			}
+			if len(refused) > 0 {
+				prompt = policyRepairPrompt(prompt, refused)
+			}
 			continue
 		}
 
-		fullPath := filepath.Join(workspace, cleanPath)
-		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
-			return nil, fmt.Errorf("writing corrected file %s: %w", cleanPath, err)
+		if t.verifyCommand == "" {
+			break
 		}
-		slog.Info("wrote corrected file", "path", cleanPath)
+
+		v := t.runVerification(ctx)
+		v.Attempts = attempt
+		fixResult.Verification = v
+		if v.Passed {
+			break
+		}
+		if attempt > t.verifyRepairs {
+			snapshot.restore()
+			return nil, &verificationError{verification: v}
+		}
+
+		slog.Warn("fix failed verification, asking the model to repair it", "attempt", attempt, "exitCode", v.ExitCode)
+		prompt = repairPrompt(userPrompt, v)
 	}
 
-	slog.Info("auto-fix complete", "filesChanged", len(fixResult.Files))
-	return &fixResult, nil
+	slog.Info("auto-fix complete", "changes", describeChanges(fixResult))
+	return fixResult, nil
+}
+
+// proposeFix runs the fix conversation and parses the model's corrected files.
+func (t *Triage) proposeFix(ctx context.Context, userPrompt string, tools []ToolDef) (*FixResult, error) {
+	var fixResult *FixResult
+	_, err := t.runToolLoop(ctx, fixPrompt, userPrompt, tools, fixResultFormat, func(response string) error {
+		var err error
+		fixResult, err = parseFixResult(response)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fix tool loop: %w", err)
+	}
+	return fixResult, nil
 }
 
 // CreateFixPR creates a new branch and pull request with the fixed files
 func (t *Triage) CreateFixPR(ctx context.Context, triageResult *TriageResult, fixResult *FixResult) (string, error) {
-	if fixResult == nil || len(fixResult.Files) == 0 {
+	if fixResult == nil || len(fixResult.changedPaths()) == 0 {
 		slog.Info("no fix result to create PR from")
 		return "", nil
 	}
@@ -838,17 +979,34 @@ func (t *Triage) CreateFixPR(ctx context.Context, triageResult *TriageResult, fi
 	}
 
 	fileModes := make(map[string]string)
+	fileSHAs := make(map[string]string)
 	for _, entry := range baseTree.Entries {
 		if entry.Path != nil && entry.Mode != nil {
 			fileModes[*entry.Path] = *entry.Mode
+			fileSHAs[*entry.Path] = entry.GetSHA()
 		}
 	}
 
+	// modeFor picks the git mode for a path, applying any executable-bit change from the fix
+	modeFor := func(path string) string {
+		mode := fileModes[path]
+		if exec, ok := fixResult.Executable[path]; ok {
+			if exec {
+				return "100755"
+			}
+			return "100644"
+		}
+		if mode == "" {
+			mode = "100644"
+		}
+		return mode
+	}
+
 	// Create blobs for each changed file
 	var treeEntries []*github.TreeEntry
 	for filePath, content := range fixResult.Files {
-		cleanPath := filepath.Clean(filePath)
-		if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
+		cleanPath, ok := cleanRelPath(filePath)
+		if !ok {
 			slog.Warn("ignoring unsafe file path from AI", "path", filePath)
 			continue
 		}
@@ -861,19 +1019,43 @@ func (t *Triage) CreateFixPR(ctx context.Context, triageResult *TriageResult, fi
 			return "", fmt.Errorf("creating blob for %s: %w", cleanPath, err)
 		}
 
-		mode := fileModes[cleanPath]
-		if mode == "" {
-			mode = "100644"
-		}
-
 		treeEntries = append(treeEntries, &github.TreeEntry{
 			Path: github.Ptr(cleanPath),
-			Mode: github.Ptr(mode),
+			Mode: github.Ptr(modeFor(cleanPath)),
 			Type: github.Ptr("blob"),
 			SHA:  blob.SHA,
 		})
 	}
 
+	// A tree entry with a nil SHA removes the path from the tree
+	for _, path := range fixResult.Deletes {
+		if _, ok := fileSHAs[path]; !ok {
+			continue
+		}
+		treeEntries = append(treeEntries, &github.TreeEntry{
+			Path: github.Ptr(path),
+			Mode: github.Ptr(fileModes[path]),
+			Type: github.Ptr("blob"),
+		})
+	}
+
+	// Mode-only changes reuse the existing blob
+	for path := range fixResult.Executable {
+		if _, changed := fixResult.Files[path]; changed {
+			continue
+		}
+		sha, ok := fileSHAs[path]
+		if !ok {
+			continue
+		}
+		treeEntries = append(treeEntries, &github.TreeEntry{
+			Path: github.Ptr(path),
+			Mode: github.Ptr(modeFor(path)),
+			Type: github.Ptr("blob"),
+			SHA:  github.Ptr(sha),
+		})
+	}
+
 	tree, _, err := t.fixClient.Git.CreateTree(ctx, t.owner, t.repo, baseCommit.Tree.GetSHA(), treeEntries)
 	if err != nil {
 		return "", fmt.Errorf("creating tree: %w", err)
@@ -927,11 +1109,13 @@ func (t *Triage) CreateFixPR(ctx context.Context, triageResult *TriageResult, fi
 	}
 
 	prTitle := fmt.Sprintf("fix: auto-triage %s", triageResult.Category)
-	prBody := fmt.Sprintf("## Auto-Triage Fix\n\n**Category:** %s\n**Confidence:** %s\n\n**Root Cause:**\n%s\n\n**Suggested Fix:**\n%s",
+	prBody := fmt.Sprintf("## Auto-Triage Fix\n\n**Category:** %s\n**Confidence:** %s\n**Changes:** %s\n\n**Root Cause:**\n%s\n\n**Suggested Fix:**\n%s%s",
 		triageResult.Category,
 		triageResult.Confidence,
+		describeChanges(fixResult),
 		triageResult.RootCause,
 		triageResult.SuggestedFix,
+		verificationSummary(fixResult.Verification),
 	)
 
 	pr, _, err := t.fixClient.PullRequests.Create(ctx, t.owner, t.repo, &github.NewPullRequest{
@@ -950,8 +1134,13 @@ func (t *Triage) CreateFixPR(ctx context.Context, triageResult *TriageResult, fi
 	return prURL, nil
 }
 
-// downloadJobLogs downloads the raw logs for a specific job
+// downloadJobLogs downloads the raw logs for a specific job. Logs are cached for the run since
+// several tools and the PR comment read the same jobs.
 func (t *Triage) downloadJobLogs(ctx context.Context, jobID int64) (string, error) {
+	if logs, ok := t.logCache[jobID]; ok {
+		return logs, nil
+	}
+
 	url, _, err := t.github.Actions.GetWorkflowJobLogs(ctx, t.owner, t.repo, jobID, 2)
 	if err != nil {
 		return "", fmt.Errorf("getting log download URL: %w", err)
@@ -978,6 +1167,7 @@ func (t *Triage) downloadJobLogs(ctx context.Context, jobID int64) (string, erro
 		return "", fmt.Errorf("reading log body: %w", err)
 	}
 
+	t.logCache[jobID] = string(body)
 	return string(body), nil
 }
 
@@ -1000,114 +1190,9 @@ func truncateLogs(logs string, maxLines int) string {
 	return strings.Join(lines, "\n")
 }
 
-// NotifySlack sends a Block Kit formatted message to Slack webhook
-func (t *Triage) NotifySlack(ctx context.Context, triageResult *TriageResult, prURL string) error {
-	webhookURL := os.Getenv("SLACK_WEBHOOK_URL")
-	if webhookURL == "" {
-		slog.Warn("SLACK_WEBHOOK_URL not set, skipping Slack notification")
-		return nil
-	}
-
-	runURL := fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d", t.owner, t.repo, t.runID)
-
-	rootCause := triageResult.RootCause
-	if len([]rune(rootCause)) > 2900 {
-		runes := []rune(rootCause)
-		rootCause = string(runes[:2900]) + "..."
-	}
-
-	var fixStatus string
-	if prURL != "" {
-		fixStatus = fmt.Sprintf(":wrench: Auto-fix PR: <%s|View PR>", prURL)
-	} else if !triageResult.Fixable {
-		fixStatus = "No auto-fix attempted — issue not auto-fixable"
-	} else {
-		fixStatus = "Auto-fix attempted but failed"
-	}
-
-	failedJobs := strings.Join(t.failedJobNames, ", ")
-	if failedJobs == "" {
-		failedJobs = "unknown"
-	}
-
-	blocks := []map[string]interface{}{
-		{
-			"type": "header",
-			"text": map[string]string{
-				"type": "plain_text",
-				"text": fmt.Sprintf(":rotating_light: CI Failure: %s/%s", t.owner, t.repo),
-			},
-		},
-		{
-			"type": "section",
-			"text": map[string]string{
-				"type": "mrkdwn",
-				"text": fmt.Sprintf("*Category:* %s\n*Confidence:* %s\n*Failed Jobs:* %s\n*Run:* <%s|View Run>",
-					triageResult.Category,
-					triageResult.Confidence,
-					failedJobs,
-					runURL,
-				),
-			},
-		},
-		{"type": "divider"},
-		{
-			"type": "section",
-			"text": map[string]string{
-				"type": "mrkdwn",
-				"text": fmt.Sprintf("*Root Cause:*\n%s", rootCause),
-			},
-		},
-		{
-			"type": "section",
-			"text": map[string]string{
-				"type": "mrkdwn",
-				"text": fixStatus,
-			},
-		},
-		{
-			"type": "context",
-			"elements": []map[string]string{
-				{
-					"type": "mrkdwn",
-					"text": fmt.Sprintf("Triaged by yo-go | %s", time.Now().Format(time.RFC3339)),
-				},
-			},
-		},
-	}
-
-	payload := map[string]interface{}{"blocks": blocks}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("marshaling Slack payload: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("creating Slack request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("posting to Slack webhook: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		slog.Warn("Slack webhook returned non-200 status", "status", resp.StatusCode, "body", string(body))
-		return fmt.Errorf("Slack webhook returned status %d", resp.StatusCode)
-	}
-
-	slog.Info("successfully sent Slack notification")
-	return nil
-}
-
-// CommentOnPR posts the triage result as a comment on the associated pull request.
+// CommentOnPR records the triage result in the triage comment on every pull request the run
+// belongs to. Each pull request has one comment, found by a hidden marker and updated in place,
+// with a section per workflow and a history of every triaged run.
 func (t *Triage) CommentOnPR(ctx context.Context, triageResult *TriageResult, prURL string, fixErr error) error {
 	// Get the workflow run to find associated PRs
 	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
@@ -1115,49 +1200,108 @@ func (t *Triage) CommentOnPR(ctx context.Context, triageResult *TriageResult, pr
 		return fmt.Errorf("getting workflow run: %w", err)
 	}
 
-	if len(run.PullRequests) == 0 {
+	prs, err := t.runPullRequests(ctx, run)
+	if err != nil {
+		return err
+	}
+	if len(prs) == 0 {
 		slog.Info("no pull request associated with this run, skipping PR comment")
 		return nil
 	}
 
-	prNumber := run.PullRequests[0].GetNumber()
-
 	var body strings.Builder
-	body.WriteString("## 🔍 CI Failure Triage\n\n")
+	body.WriteString(fmt.Sprintf("### ❌ %s · [run #%d](%s)\n\n", run.GetName(), run.GetRunNumber(), run.GetHTMLURL()))
 	body.WriteString(fmt.Sprintf("| | |\n|---|---|\n| **Category** | `%s` |\n| **Confidence** | %s |\n| **Auto-fixable** | %v |\n\n",
 		triageResult.Category,
 		triageResult.Confidence,
 		triageResult.Fixable,
 	))
-	body.WriteString(fmt.Sprintf("### Root Cause\n\n%s\n\n", triageResult.RootCause))
-	body.WriteString(fmt.Sprintf("### Suggested Fix\n\n%s\n", triageResult.SuggestedFix))
+	if triageResult.Rule != "" {
+		body.WriteString(fmt.Sprintf("📚 Matched known-failure rule `%s`\n\n", triageResult.Rule))
+	}
+	if triageResult.Escalation != "" {
+		body.WriteString(fmt.Sprintf("⬆️ Diagnosed by `%s` after escalating from `%s`\n\n", triageResult.Model, triageResult.Escalation))
+	}
+	body.WriteString(fmt.Sprintf("#### Root Cause\n\n%s\n\n", triageResult.RootCause))
+	body.WriteString(fmt.Sprintf("#### Suggested Fix\n\n%s\n", triageResult.SuggestedFix))
+
+	if len(triageResult.Evidence) > 0 {
+		body.WriteString("\n#### Flakiness Evidence\n\n")
+		for _, e := range triageResult.Evidence {
+			body.WriteString(fmt.Sprintf("- %s\n", e))
+		}
+		if triageResult.RerunStatus != "" {
+			body.WriteString(fmt.Sprintf("\n%s\n", triageResult.RerunStatus))
+		}
+	}
+
+	if failures := t.TestFailures(ctx); len(failures) > 0 {
+		body.WriteString("\n#### Failing Tests\n\n")
+		body.WriteString(failureTable(failures))
+	}
 
 	if len(triageResult.AffectedFiles) > 0 {
-		body.WriteString("\n### Affected Files\n\n")
+		body.WriteString("\n#### Affected Files\n\n")
 		for _, f := range triageResult.AffectedFiles {
 			body.WriteString(fmt.Sprintf("- `%s`\n", f))
 		}
 	}
 
+	if owners := t.affectedOwners(triageResult); len(owners) > 0 {
+		body.WriteString(fmt.Sprintf("\n#### Owners\n\ncc %s\n", strings.Join(owners, " ")))
+	}
+
+	if triageResult.Previous != nil {
+		body.WriteString(repeatSummary(triageResult.Previous))
+	}
+
+	if t.redactor.Total() > 0 {
+		body.WriteString(fmt.Sprintf("\n#### Redaction\n\n🔒 %d value(s) were redacted before being sent to the model: %s\n",
+			t.redactor.Total(), t.redactor.summary()))
+	}
+
 	if prURL != "" {
-		body.WriteString(fmt.Sprintf("\n### Auto-Fix\n\n🔧 [Draft PR with proposed fix](%s)\n", prURL))
+		body.WriteString(fmt.Sprintf("\n#### Auto-Fix\n\n%s\n", t.fixLink(prURL)))
 	} else if fixErr != nil {
-		body.WriteString(fmt.Sprintf("\n### Auto-Fix\n\n⚠️ Auto-fix was attempted but failed: `%s`\n", fixErr))
+		body.WriteString(fmt.Sprintf("\n#### Auto-Fix\n\n⚠️ Auto-fix was attempted but failed: `%s`\n", fixErr))
 	}
 
-	runURL := fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d", t.owner, t.repo, t.runID)
-	body.WriteString(fmt.Sprintf("\n---\n*[View workflow run](%s) · Triaged by yo-go*\n", runURL))
-
-	comment := &github.IssueComment{
-		Body: github.Ptr(body.String()),
+	if len(t.policyViolations) > 0 {
+		body.WriteString("\n#### Fix Policy\n\n🛡️ The auto-fix proposed changes the fix policy doesn't allow. They were not applied:\n\n")
+		for _, v := range t.policyViolations {
+			body.WriteString(fmt.Sprintf("- %s\n", v))
+		}
 	}
 
-	_, _, err = t.github.Issues.CreateComment(ctx, t.owner, t.repo, prNumber, comment)
-	if err != nil {
-		return fmt.Errorf("creating PR comment: %w", err)
+	row := stickyRun{
+		RunID:      run.GetID(),
+		RunNumber:  run.GetRunNumber(),
+		RunURL:     run.GetHTMLURL(),
+		Attempt:    run.GetRunAttempt(),
+		WorkflowID: run.GetWorkflowID(),
+		Workflow:   run.GetName(),
+		SHA:        run.GetHeadSHA(),
+		Category:   triageResult.Category,
+		FixURL:     prURL,
+		Status:     runFailing,
+		Time:       time.Now(),
 	}
 
-	slog.Info("posted triage comment on PR", "pr", prNumber)
+	for _, pr := range prs {
+		c, err := t.findStickyComment(ctx, pr.GetNumber())
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			c = parseStickyComment(0, "")
+		}
+		c.setSection(run.GetWorkflowID(), body.String())
+		c.addRun(row)
+		t.resolveRecovered(ctx, c, pr, run.GetWorkflowID())
+		if err := t.saveStickyComment(ctx, pr.GetNumber(), c); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1168,11 +1312,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Analyze with AI using tool calling — the model pulls logs on demand
-	result, err := triage.Analyze(context.Background())
+	// A passing run only marks the failures triaged earlier on its pull requests as fixed
+	if triage.runPassed(context.Background()) {
+		if err := triage.ResolveOnPR(context.Background()); err != nil {
+			slog.Error("failed to update triage comment", "err", err)
+		}
+		slog.Info("run passed, nothing to triage")
+		return
+	}
+
+	// Flaky failures are recognised from other runs on the same commit without asking the model
+	result, err := triage.DetectFlaky(context.Background())
 	if err != nil {
-		slog.Error("failed to analyze", "err", err)
-		os.Exit(1)
+		slog.Warn("flaky detection failed, falling back to analysis", "err", err)
+	}
+	if result != nil && triage.flakyRerun {
+		if err := triage.RerunFailedJobs(context.Background()); err != nil {
+			slog.Error("failed to re-run failed jobs", "err", err)
+			result.RerunStatus = fmt.Sprintf("⚠️ Could not re-run the failed jobs: `%s`", err)
+		} else {
+			result.RerunStatus = "🔁 The failed jobs have been re-run."
+		}
+	}
+
+	// A failure seen recently reuses its earlier diagnosis instead of a fresh one
+	if result == nil {
+		if prev := triage.RecallFailure(context.Background()); prev != nil {
+			reused := prev.Result
+			reused.Previous = prev
+			result = &reused
+		}
+	}
+
+	// Analyze with AI using tool calling — the model pulls logs on demand
+	if result == nil {
+		result, err = triage.Analyze(context.Background())
+		if err != nil {
+			slog.Error("failed to analyze", "err", err)
+			os.Exit(1)
+		}
 	}
 
 	slog.Info("triage result",
@@ -1188,15 +1366,15 @@ func main() {
 	autoFix := os.Getenv("AUTO_FIX") == "true"
 	var prURL string
 	var fixErr error
-	if autoFix && result.Fixable {
+	if autoFix && result.Fixable && result.Previous == nil {
 		fixResult, err := triage.AttemptFix(context.Background(), result)
 		if err != nil {
 			slog.Error("auto-fix failed", "err", err)
 			fixErr = err
 		} else if fixResult != nil {
-			prURL, err = triage.CreateFixPR(context.Background(), result, fixResult)
+			prURL, err = triage.PublishFix(context.Background(), result, fixResult)
 			if err != nil {
-				slog.Error("failed to create fix PR", "err", err)
+				slog.Error("failed to publish fix", "err", err)
 				fixErr = err
 			}
 		}
@@ -1207,9 +1385,18 @@ func main() {
 		slog.Error("failed to comment on PR", "err", err)
 	}
 
-	// Send Slack notification
-	if err := triage.NotifySlack(context.Background(), result, prURL); err != nil {
-		slog.Error("failed to send Slack notification", "err", err)
+	// Record the diagnosis as a check run so it shows inline in the PR diff
+	if err := triage.CreateCheckRun(context.Background(), result, prURL); err != nil {
+		slog.Error("failed to create check run", "err", err)
+	}
+
+	// Notify the routed channels; repeats only go to channels that can thread them
+	if err := triage.Notify(context.Background(), result, prURL); err != nil {
+		slog.Error("failed to send notifications", "err", err)
+	}
+
+	if result.Category != "flaky" {
+		triage.RecordFailure(context.Background(), result, prURL)
 	}
 
 	slog.Info("successfully completed triage analysis")
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sensitiveWords matches whole words that commonly trigger Azure's content filter. CI logs and
+// source code are full of them in perfectly normal contexts (SIGKILL, fatal error, panic stack
+// trace) and they trip the self-harm filter. Matching on word boundaries leaves words like
+// "diet" or "hangar" and identifiers like killProcess untouched, so signal names, where the
+// word follows SIG inside one token, are listed whole.
+var sensitiveWords = regexp.MustCompile(`(?i)\b(?:` + strings.Join([]string{
+	`sig(?:kill|abrt)`,
+	`kill(?:s|ed|ing)?`,
+	`suicide`,
+	`abort(?:s|ed|ing)?`,
+	`hang(?:s|ing)?`,
+	`fatal`,
+	`dead(?:lock(?:s|ed)?)?`,
+	`panic(?:s|ked|king)?`,
+	`die[sd]?`,
+	`dying`,
+	`death`,
+	`destroy(?:s|ed|ing)?`,
+}, "|") + `)\b`)
+
+// placeholderPattern matches placeholders produced by masker.
+var placeholderPattern = regexp.MustCompile(`__m(\d+)__`)
+
+// maskNotice is appended to the system prompt so the model knows to keep placeholders intact.
+const maskNotice = "\n\n## Placeholders\n\nSome words in the conversation have been replaced with placeholders like `__m1__`. " +
+	"Treat each placeholder as the original word it stands for and reproduce it exactly, character for character, " +
+	"wherever that word appears in your answer or in file contents. Never rename, expand or remove a placeholder."
+
+// masker replaces content-filter trigger words with stable placeholders and restores them in
+// the model's output. The same word (with the same casing) always maps to the same placeholder
+// for the lifetime of the masker, so the model sees a consistent vocabulary across turns.
+type masker struct {
+	placeholders map[string]string // original -> placeholder
+	originals    map[string]string // placeholder -> original
+}
+
+func newMasker() *masker {
+	return &masker{
+		placeholders: make(map[string]string),
+		originals:    make(map[string]string),
+	}
+}
+
+// Mask replaces every sensitive word in s with its placeholder.
+func (m *masker) Mask(s string) string {
+	return sensitiveWords.ReplaceAllStringFunc(s, func(word string) string {
+		if p, ok := m.placeholders[word]; ok {
+			return p
+		}
+		p := fmt.Sprintf("__m%d__", len(m.placeholders)+1)
+		m.placeholders[word] = p
+		m.originals[p] = word
+		return p
+	})
+}
+
+// Unmask restores the original words for every placeholder in s. Placeholders the masker didn't
+// produce are left as they are.
+func (m *masker) Unmask(s string) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(p string) string {
+		if original, ok := m.originals[p]; ok {
+			return original
+		}
+		return p
+	})
+}
+
+// Count returns the number of distinct words that have been masked.
+func (m *masker) Count() int {
+	return len(m.placeholders)
+}
+
+// maskMessages returns a copy of messages with sensitive words masked in every message's content
+// and tool call arguments. Assistant messages are included so the history stays consistent.
+func (m *masker) maskMessages(messages []Message) []Message {
+	masked := make([]Message, len(messages))
+	for i, msg := range messages {
+		masked[i] = msg
+		masked[i].Content = m.Mask(msg.Content)
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]ToolCall, len(msg.ToolCalls))
+			for j, tc := range msg.ToolCalls {
+				calls[j] = tc
+				calls[j].Function.Arguments = m.Mask(tc.Function.Arguments)
+			}
+			masked[i].ToolCalls = calls
+		}
+	}
+	return masked
+}
+
+// unmaskMessage restores original words in a model reply, including its tool call arguments.
+func (m *masker) unmaskMessage(msg Message) Message {
+	msg.Content = m.Unmask(msg.Content)
+	if len(msg.ToolCalls) > 0 {
+		calls := make([]ToolCall, len(msg.ToolCalls))
+		for i, tc := range msg.ToolCalls {
+			calls[i] = tc
+			calls[i].Function.Arguments = m.Unmask(tc.Function.Arguments)
+		}
+		msg.ToolCalls = calls
+	}
+	return msg
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		masked []string // words that must not reach the model
+		kept   []string // text that must be left as it is
+	}{
+		{
+			name:   "signals",
+			input:  "signal: killed\nprocess received SIGKILL after SIGABRT",
+			masked: []string{"killed", "SIGKILL", "SIGABRT"},
+		},
+		{
+			name:   "go panic",
+			input:  "panic: runtime error: index out of range\ngoroutine 1 [running]:\nfatal error: all goroutines are asleep - deadlock!",
+			masked: []string{"panic", "fatal", "deadlock"},
+			kept:   []string{"runtime error", "goroutines are asleep"},
+		},
+		{
+			name:   "word inflections and case",
+			input:  "Test hangs, then the worker Dies; test aborted while dying",
+			masked: []string{"hangs", "Dies", "aborted", "dying"},
+		},
+		{
+			name:  "identifiers and longer words",
+			input: "killProcess() in hangar.go follows the diet plan; deadline exceeded",
+			kept:  []string{"killProcess()", "hangar.go", "diet", "deadline"},
+		},
+		{
+			name:  "nothing sensitive",
+			input: "--- FAIL: TestHandler (0.01s)\n    handler_test.go:42: got 500, want 200",
+			kept:  []string{"--- FAIL: TestHandler"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMasker()
+			masked := m.Mask(tt.input)
+			for _, word := range tt.masked {
+				if strings.Contains(masked, word) {
+					t.Errorf("%q not masked in %q", word, masked)
+				}
+			}
+			for _, text := range tt.kept {
+				if !strings.Contains(masked, text) {
+					t.Errorf("%q changed in %q", text, masked)
+				}
+			}
+			if got := m.Unmask(masked); got != tt.input {
+				t.Errorf("round trip = %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestMaskPlaceholdersAreStable(t *testing.T) {
+	m := newMasker()
+	first := m.Mask("the job was killed")
+	second := m.Mask("killed again, then Killed")
+	if !strings.HasSuffix(first, "__m1__") || !strings.HasPrefix(second, "__m1__ again") {
+		t.Errorf("same word got different placeholders: %q, %q", first, second)
+	}
+	if m.Count() != 2 {
+		t.Errorf("Count() = %d, want 2 (casing is kept apart)", m.Count())
+	}
+
+	// A reply may use a placeholder the masker never produced
+	if got := m.Unmask("__m1__ by __m9__"); got != "killed by __m9__" {
+		t.Errorf("Unmask = %q", got)
+	}
+}
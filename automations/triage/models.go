@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+// defaultModelLimitsFile is where a repository's model limits are read from when MODEL_LIMITS
+// isn't set.
+const defaultModelLimitsFile = ".github/triage-models.json"
+
+// ModelLimits are the per-request limits for a model. Zero fields take the defaults.
+type ModelLimits struct {
+	// ContextWindow is the prompt token limit per request, see context.go
+	ContextWindow int `json:"contextWindow,omitempty"`
+	// MaxResultChars caps a single tool result. It defaults to, and can't exceed, half the
+	// context window at resultCharsPerToken characters a token
+	MaxResultChars int `json:"maxResultChars,omitempty"`
+	// DefaultTailLines and MaxTailLines bound how much of a job log get_job_logs returns
+	DefaultTailLines int `json:"defaultTailLines,omitempty"`
+	MaxTailLines     int `json:"maxTailLines,omitempty"`
+	// StructuredOutput is whether the model accepts a JSON schema response_format, see schema.go
+	StructuredOutput *bool `json:"structuredOutput,omitempty"`
+}
+
+var defaultModelLimits = ModelLimits{
+	ContextWindow:    8_000,
+	DefaultTailLines: 500,
+	MaxTailLines:     2000,
+	StructuredOutput: github.Ptr(false),
+}
+
+// Limit sets shared by the table below. The prompt token limits are what GitHub Models enforces
+// per request, which are much smaller than the models' native windows; small-window reasoning
+// models also get aggressive tool result truncation.
+var (
+	smallWindowLimits     = ModelLimits{ContextWindow: 4_000, MaxResultChars: 2_000, DefaultTailLines: 50, MaxTailLines: 200, StructuredOutput: github.Ptr(true)}
+	reasoningLimits       = ModelLimits{ContextWindow: 4_000, StructuredOutput: github.Ptr(true)}
+	openAILimits          = ModelLimits{ContextWindow: 8_000, StructuredOutput: github.Ptr(true)}
+	openWeightLimits      = ModelLimits{ContextWindow: 8_000}
+	openWeightSmallLimits = ModelLimits{ContextWindow: 4_000}
+)
+
+// modelLimitsTable maps GitHub Models model names, without the publisher prefix and in lower
+// case, to their limits. Models missing from it get defaultModelLimits; MODEL_LIMITS adds to or
+// overrides the table, and CONTEXT_WINDOW and STRUCTURED_OUTPUT override it for every model.
+var modelLimitsTable = map[string]ModelLimits{
+	"gpt-5":                                  smallWindowLimits,
+	"gpt-5-mini":                             smallWindowLimits,
+	"gpt-5-nano":                             smallWindowLimits,
+	"gpt-5-chat":                             smallWindowLimits,
+	"o1":                                     reasoningLimits,
+	"o1-mini":                                reasoningLimits,
+	"o1-preview":                             reasoningLimits,
+	"o3":                                     reasoningLimits,
+	"o3-mini":                                reasoningLimits,
+	"o4-mini":                                reasoningLimits,
+	"gpt-4.1":                                openAILimits,
+	"gpt-4.1-mini":                           openAILimits,
+	"gpt-4.1-nano":                           openAILimits,
+	"gpt-4o":                                 openAILimits,
+	"gpt-4o-mini":                            openAILimits,
+	"deepseek-r1":                            openWeightSmallLimits,
+	"deepseek-r1-0528":                       openWeightSmallLimits,
+	"deepseek-v3-0324":                       openWeightSmallLimits,
+	"llama-3.3-70b-instruct":                 openWeightLimits,
+	"llama-4-maverick-17b-128e-instruct-fp8": openWeightLimits,
+	"llama-4-scout-17b-16e-instruct":         openWeightLimits,
+	"meta-llama-3.1-405b-instruct":           openWeightLimits,
+	"meta-llama-3.1-8b-instruct":             openWeightLimits,
+	"mistral-large-2411":                     openWeightLimits,
+	"mistral-medium-2505":                    openWeightLimits,
+	"mistral-small-2503":                     openWeightLimits,
+	"codestral-2501":                         openWeightLimits,
+}
+
+// merge returns l with the fields set in o replacing its own.
+func (l ModelLimits) merge(o ModelLimits) ModelLimits {
+	if o.ContextWindow > 0 {
+		l.ContextWindow = o.ContextWindow
+	}
+	if o.MaxResultChars > 0 {
+		l.MaxResultChars = o.MaxResultChars
+	}
+	if o.DefaultTailLines > 0 {
+		l.DefaultTailLines = o.DefaultTailLines
+	}
+	if o.MaxTailLines > 0 {
+		l.MaxTailLines = o.MaxTailLines
+	}
+	if o.StructuredOutput != nil {
+		l.StructuredOutput = o.StructuredOutput
+	}
+	return l
+}
+
+// resultCharsPerToken is the characters-per-token ratio MaxResultChars is derived with, about
+// right for English text and logs.
+const resultCharsPerToken = 4
+
+// modelSettings is a model and the limits its requests follow.
+type modelSettings struct {
+	name string
+	ModelLimits
+	// structuredOutput starts from the limits and is switched off if the provider rejects it
+	structuredOutput bool
+}
+
+// modelLimitsKey normalises a model name for the limits table: "openai/GPT-4o" becomes "gpt-4o".
+func modelLimitsKey(model string) string {
+	if _, name, ok := strings.Cut(model, "/"); ok {
+		model = name
+	}
+	return strings.ToLower(model)
+}
+
+// loadModelLimits reads MODEL_LIMITS, relative to the workspace, or .github/triage-models.json if
+// present: an object of model names, with or without the publisher, to limits.
+func loadModelLimits() (map[string]ModelLimits, error) {
+	path := os.Getenv("MODEL_LIMITS")
+	explicit := path != ""
+	if !explicit {
+		path = defaultModelLimitsFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceDir(), path)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading model limits: %w", err)
+	}
+
+	var file struct {
+		Models map[string]ModelLimits `json:"models"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing model limits %s: %w", path, err)
+	}
+	limits := make(map[string]ModelLimits, len(file.Models))
+	for name, l := range file.Models {
+		limits[modelLimitsKey(name)] = l
+	}
+	return limits, nil
+}
+
+// resolveModel looks up a model's limits: the built-in table, then the repository's overrides,
+// then the CONTEXT_WINDOW and STRUCTURED_OUTPUT inputs.
+func resolveModel(name string, overrides map[string]ModelLimits) (*modelSettings, error) {
+	key := modelLimitsKey(name)
+	limits := defaultModelLimits
+	known, ok := modelLimitsTable[key]
+	if ok {
+		limits = limits.merge(known)
+	}
+	if o, found := overrides[key]; found {
+		limits = limits.merge(o)
+		ok = true
+	}
+	if !ok {
+		slog.Info("model not in the limits table, using default limits", "model", name, "contextWindow", limits.ContextWindow)
+	}
+
+	if v := os.Getenv("CONTEXT_WINDOW"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("CONTEXT_WINDOW must be a positive integer, got: %s", v)
+		}
+		limits.ContextWindow = n
+	}
+	// A larger tool result could never fit in the window alongside the prompt
+	if maxChars := limits.ContextWindow / 2 * resultCharsPerToken; limits.MaxResultChars == 0 || limits.MaxResultChars > maxChars {
+		limits.MaxResultChars = maxChars
+	}
+
+	m := &modelSettings{name: name, ModelLimits: limits, structuredOutput: *limits.StructuredOutput}
+	switch v := os.Getenv("STRUCTURED_OUTPUT"); v {
+	case "", "auto":
+	case "true":
+		m.structuredOutput = true
+	case "false":
+		m.structuredOutput = false
+	default:
+		return nil, fmt.Errorf("STRUCTURED_OUTPUT must be true, false or auto, got: %s", v)
+	}
+	return m, nil
+}
+
+// Conditions in ESCALATE_ON under which a diagnosis is re-run with the fix model.
+const (
+	escalateLowConfidence    = "low-confidence"    // confidence is low
+	escalateMediumConfidence = "medium-confidence" // confidence is medium or low
+	escalateUnknown          = "unknown"           // the category is unknown
+	escalateInvalid          = "invalid"           // no valid diagnosis, even after repair rounds
+)
+
+var (
+	escalationConditions = []string{escalateLowConfidence, escalateMediumConfidence, escalateUnknown, escalateInvalid}
+	defaultEscalateOn    = []string{escalateLowConfidence, escalateUnknown, escalateInvalid}
+)
+
+// parseEscalateOn reads ESCALATE_ON, a comma-separated list of escalation conditions, or "none".
+func parseEscalateOn(v string) ([]string, error) {
+	switch strings.TrimSpace(v) {
+	case "":
+		return defaultEscalateOn, nil
+	case "none":
+		return nil, nil
+	}
+	var conditions []string
+	for _, c := range strings.Split(v, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(escalationConditions, c) {
+			return nil, fmt.Errorf("ESCALATE_ON must be none or a list of %s, got: %s", strings.Join(escalationConditions, ", "), c)
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// useModel switches the requests that follow to m.
+func (t *Triage) useModel(m *modelSettings) {
+	if t.model != m {
+		slog.Info("using model", "model", m.name, "contextWindow", m.ContextWindow)
+	}
+	t.model = m
+}
+
+// escalation returns why a diagnosis from the diagnosis model should be re-run with the fix
+// model, or "" when it shouldn't: the two are the same model, or no ESCALATE_ON condition holds.
+func (t *Triage) escalation(result *TriageResult, err error) string {
+	if t.diagnosisModel == t.fixModel {
+		return ""
+	}
+	on := func(condition string) bool { return slices.Contains(t.escalateOn, condition) }
+	switch {
+	case err != nil:
+		if on(escalateInvalid) {
+			return "no valid diagnosis"
+		}
+	case result.Confidence == "low" && (on(escalateLowConfidence) || on(escalateMediumConfidence)):
+		return "low confidence"
+	case result.Confidence == "medium" && on(escalateMediumConfidence):
+		return "medium confidence"
+	case result.Category == "unknown" && on(escalateUnknown):
+		return "unknown category"
+	}
+	return ""
+}
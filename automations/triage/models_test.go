@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveModel(t *testing.T) {
+	tests := []struct {
+		name             string
+		model            string
+		overrides        map[string]ModelLimits
+		contextWindow    string
+		structuredOutput string
+		wantWindow       int
+		wantResultChars  int
+		wantStructured   bool
+		wantErr          string
+	}{
+		{name: "unknown model", model: "acme/model-x", wantWindow: 8_000, wantResultChars: 16_000},
+		{name: "table entry", model: "openai/gpt-4o", wantWindow: 8_000, wantResultChars: 16_000, wantStructured: true},
+		{name: "name is case insensitive", model: "OpenAI/GPT-4o", wantWindow: 8_000, wantResultChars: 16_000, wantStructured: true},
+		{name: "small window keeps its result cap", model: "openai/gpt-5-mini", wantWindow: 4_000, wantResultChars: 2_000, wantStructured: true},
+		{
+			name:            "override raises the window and the derived cap",
+			model:           "openai/gpt-4o",
+			overrides:       map[string]ModelLimits{"gpt-4o": {ContextWindow: 128_000}},
+			wantWindow:      128_000,
+			wantResultChars: 256_000,
+			wantStructured:  true,
+		},
+		{
+			name:            "result cap larger than the window is clamped",
+			model:           "acme/model-x",
+			overrides:       map[string]ModelLimits{"model-x": {MaxResultChars: 100_000}},
+			wantWindow:      8_000,
+			wantResultChars: 16_000,
+		},
+		{name: "CONTEXT_WINDOW lowers the cap", model: "openai/gpt-5-mini", contextWindow: "800", wantWindow: 800, wantResultChars: 1_600, wantStructured: true},
+		{name: "STRUCTURED_OUTPUT off", model: "openai/gpt-4o", structuredOutput: "false", wantWindow: 8_000, wantResultChars: 16_000},
+		{name: "STRUCTURED_OUTPUT on", model: "acme/model-x", structuredOutput: "true", wantWindow: 8_000, wantResultChars: 16_000, wantStructured: true},
+		{name: "bad CONTEXT_WINDOW", model: "openai/gpt-4o", contextWindow: "lots", wantErr: "CONTEXT_WINDOW must be a positive integer"},
+		{name: "zero CONTEXT_WINDOW", model: "openai/gpt-4o", contextWindow: "0", wantErr: "CONTEXT_WINDOW must be a positive integer"},
+		{name: "bad STRUCTURED_OUTPUT", model: "openai/gpt-4o", structuredOutput: "maybe", wantErr: "STRUCTURED_OUTPUT must be true, false or auto"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONTEXT_WINDOW", tt.contextWindow)
+			t.Setenv("STRUCTURED_OUTPUT", tt.structuredOutput)
+			m, err := resolveModel(tt.model, tt.overrides)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveModel error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.ContextWindow != tt.wantWindow || m.MaxResultChars != tt.wantResultChars || m.structuredOutput != tt.wantStructured {
+				t.Errorf("resolveModel = window %d, result chars %d, structured %v; want %d, %d, %v",
+					m.ContextWindow, m.MaxResultChars, m.structuredOutput, tt.wantWindow, tt.wantResultChars, tt.wantStructured)
+			}
+		})
+	}
+}
+
+func TestParseEscalateOn(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", defaultEscalateOn, false},
+		{"none", nil, false},
+		{"unknown, medium-confidence", []string{escalateUnknown, escalateMediumConfidence}, false},
+		{"low-confidence,sometimes", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseEscalateOn(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEscalateOn error = %v, want error: %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseEscalateOn = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	diagnosis := &modelSettings{name: "openai/gpt-4o-mini"}
+	fix := &modelSettings{name: "openai/gpt-4o"}
+
+	tests := []struct {
+		name       string
+		fixModel   *modelSettings
+		escalateOn []string
+		result     *TriageResult
+		err        error
+		want       string
+	}{
+		{"same model", diagnosis, defaultEscalateOn, &TriageResult{Confidence: "low"}, nil, ""},
+		{"low confidence", fix, defaultEscalateOn, &TriageResult{Confidence: "low", Category: "test"}, nil, "low confidence"},
+		{"medium confidence not escalated by default", fix, defaultEscalateOn, &TriageResult{Confidence: "medium", Category: "test"}, nil, ""},
+		{"medium confidence", fix, []string{escalateMediumConfidence}, &TriageResult{Confidence: "medium", Category: "test"}, nil, "medium confidence"},
+		{"unknown category", fix, defaultEscalateOn, &TriageResult{Confidence: "high", Category: "unknown"}, nil, "unknown category"},
+		{"invalid diagnosis", fix, defaultEscalateOn, nil, errors.New("invalid triage result"), "no valid diagnosis"},
+		{"invalid diagnosis not escalated", fix, []string{escalateUnknown}, nil, errors.New("invalid triage result"), ""},
+		{"confident diagnosis", fix, defaultEscalateOn, &TriageResult{Confidence: "high", Category: "build"}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			triage := &Triage{diagnosisModel: diagnosis, fixModel: tt.fixModel, escalateOn: tt.escalateOn}
+			if got := triage.escalation(tt.result, tt.err); got != tt.want {
+				t.Errorf("escalation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
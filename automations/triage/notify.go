@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultNotifyConfig is where a repository's notification routing is read from when
+// NOTIFY_CONFIG isn't set.
+const defaultNotifyConfig = ".github/triage-notify.json"
+
+// Notification is what every notifier renders: the triage result and where it came from.
+type Notification struct {
+	Repository   string
+	RunID        int64
+	RunURL       string
+	Workflow     string
+	Branch       string
+	FailedJobs   []string
+	Result       *TriageResult
+	FixURL       string
+	FixSuggested bool
+	// Owners are the code owners of the affected files; SlackMentions are the same owners as
+	// Slack mentions where the owner map has a handle for them
+	Owners        []string
+	SlackMentions []string
+	// Previous is set for a failure seen before; most notifiers skip it, see followUpNotifier
+	Previous *HistoryEntry
+}
+
+// Notifier sends a triage notification to one destination.
+type Notifier interface {
+	// Notify sends the notification. It returns a thread reference, such as a Slack message
+	// timestamp, when later repeats of the same failure can be posted as replies to it.
+	Notify(ctx context.Context, n *Notification) (thread string, err error)
+}
+
+// followUpNotifier is a notifier that posts repeat failures as replies to the first notification
+// instead of staying silent.
+type followUpNotifier interface {
+	Notifier
+	FollowUp(ctx context.Context, n *Notification, thread string) error
+}
+
+// ChannelConfig defines a notification channel. Secrets never live in the config file; the *Env
+// fields name the environment variables that hold them.
+type ChannelConfig struct {
+	Type      string `json:"type"`              // slack, teams, discord or webhook
+	Channel   string `json:"channel,omitempty"` // Slack channel ID, for the bot token API
+	URLEnv    string `json:"urlEnv,omitempty"`
+	TokenEnv  string `json:"tokenEnv,omitempty"`
+	SecretEnv string `json:"secretEnv,omitempty"`
+}
+
+// NotifyRoute sends matching failures to a set of channels. Branch, Workflow, Category and Owner
+// are regular expressions matched against the whole value; empty ones match everything. Owner
+// matches when any code owner of the affected files does. Routes are checked in order and the
+// first match wins, unless it sets Continue.
+type NotifyRoute struct {
+	Branch   string   `json:"branch,omitempty"`
+	Workflow string   `json:"workflow,omitempty"`
+	Category string   `json:"category,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Channels []string `json:"channels"`
+	Continue bool     `json:"continue,omitempty"`
+
+	branch, workflow, category, owner *regexp.Regexp
+}
+
+// NotifyConfig is the notification setup: named channels, routing rules and the channels used
+// when no route matches (every channel if Default is empty). SlackHandles maps CODEOWNERS owners,
+// such as "@org/payments", to the Slack user or user group IDs to mention for them.
+type NotifyConfig struct {
+	Channels     map[string]ChannelConfig `json:"channels"`
+	Routes       []*NotifyRoute           `json:"routes,omitempty"`
+	Default      []string                 `json:"default,omitempty"`
+	SlackHandles map[string]string        `json:"slackHandles,omitempty"`
+}
+
+// envChannels are the channels configured by the action's inputs alone, so a plain
+// SLACK_WEBHOOK_URL keeps working without a config file.
+func envChannels() map[string]ChannelConfig {
+	channels := make(map[string]ChannelConfig)
+	switch {
+	case os.Getenv("SLACK_BOT_TOKEN") != "" && os.Getenv("SLACK_CHANNEL") != "":
+		channels["slack"] = ChannelConfig{Type: "slack", Channel: os.Getenv("SLACK_CHANNEL"), TokenEnv: "SLACK_BOT_TOKEN"}
+	case os.Getenv("SLACK_WEBHOOK_URL") != "":
+		channels["slack"] = ChannelConfig{Type: "slack", URLEnv: "SLACK_WEBHOOK_URL"}
+	}
+	if os.Getenv("TEAMS_WEBHOOK_URL") != "" {
+		channels["teams"] = ChannelConfig{Type: "teams", URLEnv: "TEAMS_WEBHOOK_URL"}
+	}
+	if os.Getenv("DISCORD_WEBHOOK_URL") != "" {
+		channels["discord"] = ChannelConfig{Type: "discord", URLEnv: "DISCORD_WEBHOOK_URL"}
+	}
+	if os.Getenv("NOTIFY_WEBHOOK_URL") != "" {
+		channels["webhook"] = ChannelConfig{Type: "webhook", URLEnv: "NOTIFY_WEBHOOK_URL", SecretEnv: "NOTIFY_WEBHOOK_SECRET"}
+	}
+	return channels
+}
+
+// loadNotifyConfig merges the channels from the action's inputs with NOTIFY_CONFIG, or
+// .github/triage-notify.json if present. Channels in the file replace input channels of the
+// same name, and SLACK_OWNER_HANDLES, a JSON object, adds to the file's Slack handles.
+func loadNotifyConfig() (*NotifyConfig, error) {
+	cfg := &NotifyConfig{}
+
+	path := os.Getenv("NOTIFY_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		path = defaultNotifyConfig
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceDir(), path)
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing notify config %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist) || explicit:
+		return nil, fmt.Errorf("reading notify config: %w", err)
+	}
+
+	channels := envChannels()
+	for name, c := range cfg.Channels {
+		if _, err := newNotifier(c); err != nil {
+			return nil, fmt.Errorf("notify channel %s: %w", name, err)
+		}
+		channels[name] = c
+	}
+	cfg.Channels = channels
+
+	if handles := os.Getenv("SLACK_OWNER_HANDLES"); handles != "" {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(handles), &m); err != nil {
+			return nil, fmt.Errorf("parsing SLACK_OWNER_HANDLES: %w", err)
+		}
+		if cfg.SlackHandles == nil {
+			cfg.SlackHandles = make(map[string]string)
+		}
+		for owner, handle := range m {
+			cfg.SlackHandles[owner] = handle
+		}
+	}
+
+	for i, r := range cfg.Routes {
+		if len(r.Channels) == 0 {
+			return nil, fmt.Errorf("notify route %d has no channels", i+1)
+		}
+		for _, field := range []struct {
+			pattern string
+			re      **regexp.Regexp
+		}{{r.Branch, &r.branch}, {r.Workflow, &r.workflow}, {r.Category, &r.category}, {r.Owner, &r.owner}} {
+			if field.pattern == "" {
+				continue
+			}
+			if *field.re, err = regexp.Compile(`^(?:` + field.pattern + `)$`); err != nil {
+				return nil, fmt.Errorf("notify route %d: %w", i+1, err)
+			}
+		}
+	}
+	for _, name := range cfg.referencedChannels() {
+		if _, ok := cfg.Channels[name]; !ok {
+			return nil, fmt.Errorf("notify config refers to unknown channel %s", name)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *NotifyConfig) referencedChannels() []string {
+	names := append([]string{}, cfg.Default...)
+	for _, r := range cfg.Routes {
+		names = append(names, r.Channels...)
+	}
+	return names
+}
+
+func (r *NotifyRoute) matches(n *Notification) bool {
+	return (r.branch == nil || r.branch.MatchString(n.Branch)) &&
+		(r.workflow == nil || r.workflow.MatchString(n.Workflow)) &&
+		(r.category == nil || r.category.MatchString(n.Result.Category)) &&
+		(r.owner == nil || slices.ContainsFunc(n.Owners, r.owner.MatchString))
+}
+
+// route returns the channels a notification goes to, in a stable order without duplicates.
+func (cfg *NotifyConfig) route(n *Notification) []string {
+	var names []string
+	matched := false
+	for _, r := range cfg.Routes {
+		if !r.matches(n) {
+			continue
+		}
+		matched = true
+		names = append(names, r.Channels...)
+		if !r.Continue {
+			break
+		}
+	}
+	if !matched {
+		names = cfg.Default
+		if len(names) == 0 {
+			for name := range cfg.Channels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+	}
+
+	var unique []string
+	for _, name := range names {
+		unique = appendUnique(unique, name)
+	}
+	return unique
+}
+
+// newNotifier builds the notifier for a channel. It returns nil, without an error, when the
+// channel's secrets aren't set, so optional channels can be configured ahead of their secrets.
+func newNotifier(c ChannelConfig) (Notifier, error) {
+	env := func(name string, fallback string) string {
+		if name == "" {
+			name = fallback
+		}
+		return os.Getenv(name)
+	}
+	switch c.Type {
+	case "slack":
+		if c.Channel != "" {
+			if token := env(c.TokenEnv, "SLACK_BOT_TOKEN"); token != "" {
+				return &slackBotNotifier{token: token, channel: c.Channel}, nil
+			}
+			return nil, nil
+		}
+		if url := env(c.URLEnv, "SLACK_WEBHOOK_URL"); url != "" {
+			return &slackWebhookNotifier{url: url}, nil
+		}
+		return nil, nil
+	case "teams":
+		if url := env(c.URLEnv, "TEAMS_WEBHOOK_URL"); url != "" {
+			return &teamsNotifier{url: url}, nil
+		}
+		return nil, nil
+	case "discord":
+		if url := env(c.URLEnv, "DISCORD_WEBHOOK_URL"); url != "" {
+			return &discordNotifier{url: url}, nil
+		}
+		return nil, nil
+	case "webhook":
+		if url := env(c.URLEnv, "NOTIFY_WEBHOOK_URL"); url != "" {
+			return &webhookNotifier{url: url, secret: env(c.SecretEnv, "NOTIFY_WEBHOOK_SECRET")}, nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown channel type %q (want slack, teams, discord or webhook)", c.Type)
+	}
+}
+
+// Notify sends the triage result to the channels its route selects. A failure seen before is
+// only followed up in channels that support threads, so repeats don't flood the others. Thread
+// references from first notifications are kept in t.threads for the failure history.
+func (t *Triage) Notify(ctx context.Context, triageResult *TriageResult, prURL string) error {
+	n := t.notification(ctx, triageResult, prURL)
+	channels := t.notify.route(n)
+	if len(channels) == 0 {
+		slog.Info("no notification channels configured, skipping notifications")
+		return nil
+	}
+
+	var errs []error
+	for _, name := range channels {
+		notifier, err := newNotifier(t.notify.Channels[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if notifier == nil {
+			slog.Warn("notification channel has no credentials set, skipping", "channel", name)
+			continue
+		}
+
+		if n.Previous != nil {
+			f, ok := notifier.(followUpNotifier)
+			if !ok {
+				slog.Info("failure already notified, skipping", "channel", name, "firstRun", n.Previous.RunID)
+				continue
+			}
+			if thread := n.Previous.Threads[name]; thread != "" {
+				if err := f.FollowUp(ctx, n, thread); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				} else {
+					slog.Info("posted repeat failure as a follow-up", "channel", name)
+				}
+				continue
+			}
+		}
+
+		thread, err := notifier.Notify(ctx, n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if thread != "" {
+			t.threads[name] = thread
+		}
+		slog.Info("sent notification", "channel", name)
+	}
+	return errors.Join(errs...)
+}
+
+// notification gathers what notifiers need about the run.
+func (t *Triage) notification(ctx context.Context, triageResult *TriageResult, prURL string) *Notification {
+	n := &Notification{
+		Repository:   t.owner + "/" + t.repo,
+		RunID:        t.runID,
+		RunURL:       fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d", t.owner, t.repo, t.runID),
+		Branch:       os.Getenv("GITHUB_REF_NAME"),
+		FailedJobs:   t.failedJobNames,
+		Result:       triageResult,
+		FixURL:       prURL,
+		FixSuggested: t.fixSuggested,
+		Previous:     triageResult.Previous,
+		Owners:       t.affectedOwners(triageResult),
+	}
+	for _, owner := range n.Owners {
+		if handle := t.notify.SlackHandles[owner]; handle != "" {
+			n.SlackMentions = append(n.SlackMentions, slackMention(handle))
+		} else {
+			n.SlackMentions = append(n.SlackMentions, owner)
+		}
+	}
+	if run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID); err == nil {
+		n.Workflow = run.GetName()
+		n.Branch = run.GetHeadBranch()
+	} else {
+		slog.Warn("error getting workflow run for notifications", "err", err)
+	}
+	if len(n.FailedJobs) == 0 {
+		if jobs, err := t.failedJobs(ctx); err == nil {
+			for _, job := range jobs {
+				n.FailedJobs = append(n.FailedJobs, job.GetName())
+			}
+		}
+	}
+	return n
+}
+
+// failedJobList renders the failed job names for a message.
+func (n *Notification) failedJobList() string {
+	if len(n.FailedJobs) == 0 {
+		return "unknown"
+	}
+	return strings.Join(n.FailedJobs, ", ")
+}
+
+// fixStatus describes what happened about a fix, with link formatting the destination uses.
+func (n *Notification) fixStatus(link func(url string, text string) string) string {
+	switch {
+	case n.FixURL != "" && n.FixSuggested:
+		return "💡 Fix suggested on the PR: " + link(n.FixURL, "View review")
+	case n.FixURL != "":
+		return "🔧 Auto-fix PR: " + link(n.FixURL, "View PR")
+	case n.Result.Category == "flaky":
+		status := "♻️ Flaky failure — no auto-fix attempted"
+		if n.Result.RerunStatus != "" {
+			status += "\n" + n.Result.RerunStatus
+		}
+		return status
+	case !n.Result.Fixable:
+		return "No auto-fix attempted — issue not auto-fixable"
+	default:
+		return "Auto-fix attempted but failed"
+	}
+}
+
+// truncateRunes cuts s to at most n runes, marking the cut.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
+
+// notifyClient is shared by every notifier.
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts payload as JSON and returns the response body, failing on non-2xx statuses.
+func postJSON(ctx context.Context, url string, payload any, headers map[string]string) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling payload: %w", err)
+	}
+	return postBody(ctx, url, data, headers)
+}
+
+func postBody(ctx context.Context, url string, data []byte, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Warn("notification endpoint returned an error status", "status", resp.StatusCode, "body", string(body))
+		return nil, fmt.Errorf("notification endpoint returned status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// slackLink formats a link in Slack mrkdwn.
+func slackLink(url string, text string) string {
+	return fmt.Sprintf("<%s|%s>", url, text)
+}
+
+// markdownLink formats a link in markdown, for Teams and Discord.
+func markdownLink(url string, text string) string {
+	return fmt.Sprintf("[%s](%s)", text, url)
+}
+
+// slackBlocks renders a notification as Block Kit blocks.
+func slackBlocks(n *Notification) []map[string]interface{} {
+	summary := fmt.Sprintf("*Category:* %s\n*Confidence:* %s\n*Failed Jobs:* %s\n*Run:* <%s|View Run>",
+		n.Result.Category,
+		n.Result.Confidence,
+		n.failedJobList(),
+		n.RunURL,
+	)
+	if len(n.SlackMentions) > 0 {
+		summary += "\n*Owners:* " + strings.Join(n.SlackMentions, " ")
+	}
+	return []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]string{
+				"type": "plain_text",
+				"text": fmt.Sprintf(":rotating_light: CI Failure: %s", n.Repository),
+			},
+		},
+		{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				"text": summary,
+			},
+		},
+		{"type": "divider"},
+		{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*Root Cause:*\n%s", truncateRunes(n.Result.RootCause, 2900)),
+			},
+		},
+		{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				"text": n.fixStatus(slackLink),
+			},
+		},
+		{
+			"type": "context",
+			"elements": []map[string]string{
+				{
+					"type": "mrkdwn",
+					"text": fmt.Sprintf("Triaged by yo-go | %s", time.Now().Format(time.RFC3339)),
+				},
+			},
+		},
+	}
+}
+
+// slackWebhookNotifier posts Block Kit messages to a Slack incoming webhook.
+type slackWebhookNotifier struct {
+	url string
+}
+
+func (s *slackWebhookNotifier) Notify(ctx context.Context, n *Notification) (string, error) {
+	_, err := postJSON(ctx, s.url, map[string]interface{}{"blocks": slackBlocks(n)}, nil)
+	return "", err
+}
+
+// slackBotNotifier posts with a bot token through chat.postMessage, which returns the message's
+// timestamp so repeats of the failure can be threaded under it.
+type slackBotNotifier struct {
+	token   string
+	channel string
+}
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+func (s *slackBotNotifier) Notify(ctx context.Context, n *Notification) (string, error) {
+	return s.post(ctx, map[string]interface{}{
+		"channel": s.channel,
+		"text":    fmt.Sprintf("CI Failure: %s (%s)", n.Repository, n.Result.Category),
+		"blocks":  slackBlocks(n),
+	})
+}
+
+func (s *slackBotNotifier) FollowUp(ctx context.Context, n *Notification, thread string) error {
+	text := fmt.Sprintf(":repeat: Seen again in <%s|run %d> (%d times since %s).",
+		n.RunURL, n.RunID, n.Previous.Count, n.Previous.FirstSeen.Format("2006-01-02"))
+	if n.Branch != "" {
+		text += fmt.Sprintf(" Branch `%s`.", n.Branch)
+	}
+	if len(n.SlackMentions) > 0 {
+		text += " cc " + strings.Join(n.SlackMentions, " ")
+	}
+	_, err := s.post(ctx, map[string]interface{}{
+		"channel":   s.channel,
+		"thread_ts": thread,
+		"text":      text,
+	})
+	return err
+}
+
+// post calls chat.postMessage. Slack reports API errors in the body with a 200 status.
+func (s *slackBotNotifier) post(ctx context.Context, payload map[string]interface{}) (string, error) {
+	body, err := postJSON(ctx, slackPostMessageURL, payload, map[string]string{"Authorization": "Bearer " + s.token})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("parsing Slack response: %w", err)
+	}
+	if !resp.OK {
+		return "", fmt.Errorf("Slack API error: %s", resp.Error)
+	}
+	return resp.TS, nil
+}
+
+// teamsNotifier posts an adaptive card to a Microsoft Teams incoming webhook or workflow URL.
+type teamsNotifier struct {
+	url string
+}
+
+func (m *teamsNotifier) Notify(ctx context.Context, n *Notification) (string, error) {
+	actions := []map[string]string{{"type": "Action.OpenUrl", "title": "View Run", "url": n.RunURL}}
+	if n.FixURL != "" {
+		actions = append(actions, map[string]string{"type": "Action.OpenUrl", "title": "View Fix", "url": n.FixURL})
+	}
+	facts := []map[string]string{
+		{"title": "Category", "value": n.Result.Category},
+		{"title": "Confidence", "value": n.Result.Confidence},
+		{"title": "Failed Jobs", "value": n.failedJobList()},
+		{"title": "Branch", "value": n.Branch},
+	}
+	if len(n.Owners) > 0 {
+		facts = append(facts, map[string]string{"title": "Owners", "value": strings.Join(n.Owners, ", ")})
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{"type": "TextBlock", "size": "Medium", "weight": "Bolder", "wrap": true, "text": fmt.Sprintf("🚨 CI Failure: %s", n.Repository)},
+			{"type": "FactSet", "facts": facts},
+			{"type": "TextBlock", "weight": "Bolder", "wrap": true, "text": "Root Cause"},
+			{"type": "TextBlock", "wrap": true, "text": truncateRunes(n.Result.RootCause, 2900)},
+			{"type": "TextBlock", "wrap": true, "text": n.fixStatus(markdownLink)},
+		},
+		"actions": actions,
+	}
+	payload := map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	}
+	_, err := postJSON(ctx, m.url, payload, nil)
+	return "", err
+}
+
+// discordNotifier posts an embed to a Discord webhook.
+type discordNotifier struct {
+	url string
+}
+
+// discordRed is the embed accent colour for failures.
+const discordRed = 0xE01E5A
+
+func (d *discordNotifier) Notify(ctx context.Context, n *Notification) (string, error) {
+	fields := []map[string]interface{}{
+		{"name": "Category", "value": n.Result.Category, "inline": true},
+		{"name": "Confidence", "value": n.Result.Confidence, "inline": true},
+		{"name": "Failed Jobs", "value": truncateRunes(n.failedJobList(), 1000)},
+		{"name": "Fix", "value": truncateRunes(n.fixStatus(markdownLink), 1000)},
+	}
+	// Discord rejects embeds with empty field values
+	if len(n.Owners) > 0 {
+		fields = append(fields, map[string]interface{}{"name": "Owners", "value": truncateRunes(strings.Join(n.Owners, ", "), 1000)})
+	}
+	embed := map[string]interface{}{
+		"title":       fmt.Sprintf("🚨 CI Failure: %s", n.Repository),
+		"url":         n.RunURL,
+		"description": truncateRunes(n.Result.RootCause, 4000),
+		"color":       discordRed,
+		"fields":      fields,
+		"footer":      map[string]string{"text": "Triaged by yo-go"},
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}
+	_, err := postJSON(ctx, d.url, map[string]interface{}{"embeds": []interface{}{embed}}, nil)
+	return "", err
+}
+
+// webhookNotifier posts the notification as plain JSON for custom integrations. With a secret,
+// the body is signed like GitHub's own webhooks: X-Yo-Go-Signature-256 is "sha256=" followed by
+// the hex HMAC-SHA256 of the body. Repeat failures are sent too, with repeat set, so receivers
+// can decide for themselves what to do with them.
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+// webhookPayload is the generic webhook's JSON body.
+type webhookPayload struct {
+	Event      string        `json:"event"`
+	Repository string        `json:"repository"`
+	RunID      int64         `json:"runId"`
+	RunURL     string        `json:"runUrl"`
+	Workflow   string        `json:"workflow,omitempty"`
+	Branch     string        `json:"branch,omitempty"`
+	FailedJobs []string      `json:"failedJobs"`
+	Result     *TriageResult `json:"result"`
+	FixURL     string        `json:"fixUrl,omitempty"`
+	Owners     []string      `json:"owners,omitempty"`
+	Repeat     int           `json:"repeat,omitempty"` // times this failure has been seen, for repeats
+	SentAt     time.Time     `json:"sentAt"`
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n *Notification) (string, error) {
+	payload := webhookPayload{
+		Event:      "triage",
+		Repository: n.Repository,
+		RunID:      n.RunID,
+		RunURL:     n.RunURL,
+		Workflow:   n.Workflow,
+		Branch:     n.Branch,
+		FailedJobs: n.FailedJobs,
+		Result:     n.Result,
+		FixURL:     n.FixURL,
+		Owners:     n.Owners,
+		SentAt:     time.Now().UTC(),
+	}
+	if n.Previous != nil {
+		payload.Repeat = n.Previous.Count
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	headers := map[string]string{"X-Yo-Go-Event": "triage"}
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(data)
+		headers["X-Yo-Go-Signature-256"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	_, err = postBody(ctx, w.url, data, headers)
+	return "", err
+}
+
+func (w *webhookNotifier) FollowUp(ctx context.Context, n *Notification, thread string) error {
+	_, err := w.Notify(ctx, n)
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testNotifyConfig writes config to a file, points NOTIFY_CONFIG at it with no channel inputs
+// set, and loads it.
+func testNotifyConfig(t *testing.T, config string) (*NotifyConfig, error) {
+	t.Helper()
+	for _, name := range []string{"SLACK_BOT_TOKEN", "SLACK_CHANNEL", "SLACK_WEBHOOK_URL", "TEAMS_WEBHOOK_URL", "DISCORD_WEBHOOK_URL", "NOTIFY_WEBHOOK_URL"} {
+		t.Setenv(name, "")
+	}
+	path := filepath.Join(t.TempDir(), "notify.json")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NOTIFY_CONFIG", path)
+	return loadNotifyConfig()
+}
+
+func TestNotifyConfigRoute(t *testing.T) {
+	cfg, err := testNotifyConfig(t, `{
+		"channels": {
+			"oncall": {"type": "slack"},
+			"infra": {"type": "teams"},
+			"audit": {"type": "webhook"}
+		},
+		"routes": [
+			{"branch": "release/.*", "channels": ["oncall", "audit"], "continue": true},
+			{"category": "infra|dependency", "channels": ["infra"]},
+			{"workflow": "Nightly", "channels": ["audit"]},
+			{"branch": "main", "channels": ["oncall"]}
+		],
+		"default": ["audit"]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		branch   string
+		workflow string
+		category string
+		want     []string
+	}{
+		{"first match wins", "main", "CI", "infra", []string{"infra"}},
+		{"continue adds later matches", "release/1.2", "CI", "infra", []string{"oncall", "audit", "infra"}},
+		{"continue without later matches", "release/1.2", "CI", "code", []string{"oncall", "audit"}},
+		{"duplicates removed", "release/1.2", "Nightly", "code", []string{"oncall", "audit"}},
+		{"patterns match the whole value", "main-backport", "CI", "code", []string{"audit"}},
+		{"later route", "main", "CI", "code", []string{"oncall"}},
+		{"no match uses default", "feature", "CI", "code", []string{"audit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Notification{Branch: tt.branch, Workflow: tt.workflow, Result: &TriageResult{Category: tt.category}}
+			if got := cfg.route(n); !slices.Equal(got, tt.want) {
+				t.Errorf("route = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyConfigRouteWithoutDefault(t *testing.T) {
+	cfg, err := testNotifyConfig(t, `{"channels": {"b": {"type": "slack"}, "a": {"type": "discord"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &Notification{Branch: "main", Result: &TriageResult{Category: "code"}}
+	if got, want := cfg.route(n), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("route = %v, want %v", got, want)
+	}
+}
+
+func TestLoadNotifyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"invalid json", `{"channels":`, "parsing notify config"},
+		{"unknown channel type", `{"channels": {"pager": {"type": "pagerduty"}}}`, "unknown channel type"},
+		{"route without channels", `{"channels": {"a": {"type": "slack"}}, "routes": [{"branch": "main"}]}`, "route 1 has no channels"},
+		{"bad pattern", `{"channels": {"a": {"type": "slack"}}, "routes": [{"branch": "(", "channels": ["a"]}]}`, "notify route 1"},
+		{"route to unknown channel", `{"channels": {"a": {"type": "slack"}}, "routes": [{"channels": ["b"]}]}`, "unknown channel b"},
+		{"default to unknown channel", `{"channels": {"a": {"type": "slack"}}, "default": ["b"]}`, "unknown channel b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testNotifyConfig(t, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadNotifyConfig error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	t.Setenv("SLACK_BOT_TOKEN", "")
+	t.Setenv("SLACK_WEBHOOK_URL", "")
+	t.Setenv("TEAMS_WEBHOOK_URL", "")
+	t.Setenv("CUSTOM_TEAMS_URL", "https://teams.example.com/hook")
+
+	tests := []struct {
+		name    string
+		channel ChannelConfig
+		want    bool // whether a notifier is returned
+	}{
+		{"slack bot without token", ChannelConfig{Type: "slack", Channel: "C123"}, false},
+		{"slack webhook without url", ChannelConfig{Type: "slack"}, false},
+		{"teams without url", ChannelConfig{Type: "teams"}, false},
+		{"teams with named url", ChannelConfig{Type: "teams", URLEnv: "CUSTOM_TEAMS_URL"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newNotifier(tt.channel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got != nil) != tt.want {
+				t.Errorf("newNotifier = %v, want notifier: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 5, "trunc..."},
+		{"héllo wörld", 5, "héllo..."},
+		{"日本語のテキスト", 3, "日本語..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := truncateRunes(tt.in, tt.n); got != tt.want {
+				t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"signed", "s3cret"},
+		{"unsigned", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				header = r.Header
+			}))
+			defer srv.Close()
+
+			n := &Notification{Repository: "acme/app", RunID: 42, Result: &TriageResult{Category: "code"}}
+			if _, err := (&webhookNotifier{url: srv.URL, secret: tt.secret}).Notify(context.Background(), n); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := header.Get("X-Yo-Go-Event"); got != "triage" {
+				t.Errorf("X-Yo-Go-Event = %q, want triage", got)
+			}
+			signature := header.Get("X-Yo-Go-Signature-256")
+			if tt.secret == "" {
+				if signature != "" {
+					t.Errorf("unsigned webhook sent signature %q", signature)
+				}
+			} else {
+				mac := hmac.New(sha256.New, []byte(tt.secret))
+				mac.Write(body)
+				if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+					t.Errorf("X-Yo-Go-Signature-256 = %q, want %q", signature, want)
+				}
+			}
+
+			var payload webhookPayload
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Repository != "acme/app" || payload.RunID != 42 || payload.Result.Category != "code" {
+				t.Errorf("payload = %+v", payload)
+			}
+		})
+	}
+}
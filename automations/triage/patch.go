@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxWholeFileLines is the largest existing file the model may rewrite wholesale. Anything bigger
+// has to be changed with edits or patches so unrelated lines aren't rewritten or truncated.
+const maxWholeFileLines = 200
+
+// EditBlock replaces one occurrence of Search with Replace in a file.
+type EditBlock struct {
+	Path    string `json:"path"`
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// FilePatch is a unified diff against a single file.
+type FilePatch struct {
+	Path string `json:"path"`
+	Diff string `json:"diff"`
+}
+
+// HunkFailure describes an edit or diff hunk that couldn't be applied.
+type HunkFailure struct {
+	Path   string
+	Hunk   string
+	Reason string
+}
+
+func (f HunkFailure) String() string {
+	return fmt.Sprintf("%s: %s\n%s", f.Path, f.Reason, truncateResult(f.Hunk, 1_000))
+}
+
+// patchError is returned when some of a fix's hunks still fail to apply after every repair attempt.
+type patchError struct {
+	failures []HunkFailure
+}
+
+func (e *patchError) Error() string {
+	paths := make([]string, len(e.failures))
+	for i, f := range e.failures {
+		paths[i] = fmt.Sprintf("%s (%s)", f.Path, f.Reason)
+	}
+	return fmt.Sprintf("%d fix hunk(s) failed to apply: %s", len(e.failures), strings.Join(paths, "; "))
+}
+
+// patchRepairPrompt asks the model to redo the hunks that failed to apply.
+func patchRepairPrompt(originalPrompt string, failures []HunkFailure) string {
+	var b strings.Builder
+	b.WriteString(originalPrompt)
+	b.WriteString("\n\n**Some Changes Failed To Apply:**\nThe changes that did apply have been written to the workspace. These did not:\n")
+	for _, f := range failures {
+		fmt.Fprintf(&b, "\n```\n%s\n```\n", f)
+	}
+	b.WriteString("\nUse read_file to look at the current state of these files, then respond with your final JSON containing only the changes that are still needed. Copy search blocks and diff context exactly from the current file.")
+	return b.String()
+}
+
+// applyFix resolves a fix against the workspace: renames first, then whole files, edits and
+// patches, then deletes and executable-bit changes. Hunks that fail to apply are reported rather
+// than guessed at; the files they belong to keep whatever hunks did apply.
+func applyFix(workspace string, fix *FixResult) (*fileChanges, []HunkFailure) {
+	changes := newFileChanges()
+	files := changes.files
+	var failures []HunkFailure
+
+	current := func(path string) (string, bool) {
+		if content, ok := files[path]; ok {
+			return content, true
+		}
+		if changes.deletes[path] {
+			return "", false
+		}
+		data, err := os.ReadFile(filepath.Join(workspace, path))
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+
+	// Renames go first so edits can target the new path
+	failures = append(failures, resolveRenames(workspace, fix.Renames, changes, current)...)
+
+	for filePath, content := range fix.Files {
+		path, ok := cleanRelPath(filePath)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: filePath, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		if existing, ok := current(path); ok && strings.Count(existing, "\n") > maxWholeFileLines && existing != content {
+			failures = append(failures, HunkFailure{
+				Path:   path,
+				Reason: fmt.Sprintf("file has more than %d lines; use edits or patches instead of returning the whole file", maxWholeFileLines),
+			})
+			continue
+		}
+		files[path] = content
+	}
+
+	for _, e := range fix.Edits {
+		path, ok := cleanRelPath(e.Path)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: e.Path, Hunk: e.Search, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		content, exists := current(path)
+		if !exists && e.Search != "" {
+			failures = append(failures, HunkFailure{Path: path, Hunk: e.Search, Reason: "file does not exist"})
+			continue
+		}
+		updated, err := applyEdit(content, e.Search, e.Replace)
+		if err != nil {
+			failures = append(failures, HunkFailure{Path: path, Hunk: e.Search, Reason: err.Error()})
+			continue
+		}
+		files[path] = updated
+	}
+
+	for _, p := range fix.Patches {
+		path, ok := cleanRelPath(p.Path)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: p.Path, Hunk: p.Diff, Reason: "path must be relative and within the repository"})
+			continue
+		}
+		content, _ := current(path)
+		updated, hunkFailures := applyUnifiedDiff(path, content, p.Diff)
+		failures = append(failures, hunkFailures...)
+		if updated != content {
+			files[path] = updated
+		}
+	}
+
+	failures = append(failures, resolveDeletesAndModes(fix.Deletes, fix.Executable, changes, current)...)
+	failures = append(failures, placeholderFailures(workspace, files)...)
+
+	return changes, failures
+}
+
+// applyEdit replaces the single occurrence of search in content. An exact match is tried first,
+// then a match that ignores indentation and trailing whitespace line by line.
+func applyEdit(content string, search string, replace string) (string, error) {
+	if search == "" {
+		if content != "" {
+			return "", fmt.Errorf("empty search block is only allowed when creating a new file")
+		}
+		return replace, nil
+	}
+
+	switch n := strings.Count(content, search); {
+	case n == 1:
+		return strings.Replace(content, search, replace, 1), nil
+	case n > 1:
+		return "", fmt.Errorf("search block matches %d places; include more surrounding lines to make it unique", n)
+	}
+
+	lines := splitLines(content)
+	searchLines := splitLines(strings.TrimSuffix(search, "\n"))
+	matches := findLines(lines, searchLines, 0, len(lines), looseLineEqual)
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("search block not found in file")
+	case 1:
+	default:
+		return "", fmt.Errorf("search block matches %d places; include more surrounding lines to make it unique", len(matches))
+	}
+
+	at := matches[0]
+	replaceLines := splitLines(strings.TrimSuffix(replace, "\n"))
+	if replace == "" {
+		replaceLines = nil
+	}
+	out := append(append(append([]string{}, lines[:at]...), replaceLines...), lines[at+len(searchLines):]...)
+	return joinLines(out, strings.HasSuffix(content, "\n")), nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type diffHunk struct {
+	oldStart int
+	oldLines []string
+	newLines []string
+	text     string
+}
+
+// parseUnifiedDiff splits a unified diff into hunks. File headers ("---", "+++", "diff --git")
+// are skipped so the model can send either a bare hunk list or a full git diff.
+func parseUnifiedDiff(diff string) ([]diffHunk, error) {
+	var hunks []diffHunk
+	var cur *diffHunk
+	var text []string
+
+	flush := func() {
+		if cur != nil {
+			cur.text = strings.Join(text, "\n")
+			hunks = append(hunks, *cur)
+		}
+		cur, text = nil, nil
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		if m := hunkHeader.FindStringSubmatch(line); m != nil {
+			flush()
+			start, _ := strconv.Atoi(m[1])
+			cur = &diffHunk{oldStart: start}
+			text = []string{line}
+			continue
+		}
+		if cur == nil {
+			continue // file headers before the first hunk
+		}
+		text = append(text, line)
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file"
+		case strings.HasPrefix(line, "+"):
+			cur.newLines = append(cur.newLines, line[1:])
+		case strings.HasPrefix(line, "-"):
+			cur.oldLines = append(cur.oldLines, line[1:])
+		case strings.HasPrefix(line, " "):
+			cur.oldLines = append(cur.oldLines, line[1:])
+			cur.newLines = append(cur.newLines, line[1:])
+		case line == "":
+			// Blank context lines often lose their leading space in model output
+			cur.oldLines = append(cur.oldLines, "")
+			cur.newLines = append(cur.newLines, "")
+		default:
+			return nil, fmt.Errorf("unexpected line in hunk: %q", line)
+		}
+	}
+	flush()
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("no hunks found in diff")
+	}
+	return hunks, nil
+}
+
+// applyUnifiedDiff applies a unified diff to content. Each hunk is located near its stated line
+// number, first exactly, then ignoring whitespace, then with up to two lines of leading and
+// trailing context dropped (like patch's fuzz factor). Hunks that still can't be placed are
+// returned as failures and the rest are applied.
+func applyUnifiedDiff(path string, content string, diff string) (string, []HunkFailure) {
+	hunks, err := parseUnifiedDiff(diff)
+	if err != nil {
+		return content, []HunkFailure{{Path: path, Hunk: diff, Reason: err.Error()}}
+	}
+
+	lines := splitLines(content)
+	if content == "" {
+		lines = nil
+	}
+	var failures []HunkFailure
+	offset := 0
+	searchFrom := 0
+
+	for _, h := range hunks {
+		// A hunk without old lines ("@@ -5,0 +6,2 @@") inserts after its stated line
+		expected := h.oldStart - 1 + offset
+		if len(h.oldLines) == 0 {
+			expected++
+		}
+		at, fuzz, ok := locateHunk(lines, h, expected, searchFrom)
+		if !ok {
+			failures = append(failures, HunkFailure{Path: path, Hunk: h.text, Reason: "hunk context not found in file"})
+			continue
+		}
+
+		oldLines := h.oldLines[fuzz : len(h.oldLines)-fuzz]
+		newLines := h.newLines[fuzz : len(h.newLines)-fuzz]
+		out := append(append(append([]string{}, lines[:at]...), newLines...), lines[at+len(oldLines):]...)
+		lines = out
+
+		offset += len(newLines) - len(oldLines)
+		searchFrom = at + len(newLines)
+	}
+
+	return joinLines(lines, content == "" || strings.HasSuffix(content, "\n")), failures
+}
+
+// locateHunk finds where a hunk's old lines sit in the file, preferring the match closest to the
+// expected line. It returns the start index and how many context lines were trimmed from each end.
+// A pure insertion has nothing to match, so it goes at the expected line, kept after earlier hunks.
+func locateHunk(lines []string, h diffHunk, expected int, from int) (int, int, bool) {
+	if len(h.oldLines) == 0 {
+		return min(max(expected, from, 0), len(lines)), 0, true
+	}
+	for fuzz := 0; fuzz <= 2; fuzz++ {
+		if 2*fuzz >= len(h.oldLines) && fuzz > 0 {
+			break
+		}
+		// Only trim lines that are context on both sides of the hunk
+		if fuzz > 0 && !contextEnds(h, fuzz) {
+			break
+		}
+		old := h.oldLines[fuzz : len(h.oldLines)-fuzz]
+		for _, eq := range []func(a, b string) bool{exactLineEqual, looseLineEqual} {
+			if matches := findLines(lines, old, from, len(lines), eq); len(matches) > 0 {
+				return closest(matches, expected+fuzz), fuzz, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// contextEnds reports whether the first and last n lines of a hunk are unchanged context.
+func contextEnds(h diffHunk, n int) bool {
+	if len(h.oldLines) < 2*n || len(h.newLines) < 2*n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if h.oldLines[i] != h.newLines[i] || h.oldLines[len(h.oldLines)-1-i] != h.newLines[len(h.newLines)-1-i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findLines returns every index in lines[from:to] where needle matches. An empty needle matches
+// nowhere; callers place pure insertions themselves.
+func findLines(lines []string, needle []string, from int, to int, eq func(a, b string) bool) []int {
+	if len(needle) == 0 {
+		return nil
+	}
+	var matches []int
+	for i := max(from, 0); i+len(needle) <= to; i++ {
+		ok := true
+		for j := range needle {
+			if !eq(lines[i+j], needle[j]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+func closest(candidates []int, target int) int {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if abs(c-target) < abs(best-target) {
+			best = c
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func exactLineEqual(a, b string) bool {
+	return strings.TrimRight(a, "\r") == strings.TrimRight(b, "\r")
+}
+
+func looseLineEqual(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// splitLines splits content into lines without the trailing empty line from a final newline.
+func splitLines(content string) []string {
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func joinLines(lines []string, trailingNewline bool) string {
+	s := strings.Join(lines, "\n")
+	if trailingNewline && len(lines) > 0 {
+		s += "\n"
+	}
+	return s
+}
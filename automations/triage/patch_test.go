@@ -0,0 +1,124 @@
+package main
+
+import "testing"
+
+func TestApplyUnifiedDiff(t *testing.T) {
+	const file = "one\ntwo\nthree\nfour\nfive\nsix\nseven\n"
+	tests := []struct {
+		name     string
+		content  string
+		diff     string
+		want     string
+		failures int
+	}{
+		{
+			name:    "replace line",
+			content: file,
+			diff:    "@@ -2,3 +2,3 @@\n two\n-three\n+THREE\n four\n",
+			want:    "one\ntwo\nTHREE\nfour\nfive\nsix\nseven\n",
+		},
+		{
+			name:    "git headers and wrong line number",
+			content: file,
+			diff:    "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,3 +1,2 @@\n five\n-six\n seven\n",
+			want:    "one\ntwo\nthree\nfour\nfive\nseven\n",
+		},
+		{
+			name:    "whitespace differences",
+			content: "func f() {\n\treturn 1\n}\n",
+			diff:    "@@ -1,3 +1,3 @@\n func f() {\n-    return 1\n+\treturn 2\n }\n",
+			want:    "func f() {\n\treturn 2\n}\n",
+		},
+		{
+			name:    "fuzzed context",
+			content: file,
+			diff:    "@@ -3,5 +3,5 @@\n tres\n four\n-five\n+FIVE\n six\n siete\n",
+			want:    "one\ntwo\nthree\nfour\nFIVE\nsix\nseven\n",
+		},
+		{
+			name:    "pure insertion",
+			content: file,
+			diff:    "@@ -4,0 +5,2 @@\n+four and a half\n+four and three quarters\n",
+			want:    "one\ntwo\nthree\nfour\nfour and a half\nfour and three quarters\nfive\nsix\nseven\n",
+		},
+		{
+			name:    "insertion at top",
+			content: file,
+			diff:    "@@ -0,0 +1 @@\n+zero\n",
+			want:    "zero\none\ntwo\nthree\nfour\nfive\nsix\nseven\n",
+		},
+		{
+			name:    "insertion past the end",
+			content: file,
+			diff:    "@@ -40,0 +41 @@\n+eight\n",
+			want:    file + "eight\n",
+		},
+		{
+			name:    "insertion after an earlier hunk",
+			content: file,
+			diff:    "@@ -1,2 +1,3 @@\n one\n+one and a half\n two\n@@ -6,0 +7 @@\n+six and a half\n",
+			want:    "one\none and a half\ntwo\nthree\nfour\nfive\nsix\nsix and a half\nseven\n",
+		},
+		{
+			name:    "new file",
+			content: "",
+			diff:    "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+hello\n+world\n",
+			want:    "hello\nworld\n",
+		},
+		{
+			name:     "missing context",
+			content:  file,
+			diff:     "@@ -1,3 +1,3 @@\n one\n-deux\n+DEUX\n three\n@@ -6,2 +6,2 @@\n-six\n+SIX\n seven\n",
+			want:     "one\ntwo\nthree\nfour\nfive\nSIX\nseven\n",
+			failures: 1,
+		},
+		{
+			name:     "not a diff",
+			content:  file,
+			diff:     "just replace three",
+			want:     file,
+			failures: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, failures := applyUnifiedDiff("f", tt.content, tt.diff)
+			if got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if len(failures) != tt.failures {
+				t.Errorf("got %d failures, want %d: %v", len(failures), tt.failures, failures)
+			}
+		})
+	}
+}
+
+func TestApplyEdit(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		search  string
+		replace string
+		want    string
+		wantErr bool
+	}{
+		{"exact", "a\nb\nc\n", "b\n", "B\n", "a\nB\nc\n", false},
+		{"indentation", "if x {\n\ty()\n}\n", "  y()", "\tz()", "if x {\n\tz()\n}\n", false},
+		{"delete", "a\nb\nc\n", "b\n", "", "a\nc\n", false},
+		{"ambiguous", "a\nb\na\n", "a", "A", "", true},
+		{"missing", "a\nb\n", "c", "C", "", true},
+		{"new file", "", "", "hello\n", "hello\n", false},
+		{"empty search", "a\n", "", "b", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyEdit(tt.content, tt.search, tt.replace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	// defaultFixPolicyFile is where a repository's fix policy is read from when FIX_POLICY isn't set.
+	defaultFixPolicyFile = ".github/triage-fix-policy.json"
+	defaultFixMaxFiles   = 10
+	defaultFixMaxLines   = 200
+)
+
+// defaultFixDeny keeps the auto-fix away from CI definitions, database migrations and files that
+// hold secrets, unless a policy file sets its own deny list.
+var defaultFixDeny = []string{
+	".github/workflows/",
+	"migrations/",
+	"secrets/",
+	".env",
+	".env.*",
+	"*.pem",
+	"*.key",
+	"id_rsa*",
+}
+
+// fixAlwaysDeny is denied whatever the policy file says: a fix that writes .git/config or a hook
+// gets to run commands the next time git runs.
+var fixAlwaysDeny = []string{".git/"}
+
+// FixPolicy limits what an auto-fix may change. Patterns are gitignore-style, like CODEOWNERS.
+// A changed path must match no Deny pattern and, if Allow is set, some Allow pattern. Paths that
+// aren't among the diagnosis's affected files are refused unless they match AllowUnaffected, so a
+// diagnosis without affected files only lets the fix touch AllowUnaffected paths.
+// MaxFiles and MaxLines cap the size of the whole fix.
+type FixPolicy struct {
+	Allow           []string `json:"allow,omitempty"`
+	Deny            []string `json:"deny"`
+	AllowUnaffected []string `json:"allowUnaffected,omitempty"`
+	MaxFiles        int      `json:"maxFiles,omitempty"`
+	MaxLines        int      `json:"maxLines,omitempty"`
+
+	allow, deny, unaffected []*regexp.Regexp
+}
+
+// loadFixPolicy reads FIX_POLICY, or .github/triage-fix-policy.json if present, from the base
+// branch of a pull_request run or the default branch otherwise, never from the checkout: a pull
+// request must not be able to loosen the policy its own fix is held to. An absolute FIX_POLICY is
+// a file the workflow provides and is read from disk. FIX_MAX_FILES and FIX_MAX_LINES override
+// the file's limits.
+func (t *Triage) loadFixPolicy(ctx context.Context) (*FixPolicy, error) {
+	path := os.Getenv("FIX_POLICY")
+	explicit := path != ""
+	if !explicit {
+		path = defaultFixPolicyFile
+	}
+
+	var data []byte
+	if filepath.IsAbs(path) {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading fix policy: %w", err)
+		}
+	} else {
+		ref := os.Getenv("GITHUB_BASE_REF") // empty means the default branch
+		file, _, resp, err := t.github.Repositories.GetContents(ctx, t.owner, t.repo, filepath.ToSlash(filepath.Clean(path)), &github.RepositoryContentGetOptions{Ref: ref})
+		switch {
+		case resp != nil && resp.StatusCode == 404 && !explicit:
+		case err != nil:
+			return nil, fmt.Errorf("reading fix policy %s from the base branch: %w", path, err)
+		case file == nil:
+			return nil, fmt.Errorf("fix policy %s is not a file", path)
+		default:
+			content, err := file.GetContent()
+			if err != nil {
+				return nil, fmt.Errorf("decoding fix policy %s: %w", path, err)
+			}
+			data = []byte(content)
+		}
+	}
+	return parseFixPolicy(path, data)
+}
+
+// parseFixPolicy parses a policy file, or the default policy when data is nil, and applies
+// FIX_MAX_FILES and FIX_MAX_LINES. A missing deny list means defaultFixDeny; an empty one denies
+// nothing beyond fixAlwaysDeny.
+func parseFixPolicy(path string, data []byte) (*FixPolicy, error) {
+	p := &FixPolicy{}
+	if data != nil {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("parsing fix policy %s: %w", path, err)
+		}
+	}
+
+	if p.Deny == nil {
+		p.Deny = defaultFixDeny
+	}
+	p.Deny = slices.Clone(p.Deny)
+	for _, pattern := range fixAlwaysDeny {
+		if !slices.Contains(p.Deny, pattern) {
+			p.Deny = append(p.Deny, pattern)
+		}
+	}
+	for _, limit := range []struct {
+		env   string
+		value *int
+		def   int
+	}{{"FIX_MAX_FILES", &p.MaxFiles, defaultFixMaxFiles}, {"FIX_MAX_LINES", &p.MaxLines, defaultFixMaxLines}} {
+		if v := os.Getenv(limit.env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%s must be a positive integer, got: %s", limit.env, v)
+			}
+			*limit.value = n
+		}
+		if *limit.value <= 0 {
+			*limit.value = limit.def
+		}
+	}
+
+	for _, list := range []struct {
+		patterns []string
+		res      *[]*regexp.Regexp
+	}{{p.Allow, &p.allow}, {p.Deny, &p.deny}, {p.AllowUnaffected, &p.unaffected}} {
+		for _, pattern := range list.patterns {
+			re, err := pathPattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("fix policy pattern %q: %w", pattern, err)
+			}
+			*list.res = append(*list.res, re)
+		}
+	}
+	return p, nil
+}
+
+// policyViolation is a change the fix policy refused, or a limit the whole fix exceeded.
+type policyViolation struct {
+	Path   string // empty for size limits
+	Reason string
+}
+
+func (v policyViolation) String() string {
+	if v.Path == "" {
+		return v.Reason
+	}
+	return fmt.Sprintf("`%s`: %s", v.Path, v.Reason)
+}
+
+// policyError is returned when a fix still breaks the fix policy after every repair attempt, or
+// exceeds its size limits.
+type policyError struct {
+	violations []policyViolation
+}
+
+func (e *policyError) Error() string {
+	reasons := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		reasons[i] = strings.ReplaceAll(v.String(), "`", "")
+	}
+	return fmt.Sprintf("fix violates the fix policy: %s", strings.Join(reasons, "; "))
+}
+
+// appendViolations adds violations that aren't already in list.
+func appendViolations(list []policyViolation, violations ...policyViolation) []policyViolation {
+	for _, v := range violations {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// matchAny returns the pattern of the first expression that matches path, or "".
+func matchAny(path string, res []*regexp.Regexp, patterns []string) string {
+	for i, re := range res {
+		if re.MatchString(path) {
+			return patterns[i]
+		}
+	}
+	return ""
+}
+
+// pathViolation returns why the policy refuses a change to path, or "" if it allows it.
+func (p *FixPolicy) pathViolation(path string, affected map[string]bool) string {
+	path = filepath.ToSlash(path)
+	if pattern := matchAny(path, p.deny, p.Deny); pattern != "" {
+		return fmt.Sprintf("matches denied pattern `%s`", pattern)
+	}
+	if len(p.allow) > 0 && matchAny(path, p.allow, p.Allow) == "" {
+		return "is not covered by the allowed patterns"
+	}
+	if !isAffected(path, affected) && matchAny(path, p.unaffected, p.AllowUnaffected) == "" {
+		if len(affected) == 0 {
+			return "is not covered by allowUnaffected, and the diagnosis lists no affected files"
+		}
+		return "is not one of the diagnosis's affected files"
+	}
+	return ""
+}
+
+// affectedSet normalises the diagnosis's affected files, which may carry line numbers.
+func affectedSet(affectedFiles []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, f := range affectedFiles {
+		path, _ := splitFileLine(f)
+		if clean, ok := cleanRelPath(path); ok {
+			set[filepath.ToSlash(clean)] = true
+		}
+	}
+	return set
+}
+
+// isAffected reports whether path is an affected file or inside an affected directory.
+func isAffected(path string, affected map[string]bool) bool {
+	for dir := path; dir != "." && dir != "/"; dir = filepath.ToSlash(filepath.Dir(dir)) {
+		if affected[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterChanges removes the changes the policy refuses from one round of a fix, so they are
+// never written, and returns them as violations.
+func (p *FixPolicy) filterChanges(changes *fileChanges, affectedFiles []string) []policyViolation {
+	affected := affectedSet(affectedFiles)
+	var violations []policyViolation
+	check := func(path string) bool {
+		reason := p.pathViolation(path, affected)
+		if reason != "" {
+			violations = append(violations, policyViolation{Path: path, Reason: reason})
+		}
+		return reason == ""
+	}
+
+	for path := range changes.files {
+		if !check(path) {
+			delete(changes.files, path)
+			delete(changes.executable, path)
+		}
+	}
+	for path := range changes.deletes {
+		if !check(path) {
+			delete(changes.deletes, path)
+		}
+	}
+	for path := range changes.executable {
+		if _, ok := changes.files[path]; !ok && !check(path) {
+			delete(changes.executable, path)
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Path < violations[j].Path })
+	return violations
+}
+
+// sizeViolations checks the whole fix so far against the file and line limits. Changed lines
+// are counted against the originals the snapshot kept: added plus removed lines.
+func (p *FixPolicy) sizeViolations(fixResult *FixResult, snapshot *workspaceSnapshot) []policyViolation {
+	paths := fixResult.changedPaths()
+	lines := 0
+	for _, path := range paths {
+		var original []string
+		if state := snapshot.originals[path]; state != nil {
+			original = splitLines(string(state.content))
+		}
+		var fixed []string
+		if content, ok := fixResult.Files[path]; ok {
+			fixed = splitLines(content)
+		} else if _, ok := fixResult.Executable[path]; ok {
+			fixed = original // only the mode changed
+		}
+		lines += changedLines(original, fixed)
+	}
+
+	var violations []policyViolation
+	if len(paths) > p.MaxFiles {
+		violations = append(violations, policyViolation{Reason: fmt.Sprintf("the fix changes %d files, more than the limit of %d", len(paths), p.MaxFiles)})
+	}
+	if lines > p.MaxLines {
+		violations = append(violations, policyViolation{Reason: fmt.Sprintf("the fix changes %d lines, more than the limit of %d", lines, p.MaxLines)})
+	}
+	return violations
+}
+
+// changedLines counts the lines added and removed between two versions of a file. Files too big
+// to diff count every line of both versions.
+func changedLines(original []string, fixed []string) int {
+	regions, ok := diffRegions(original, fixed)
+	if !ok {
+		return len(original) + len(fixed)
+	}
+	n := 0
+	for _, r := range regions {
+		n += r.oldEnd - r.oldStart + 1 + len(r.newLines)
+	}
+	return n
+}
+
+// describe summarises the policy for the fix prompt, so the model can stay within it.
+func (p *FixPolicy) describe(affectedFiles []string) string {
+	var b strings.Builder
+	b.WriteString("\n\n**Fix Policy:**\n")
+	if len(p.Deny) > 0 {
+		fmt.Fprintf(&b, "- Never change paths matching: %s\n", strings.Join(p.Deny, ", "))
+	}
+	if len(p.Allow) > 0 {
+		fmt.Fprintf(&b, "- Only change paths matching: %s\n", strings.Join(p.Allow, ", "))
+	}
+	switch {
+	case len(affectedFiles) > 0:
+		b.WriteString("- Only change the affected files")
+		if len(p.AllowUnaffected) > 0 {
+			fmt.Fprintf(&b, ", plus paths matching: %s", strings.Join(p.AllowUnaffected, ", "))
+		}
+		b.WriteString("\n")
+	case len(p.AllowUnaffected) > 0:
+		fmt.Fprintf(&b, "- No affected files were diagnosed, so only change paths matching: %s\n", strings.Join(p.AllowUnaffected, ", "))
+	default:
+		b.WriteString("- No affected files were diagnosed, so no file may be changed\n")
+	}
+	fmt.Fprintf(&b, "- Change at most %d files and %d lines in total\n", p.MaxFiles, p.MaxLines)
+	b.WriteString("If the fix needs changes outside this policy, return an empty object instead.")
+	return b.String()
+}
+
+// policyRepairPrompt asks the model to redo a fix without the changes the policy refused.
+func policyRepairPrompt(originalPrompt string, violations []policyViolation) string {
+	var b strings.Builder
+	b.WriteString(originalPrompt)
+	b.WriteString("\n\n**Changes Refused By The Fix Policy:**\nThese changes were not applied:\n")
+	for _, v := range violations {
+		fmt.Fprintf(&b, "- %s\n", v)
+	}
+	b.WriteString("\nFix the issue without them, or return an empty object if it can't be fixed within the policy.")
+	return b.String()
+}
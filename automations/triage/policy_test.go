@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestFixPolicyPathViolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string // policy file, "" for the default policy
+		path     string
+		affected []string
+		allowed  bool
+	}{
+		{"default allows affected source", "", "pkg/server.go", []string{"pkg/server.go"}, true},
+		{"default denies workflows", "", ".github/workflows/ci.yml", []string{".github/workflows/ci.yml"}, false},
+		{"default denies migrations", "", "db/migrations/001.sql", []string{"db/"}, false},
+		{"default denies env files", "", "config/.env.production", []string{"config/"}, false},
+		{"default denies keys", "", "certs/server.key", []string{"certs/"}, false},
+		{"empty deny list", `{"deny": []}`, ".github/workflows/ci.yml", []string{".github/"}, true},
+		{"git dir with default deny list", "", ".git/config", []string{".git/config"}, false},
+		{"git dir with empty deny list", `{"deny": []}`, ".git/hooks/pre-commit", []string{".git/"}, false},
+		{"nested git dir", `{"deny": []}`, "vendor/lib/.git/config", []string{"vendor/"}, false},
+		{"allow list", `{"allow": ["src/"]}`, "src/app.ts", []string{"src/app.ts"}, true},
+		{"outside allow list", `{"allow": ["src/"]}`, "scripts/build.sh", []string{"scripts/build.sh"}, false},
+		{"affected file", "", "pkg/server.go", []string{"pkg/server.go:42"}, true},
+		{"inside affected directory", "", "pkg/server/handler.go", []string{"pkg/server"}, true},
+		{"not affected", "", "pkg/client.go", []string{"pkg/server.go"}, false},
+		{"allowed although not affected", `{"allowUnaffected": ["*_test.go"]}`, "pkg/client_test.go", []string{"pkg/server.go"}, true},
+		{"no affected files", "", "pkg/server.go", nil, false},
+		{"no affected files, allowUnaffected path", `{"allowUnaffected": ["go.mod"]}`, "go.mod", nil, true},
+		{"no affected files, other path", `{"allowUnaffected": ["go.mod"]}`, "main.go", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data []byte
+			if tt.policy != "" {
+				data = []byte(tt.policy)
+			}
+			p, err := parseFixPolicy("policy.json", data)
+			if err != nil {
+				t.Fatalf("parseFixPolicy: %v", err)
+			}
+			reason := p.pathViolation(tt.path, affectedSet(tt.affected))
+			if (reason == "") != tt.allowed {
+				t.Errorf("pathViolation(%q) = %q, allowed %v", tt.path, reason, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestParseFixPolicyLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		maxFiles  string
+		wantFiles int
+		wantLines int
+		wantErr   bool
+	}{
+		{"defaults", "", "", defaultFixMaxFiles, defaultFixMaxLines, false},
+		{"from file", `{"maxFiles": 3, "maxLines": 50}`, "", 3, 50, false},
+		{"input overrides file", `{"maxFiles": 3}`, "5", 5, defaultFixMaxLines, false},
+		{"invalid input", "", "lots", 0, 0, true},
+		{"invalid file", `{"deny": "all"}`, "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FIX_MAX_FILES", tt.maxFiles)
+			t.Setenv("FIX_MAX_LINES", "")
+			var data []byte
+			if tt.policy != "" {
+				data = []byte(tt.policy)
+			}
+			p, err := parseFixPolicy("policy.json", data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (p.MaxFiles != tt.wantFiles || p.MaxLines != tt.wantLines) {
+				t.Errorf("limits = %d files, %d lines, want %d, %d", p.MaxFiles, p.MaxLines, tt.wantFiles, tt.wantLines)
+			}
+		})
+	}
+}
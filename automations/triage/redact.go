@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// defaultRedactionFile is where a repository's own redaction patterns are read from when
+// REDACTION_PATTERNS isn't set.
+const defaultRedactionFile = ".github/triage-redaction.json"
+
+const (
+	// minEntropyLength and minEntropy decide when an unrecognised token looks like a secret.
+	minEntropyLength = 24
+	minEntropy       = 4.0
+)
+
+// detector finds one kind of secret or personal data. When keep is set, only the named capture
+// group is redacted and the rest of the match (a key name, a URL scheme) stays readable.
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	keep    bool
+}
+
+var builtinDetectors = []detector{
+	{name: "private-key", pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{name: "github-token", pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "aws-access-key", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "slack-token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "slack-webhook", pattern: regexp.MustCompile(`https://hooks\.slack\.com/(?:services|workflows)/[A-Za-z0-9/_-]+`)},
+	{name: "google-api-key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "stripe-key", pattern: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{16,}\b`)},
+	{name: "npm-token", pattern: regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{name: "jwt", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{name: "url-credentials", pattern: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^:/\s@]+:(?P<secret>[^@\s/]+)@`), keep: true},
+	{name: "credential", pattern: regexp.MustCompile(`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret)["']?\s*[:=]\s*["']?(?P<secret>[^\s"',;&]{8,})`), keep: true},
+	{name: "email", pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+}
+
+// entropyCandidate matches tokens long enough to be checked for high entropy.
+var entropyCandidate = regexp.MustCompile(`[A-Za-z0-9+/_-]{24,}={0,2}`)
+
+// wordRun matches a word-like run of letters, used to tell identifiers from random tokens.
+var wordRun = regexp.MustCompile(`[A-Z]?[a-z]{2,}`)
+
+// secretEnv are variables whose values are redacted wherever they appear.
+var secretEnv = []string{"GITHUB_TOKEN", "FIX_TOKEN", "SLACK_WEBHOOK_URL", "SLACK_BOT_TOKEN", "TEAMS_WEBHOOK_URL", "DISCORD_WEBHOOK_URL", "NOTIFY_WEBHOOK_URL", "NOTIFY_WEBHOOK_SECRET"}
+
+// Redactor removes secrets and personal data from text before it is sent to the model and counts
+// what it removed by detector.
+type Redactor struct {
+	detectors []detector
+	allow     []*regexp.Regexp
+	values    []string // exact secret values from the environment
+	counts    map[string]int
+}
+
+// RedactionConfig is a repository's redaction file: extra patterns to redact and patterns for
+// values that must never be redacted (e.g. well-known test fixtures).
+type RedactionConfig struct {
+	Patterns []struct {
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+	} `json:"patterns"`
+	Allow []string `json:"allow,omitempty"`
+}
+
+// newRedactor builds the redactor from the built-in detectors, secret environment variables and
+// the repository's patterns from REDACTION_PATTERNS, or .github/triage-redaction.json if present.
+func newRedactor() (*Redactor, error) {
+	r := &Redactor{detectors: append([]detector{}, builtinDetectors...), counts: make(map[string]int)}
+	for _, name := range secretEnv {
+		if v := os.Getenv(name); len(v) >= 8 {
+			r.values = append(r.values, v)
+		}
+	}
+
+	path := os.Getenv("REDACTION_PATTERNS")
+	explicit := path != ""
+	if !explicit {
+		path = defaultRedactionFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceDir(), path)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading redaction patterns: %w", err)
+	}
+
+	var cfg RedactionConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing redaction patterns %s: %w", path, err)
+	}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %s: %w", p.Name, err)
+		}
+		if p.Name == "" {
+			p.Name = "custom"
+		}
+		// Patterns with a "secret" group redact only that group, like the built-in ones
+		r.detectors = append(r.detectors, detector{name: p.Name, pattern: re, keep: re.SubexpIndex("secret") >= 0})
+	}
+	for _, a := range cfg.Allow {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, fmt.Errorf("redaction allow pattern %q: %w", a, err)
+		}
+		r.allow = append(r.allow, re)
+	}
+
+	slog.Info("loaded redaction patterns", "patterns", len(cfg.Patterns), "allow", len(cfg.Allow))
+	return r, nil
+}
+
+func (r *Redactor) allowed(s string) bool {
+	for _, a := range r.allow {
+		if a.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) placeholder(name string) string {
+	r.counts[name]++
+	return "[REDACTED:" + name + "]"
+}
+
+// Redact returns s with every detected secret replaced by a [REDACTED:kind] placeholder.
+func (r *Redactor) Redact(s string) string {
+	for _, v := range r.values {
+		for strings.Contains(s, v) {
+			s = strings.Replace(s, v, r.placeholder("environment-secret"), 1)
+		}
+	}
+
+	for _, d := range r.detectors {
+		secret := d.pattern.SubexpIndex("secret")
+		s = d.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if r.allowed(match) || strings.Contains(match, "[REDACTED:") {
+				return match
+			}
+			if !d.keep || secret < 0 {
+				return r.placeholder(d.name)
+			}
+			m := d.pattern.FindStringSubmatchIndex(match)
+			value := match[m[2*secret]:m[2*secret+1]]
+			if r.allowed(value) || (d.name == "credential" && !looksLikeSecretValue(value)) {
+				return match
+			}
+			return match[:m[2*secret]] + r.placeholder(d.name) + match[m[2*secret+1]:]
+		})
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range entropyCandidate.FindAllStringIndex(s, -1) {
+		token := s[loc[0]:loc[1]]
+		if !highEntropy(token) || r.allowed(token) || integrityHash(s[:loc[0]], token) {
+			continue
+		}
+		b.WriteString(s[last:loc[0]])
+		b.WriteString(r.placeholder("high-entropy"))
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// integrityHash reports whether a token is a published checksum, like go.sum's "h1:" hashes or
+// npm's "sha512-" integrity values. They look random but are public and matter for debugging.
+func integrityHash(before string, token string) bool {
+	if strings.HasSuffix(before, "h1:") {
+		return true
+	}
+	for _, prefix := range []string{"sha1-", "sha256-", "sha384-", "sha512-"} {
+		if strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeSecretValue filters out the code on the right of "token = " style assignments, such
+// as os.Getenv("X"), process.env.TOKEN or a type name, which are not secrets.
+func looksLikeSecretValue(v string) bool {
+	if strings.ContainsAny(v, "()[]{}$<>") || strings.HasPrefix(v, "=") {
+		return false
+	}
+	hasDigit := strings.IndexFunc(v, unicode.IsDigit) >= 0
+	identifier := strings.IndexFunc(v, func(c rune) bool {
+		return !unicode.IsLetter(c) && c != '_' && c != '.'
+	}) < 0
+	return hasDigit || !identifier
+}
+
+// highEntropy reports whether a token looks like a random secret: long, mixing upper case, lower
+// case and several digits, with high Shannon entropy. Hex digests such as commit SHAs are left
+// alone because the model needs them.
+func highEntropy(token string) bool {
+	if len(token) < minEntropyLength {
+		return false
+	}
+	var upper, lower bool
+	digits := 0
+	for _, c := range token {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digits++
+		}
+	}
+	if !upper || !lower || digits < 2 {
+		return false
+	}
+	// Identifiers and paths like TestListJobs123 mix case and digits too, but are mostly words
+	words := 0
+	for _, w := range wordRun.FindAllString(token, -1) {
+		words += len(w)
+	}
+	if words*2 >= len(token) {
+		return false
+	}
+	return shannonEntropy(token) >= minEntropy
+}
+
+func shannonEntropy(s string) float64 {
+	freq := make(map[rune]int)
+	for _, c := range s {
+		freq[c]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, count := range freq {
+		p := float64(count) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// placeholderFailures rejects fixes that write a redaction placeholder into a file. The model only
+// ever saw "[REDACTED:...]" in place of the real value, so copying it back would corrupt the file.
+// The offending files are dropped from the change set and reported as failed hunks.
+func placeholderFailures(workspace string, files map[string]string) []HunkFailure {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var failures []HunkFailure
+	for _, path := range paths {
+		added := strings.Count(files[path], "[REDACTED:")
+		if added == 0 {
+			continue
+		}
+		if original, err := os.ReadFile(filepath.Join(workspace, path)); err == nil {
+			added -= strings.Count(string(original), "[REDACTED:")
+		}
+		if added > 0 {
+			delete(files, path)
+			failures = append(failures, HunkFailure{
+				Path:   path,
+				Reason: "change contains a [REDACTED:...] placeholder; edit around redacted values instead of rewriting the lines that hold them",
+			})
+		}
+	}
+	return failures
+}
+
+// Total returns how many values have been redacted so far.
+func (r *Redactor) Total() int {
+	total := 0
+	for _, n := range r.counts {
+		total += n
+	}
+	return total
+}
+
+// summary renders the redaction counts for the triage comment, e.g. "github-token ×1, email ×2".
+func (r *Redactor) summary() string {
+	names := make([]string, 0, len(r.counts))
+	for name := range r.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("`%s` ×%d", name, r.counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	// maxSuggestions caps the suggestion comments in one review; bigger fixes read better as a PR.
+	maxSuggestions = 25
+	// maxDiffCells bounds the line diff's table, roughly 2,000 changed lines on each side.
+	maxDiffCells = 4_000_000
+)
+
+// Fix modes: open a separate draft PR with the fix, or post it as review suggestions on the run's
+// pull request.
+const (
+	fixModePR     = "pr"
+	fixModeReview = "review"
+)
+
+// notSuggestibleError explains why a fix can't be posted as review suggestions, in which case it
+// is opened as a separate PR instead.
+type notSuggestibleError struct {
+	reason string
+}
+
+func (e *notSuggestibleError) Error() string {
+	return "fix can't be posted as review suggestions: " + e.reason
+}
+
+// PublishFix publishes a verified fix according to FIX_MODE and returns its URL. In review mode a
+// fix that can't be expressed as line suggestions is opened as a separate PR instead.
+func (t *Triage) PublishFix(ctx context.Context, triageResult *TriageResult, fixResult *FixResult) (string, error) {
+	if t.fixMode == fixModeReview {
+		url, err := t.SuggestFix(ctx, triageResult, fixResult)
+		var nse *notSuggestibleError
+		if !errors.As(err, &nse) {
+			t.fixSuggested = err == nil && url != ""
+			return url, err
+		}
+		slog.Info("falling back to a fix PR", "reason", nse.reason)
+	}
+	return t.CreateFixPR(ctx, triageResult, fixResult)
+}
+
+// fixLink renders the link to a published fix for the PR comment and check run.
+func (t *Triage) fixLink(url string) string {
+	if t.fixSuggested {
+		return fmt.Sprintf("💡 [Review with suggested changes](%s)", url)
+	}
+	return fmt.Sprintf("🔧 [Draft PR with proposed fix](%s)", url)
+}
+
+// suggestion replaces lines startLine..line of a file on the pull request's head with newLines.
+type suggestion struct {
+	path      string
+	startLine int
+	line      int
+	newLines  []string
+}
+
+// SuggestFix posts the fix as a review on the run's pull request, with one GitHub suggestion block
+// per changed region so the author can apply it with one click. Every change has to be an edit of
+// lines the pull request already touches; otherwise a *notSuggestibleError is returned and the
+// caller falls back to CreateFixPR. Returns the review's URL.
+func (t *Triage) SuggestFix(ctx context.Context, triageResult *TriageResult, fixResult *FixResult) (string, error) {
+	if fixResult == nil || len(fixResult.changedPaths()) == 0 {
+		slog.Info("no fix result to suggest")
+		return "", nil
+	}
+	if len(fixResult.Deletes) > 0 || len(fixResult.Executable) > 0 {
+		return "", &notSuggestibleError{reason: "it deletes files or changes file modes"}
+	}
+
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return "", fmt.Errorf("getting workflow run: %w", err)
+	}
+	if len(run.PullRequests) == 0 {
+		return "", &notSuggestibleError{reason: "the run has no pull request"}
+	}
+	prNumber := run.PullRequests[0].GetNumber()
+	pr, _, err := t.github.PullRequests.Get(ctx, t.owner, t.repo, prNumber)
+	if err != nil {
+		return "", fmt.Errorf("getting pull request: %w", err)
+	}
+	headSHA := pr.GetHead().GetSHA()
+
+	ranges, err := t.pullRequestLineRanges(ctx, prNumber)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(fixResult.Files))
+	for path := range fixResult.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var suggestions []suggestion
+	for _, path := range paths {
+		original, err := t.headContent(ctx, path, headSHA)
+		if err != nil {
+			return "", err
+		}
+		found, err := fileSuggestions(path, original, fixResult.Files[path], ranges[path])
+		if err != nil {
+			return "", err
+		}
+		suggestions = append(suggestions, found...)
+	}
+	if len(suggestions) > maxSuggestions {
+		return "", &notSuggestibleError{reason: fmt.Sprintf("it needs %d suggestions, more than %d", len(suggestions), maxSuggestions)}
+	}
+
+	comments := make([]*github.DraftReviewComment, len(suggestions))
+	for i, s := range suggestions {
+		comment := &github.DraftReviewComment{
+			Path: github.Ptr(s.path),
+			Body: github.Ptr(suggestionBody(s.newLines)),
+			Side: github.Ptr("RIGHT"),
+			Line: github.Ptr(s.line),
+		}
+		if s.startLine < s.line {
+			comment.StartLine = github.Ptr(s.startLine)
+			comment.StartSide = github.Ptr("RIGHT")
+		}
+		comments[i] = comment
+	}
+
+	body := fmt.Sprintf("## 🔧 Suggested Fix\n\n**Category:** %s\n**Confidence:** %s\n\n**Root Cause:**\n%s\n\n**Suggested Fix:**\n%s%s\n\n"+
+		"Apply the suggestions below, or add them to a batch and commit them together.",
+		triageResult.Category,
+		triageResult.Confidence,
+		triageResult.RootCause,
+		triageResult.SuggestedFix,
+		verificationSummary(fixResult.Verification),
+	)
+	review, _, err := t.github.PullRequests.CreateReview(ctx, t.owner, t.repo, prNumber, &github.PullRequestReviewRequest{
+		CommitID: github.Ptr(headSHA),
+		Body:     github.Ptr(body),
+		Event:    github.Ptr("COMMENT"),
+		Comments: comments,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating review: %w", err)
+	}
+
+	slog.Info("posted fix as review suggestions", "pr", prNumber, "suggestions", len(comments), "url", review.GetHTMLURL())
+	return review.GetHTMLURL(), nil
+}
+
+// lineRange is an inclusive range of lines on the head side of a pull request's diff.
+type lineRange struct {
+	start, end int
+}
+
+// pullRequestLineRanges returns, per file, the head-side line ranges covered by the pull
+// request's diff hunks. Review comments can only be placed on these lines.
+func (t *Triage) pullRequestLineRanges(ctx context.Context, prNumber int) (map[string][]lineRange, error) {
+	ranges := make(map[string][]lineRange)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := t.github.PullRequests.ListFiles(ctx, t.owner, t.repo, prNumber, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing pull request files: %w", err)
+		}
+		for _, f := range files {
+			for _, line := range strings.Split(f.GetPatch(), "\n") {
+				m := hunkHeader.FindStringSubmatch(line)
+				if m == nil {
+					continue
+				}
+				start, _ := strconv.Atoi(m[3])
+				count := 1
+				if m[4] != "" {
+					count, _ = strconv.Atoi(m[4])
+				}
+				if count > 0 {
+					ranges[f.GetFilename()] = append(ranges[f.GetFilename()], lineRange{start, start + count - 1})
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return ranges, nil
+}
+
+// headContent returns a file as it is on the pull request's head, and makes sure the fix was
+// written against that same content. pull_request runs check out a merge with the base branch,
+// and suggestions computed against the merge would also revert the base branch's changes.
+func (t *Triage) headContent(ctx context.Context, path string, headSHA string) (string, error) {
+	file, _, resp, err := t.github.Repositories.GetContents(ctx, t.owner, t.repo, path, &github.RepositoryContentGetOptions{Ref: headSHA})
+	if resp != nil && resp.StatusCode == 404 {
+		return "", &notSuggestibleError{reason: fmt.Sprintf("%s is a new file", path)}
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting %s at %s: %w", path, shortSHA(headSHA), err)
+	}
+	if file == nil {
+		return "", &notSuggestibleError{reason: fmt.Sprintf("%s is not a file", path)}
+	}
+	head, err := file.GetContent()
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	checkedOut, err := runGit(ctx, "show", "HEAD:"+path)
+	if err != nil {
+		return "", &notSuggestibleError{reason: fmt.Sprintf("can't read the checked-out %s: %v", path, err)}
+	}
+	if checkedOut != head {
+		return "", &notSuggestibleError{reason: fmt.Sprintf("%s in the checkout differs from the pull request head", path)}
+	}
+	return head, nil
+}
+
+// fileSuggestions turns the change from original to fixed into suggestions, each of which must lie
+// within one of the file's diff ranges.
+func fileSuggestions(path string, original string, fixed string, ranges []lineRange) ([]suggestion, error) {
+	if strings.HasSuffix(original, "\n") != strings.HasSuffix(fixed, "\n") {
+		return nil, &notSuggestibleError{reason: fmt.Sprintf("%s changes its final newline", path)}
+	}
+	if original == "" {
+		return nil, &notSuggestibleError{reason: fmt.Sprintf("%s is empty on the pull request head", path)}
+	}
+	oldLines, newLines := splitLines(original), splitLines(fixed)
+	regions, ok := diffRegions(oldLines, newLines)
+	if !ok {
+		return nil, &notSuggestibleError{reason: fmt.Sprintf("%s changes too much to diff line by line", path)}
+	}
+
+	var suggestions []suggestion
+	for _, r := range regions {
+		s := suggestion{path: path, startLine: r.oldStart, line: r.oldEnd, newLines: r.newLines}
+		// A suggestion has to replace at least one line, so pure insertions take a neighbour along
+		if r.oldEnd < r.oldStart {
+			if r.oldStart > 1 {
+				s.startLine, s.line = r.oldStart-1, r.oldStart-1
+				s.newLines = append([]string{oldLines[r.oldStart-2]}, r.newLines...)
+			} else {
+				s.startLine, s.line = 1, 1
+				s.newLines = append(append([]string{}, r.newLines...), oldLines[0])
+			}
+		}
+		if !withinRanges(s.startLine, s.line, ranges) {
+			return nil, &notSuggestibleError{reason: fmt.Sprintf("%s:%d is outside the pull request's diff", path, s.startLine)}
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
+
+func withinRanges(start int, end int, ranges []lineRange) bool {
+	for _, r := range ranges {
+		if start >= r.start && end <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestionBody wraps lines in a suggestion block, with a fence longer than any in the code.
+func suggestionBody(lines []string) string {
+	fence := "```"
+	for strings.Contains(strings.Join(lines, "\n"), fence) {
+		fence += "`"
+	}
+	return fence + "suggestion\n" + joinLines(lines, true) + fence
+}
+
+// diffRegion replaces old lines oldStart..oldEnd (1-based, inclusive; oldEnd < oldStart for a
+// pure insertion before oldStart) with newLines.
+type diffRegion struct {
+	oldStart, oldEnd int
+	newLines         []string
+}
+
+// diffRegions computes a line diff as the regions that changed, from the longest common
+// subsequence of the lines between the common prefix and suffix. It reports false when that
+// middle part is too big to diff.
+func diffRegions(a []string, b []string) ([]diffRegion, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var regions []diffRegion
+	var cur *diffRegion
+	flush := func() {
+		if cur != nil {
+			regions = append(regions, *cur)
+			cur = nil
+		}
+	}
+	open := func(i int) {
+		if cur == nil {
+			cur = &diffRegion{oldStart: prefix + i + 1, oldEnd: prefix + i}
+		}
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			flush()
+			i++
+			j++
+		case j < len(midB) && (i == len(midA) || lcs[i][j+1] >= lcs[i+1][j]):
+			open(i)
+			cur.newLines = append(cur.newLines, midB[j])
+			j++
+		default:
+			open(i)
+			cur.oldEnd++
+			i++
+		}
+	}
+	flush()
+	return regions, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		regions int
+		changed int // lines added plus removed
+	}{
+		{"identical", "a b c", "a b c", 0, 0},
+		{"replace one line", "a b c", "a X c", 1, 2},
+		{"insert", "a b c", "a b N c", 1, 1},
+		{"insert at start", "a b c", "N a b c", 1, 1},
+		{"append", "a b c", "a b c N", 1, 1},
+		{"delete", "a b c d", "a d", 1, 2},
+		{"two separate changes", "a b c d e f", "a X c d Y f", 2, 4},
+		{"from empty", "", "a b", 1, 2},
+		{"to empty", "a b", "", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+			regions, ok := diffRegions(a, b)
+			if !ok {
+				t.Fatal("diffRegions gave up")
+			}
+			if len(regions) != tt.regions {
+				t.Errorf("got %d regions, want %d: %+v", len(regions), tt.regions, regions)
+			}
+			if n := changedLines(a, b); n != tt.changed {
+				t.Errorf("changedLines = %d, want %d", n, tt.changed)
+			}
+
+			// Applying the regions from the bottom up must turn a into b
+			got := slices.Clone(a)
+			for i := len(regions) - 1; i >= 0; i-- {
+				r := regions[i]
+				got = slices.Concat(got[:r.oldStart-1], r.newLines, got[max(r.oldEnd, r.oldStart-1):])
+			}
+			if !slices.Equal(got, b) {
+				t.Errorf("applying %+v gives %v, want %v", regions, got, b)
+			}
+		})
+	}
+}
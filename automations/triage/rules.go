@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//go:embed rules.json
+var builtinRules []byte
+
+// defaultRulesFile is where a repository's own rules are read from when TRIAGE_RULES isn't set.
+const defaultRulesFile = ".github/triage-rules.json"
+
+// Rule maps a well-known failure, recognised by a regular expression over a failed job's log, to
+// a fixed diagnosis. RootCause, SuggestedFix and AffectedFiles may refer to capture groups as
+// $1 or ${name}.
+type Rule struct {
+	Name          string   `json:"name"`
+	Pattern       string   `json:"pattern"`
+	Job           string   `json:"job,omitempty"` // only match jobs whose name matches this regex
+	Category      string   `json:"category"`
+	RootCause     string   `json:"rootCause"`
+	SuggestedFix  string   `json:"suggestedFix"`
+	Confidence    string   `json:"confidence,omitempty"`
+	Fixable       bool     `json:"fixable"`
+	AffectedFiles []string `json:"affectedFiles,omitempty"`
+	Disabled      bool     `json:"disabled,omitempty"` // turns off a built-in rule of the same name
+
+	pattern *regexp.Regexp
+	job     *regexp.Regexp
+}
+
+type ruleFile struct {
+	Rules []*Rule `json:"rules"`
+}
+
+func parseRules(data []byte, source string) ([]*Rule, error) {
+	var f ruleFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing rules from %s: %w", source, err)
+	}
+	for _, r := range f.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule in %s has no name", source)
+		}
+		if r.Disabled {
+			continue
+		}
+		var err error
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("rule %s in %s: invalid pattern: %w", r.Name, source, err)
+		}
+		if r.Job != "" {
+			if r.job, err = regexp.Compile(r.Job); err != nil {
+				return nil, fmt.Errorf("rule %s in %s: invalid job pattern: %w", r.Name, source, err)
+			}
+		}
+		if r.Category == "" || r.RootCause == "" {
+			return nil, fmt.Errorf("rule %s in %s needs a category and rootCause", r.Name, source)
+		}
+		if !slices.Contains(triageCategories, r.Category) {
+			return nil, fmt.Errorf("rule %s in %s: category must be one of %s", r.Name, source, strings.Join(triageCategories, ", "))
+		}
+		if r.Confidence != "" && !slices.Contains(confidenceLevels, r.Confidence) {
+			return nil, fmt.Errorf("rule %s in %s: confidence must be one of %s", r.Name, source, strings.Join(confidenceLevels, ", "))
+		}
+	}
+	return f.Rules, nil
+}
+
+// loadRules returns the repository's rules followed by the built-in ones. A repository rule with
+// the same name as a built-in replaces it, or switches it off with "disabled": true. The rule
+// file is TRIAGE_RULES, relative to the workspace, or .github/triage-rules.json if present.
+func loadRules() ([]*Rule, error) {
+	builtin, err := parseRules(builtinRules, "built-in rules")
+	if err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv("TRIAGE_RULES")
+	explicit := path != ""
+	if !explicit {
+		path = defaultRulesFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceDir(), path)
+	}
+
+	var repoRules []*Rule
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if repoRules, err = parseRules(data, path); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err) || explicit:
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	overridden := make(map[string]bool)
+	var rules []*Rule
+	for _, r := range repoRules {
+		overridden[r.Name] = true
+		if !r.Disabled {
+			rules = append(rules, r)
+		}
+	}
+	for _, r := range builtin {
+		if !overridden[r.Name] && !r.Disabled {
+			rules = append(rules, r)
+		}
+	}
+
+	slog.Info("loaded known-failure rules", "repo", len(repoRules), "total", len(rules))
+	return rules, nil
+}
+
+// MatchRules checks every failed job's log against the known-failure rules in order and returns
+// the diagnosis of the first rule that matches, or nil if none do.
+func (t *Triage) MatchRules(ctx context.Context) *TriageResult {
+	if len(t.rules) == 0 {
+		return nil
+	}
+
+	jobs, err := t.failedJobs(ctx)
+	if err != nil {
+		slog.Warn("error listing failed jobs for rule matching", "err", err)
+		return nil
+	}
+
+	logs := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		content, err := t.downloadJobLogs(ctx, job.GetID())
+		if err != nil {
+			slog.Warn("error downloading logs for rule matching", "job", job.GetName(), "err", err)
+			continue
+		}
+		logs[job.GetName()] = strings.Join(stripLogTimestamps(content), "\n")
+	}
+
+	for _, r := range t.rules {
+		for _, job := range jobs {
+			if r.job != nil && !r.job.MatchString(job.GetName()) {
+				continue
+			}
+			content := logs[job.GetName()]
+			match := r.pattern.FindStringSubmatchIndex(content)
+			if match == nil {
+				continue
+			}
+			slog.Info("failure matched known-failure rule", "rule", r.Name, "job", job.GetName())
+			return r.result(content, match)
+		}
+	}
+	return nil
+}
+
+// result builds the rule's diagnosis, expanding capture group references from the match.
+func (r *Rule) result(content string, match []int) *TriageResult {
+	expand := func(template string) string {
+		return string(r.pattern.ExpandString(nil, template, content, match))
+	}
+
+	confidence := r.Confidence
+	if confidence == "" {
+		confidence = "high"
+	}
+	result := &TriageResult{
+		Category:     r.Category,
+		RootCause:    expand(r.RootCause),
+		SuggestedFix: expand(r.SuggestedFix),
+		Confidence:   confidence,
+		Fixable:      r.Fixable,
+		Rule:         r.Name,
+	}
+	for _, f := range r.AffectedFiles {
+		if path := expand(f); path != "" {
+			result.AffectedFiles = appendUnique(result.AffectedFiles, path)
+		}
+	}
+	return result
+}
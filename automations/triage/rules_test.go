@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// matchBuiltin returns the diagnosis of the first built-in rule matching a job log, or nil.
+func matchBuiltin(t *testing.T, log string) *TriageResult {
+	t.Helper()
+	rules, err := parseRules(builtinRules, "built-in rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rules {
+		if match := r.pattern.FindStringSubmatchIndex(log); match != nil {
+			return r.result(log, match)
+		}
+	}
+	return nil
+}
+
+func TestBuiltinRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		log           string
+		rule          string // "" for no match
+		fixable       bool
+		affectedFiles []string
+		rootCause     string
+	}{
+		{
+			name:          "missing go.sum entry is not fixable",
+			log:           "main.go:5:2: missing go.sum entry for module providing package github.com/google/uuid (imported by example.com/app); to add:\n\tgo get example.com/app",
+			rule:          "go-sum-missing",
+			affectedFiles: []string{"go.sum"},
+			rootCause:     "package github.com/google/uuid.",
+		},
+		{
+			name:          "gofmt",
+			log:           "level=info msg=\"linting\"\npkg/server/handler.go:12: File is not `gofmt`-ed with `-s` (gofmt)",
+			rule:          "gofmt",
+			fixable:       true,
+			affectedFiles: []string{"pkg/server/handler.go"},
+			rootCause:     "pkg/server/handler.go is not formatted",
+		},
+		{
+			name:      "docker hub rate limit",
+			log:       "Error response from daemon: toomanyrequests: You have reached your unauthenticated pull rate limit.",
+			rule:      "docker-hub-rate-limit",
+			rootCause: "Docker Hub",
+		},
+		{
+			name: "out of disk",
+			log:  "write /tmp/go-build123/b001/_pkg_.a: No space left on device",
+			rule: "runner-out-of-disk",
+		},
+		{
+			name: "runner lost",
+			log:  "The runner has received a shutdown signal. This can happen when the runner service is stopped.",
+			rule: "runner-lost",
+		},
+		{
+			name: "ordinary test failure",
+			log:  "--- FAIL: TestHandler (0.01s)\n    handler_test.go:42: got 500, want 200",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := matchBuiltin(t, tt.log)
+			if tt.rule == "" {
+				if result != nil {
+					t.Fatalf("matched rule %s, want no match", result.Rule)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatalf("no rule matched, want %s", tt.rule)
+			}
+			if result.Rule != tt.rule {
+				t.Errorf("rule = %s, want %s", result.Rule, tt.rule)
+			}
+			if result.Fixable != tt.fixable {
+				t.Errorf("fixable = %v, want %v", result.Fixable, tt.fixable)
+			}
+			if !slices.Equal(result.AffectedFiles, tt.affectedFiles) {
+				t.Errorf("affected files = %v, want %v", result.AffectedFiles, tt.affectedFiles)
+			}
+			if !strings.Contains(result.RootCause, tt.rootCause) {
+				t.Errorf("root cause %q doesn't contain %q", result.RootCause, tt.rootCause)
+			}
+		})
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"no name", `{"rules":[{"pattern":"x","category":"lint","rootCause":"x"}]}`, "has no name"},
+		{"invalid pattern", `{"rules":[{"name":"r","pattern":"(","category":"lint","rootCause":"x"}]}`, "invalid pattern"},
+		{"invalid job", `{"rules":[{"name":"r","pattern":"x","job":"[","category":"lint","rootCause":"x"}]}`, "invalid job pattern"},
+		{"no root cause", `{"rules":[{"name":"r","pattern":"x","category":"lint"}]}`, "needs a category and rootCause"},
+		{"unknown category", `{"rules":[{"name":"r","pattern":"x","category":"weather","rootCause":"x"}]}`, "category must be one of"},
+		{"unknown confidence", `{"rules":[{"name":"r","pattern":"x","category":"lint","rootCause":"x","confidence":"certain"}]}`, "confidence must be one of"},
+		{"not json", `rules:`, "parsing rules"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRules([]byte(tt.json), "test.json")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // repository rules, "" for none
+		want []string
+	}{
+		{
+			name: "built-in only",
+			want: []string{"go-sum-missing", "gofmt", "docker-hub-rate-limit", "runner-out-of-disk", "runner-lost"},
+		},
+		{
+			name: "repository rules first, replacing and disabling built-ins",
+			file: `{"rules":[
+				{"name":"flaky-e2e","pattern":"e2e timeout","category":"flaky","rootCause":"x"},
+				{"name":"go-sum-missing","pattern":"missing go\\.sum","category":"dependency","rootCause":"x","fixable":true},
+				{"name":"runner-lost","disabled":true}
+			]}`,
+			want: []string{"flaky-e2e", "go-sum-missing", "gofmt", "docker-hub-rate-limit", "runner-out-of-disk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspace := t.TempDir()
+			t.Setenv("GITHUB_WORKSPACE", workspace)
+			t.Setenv("TRIAGE_RULES", "")
+			if tt.file != "" {
+				path := filepath.Join(workspace, defaultRulesFile)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rules, err := loadRules()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range rules {
+				names = append(names, r.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("rules = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// triageCategories and confidenceLevels are the values triage.md documents for a diagnosis.
+var (
+	triageCategories = []string{"build", "test", "lint", "dependency", "infra", "flaky", "unknown"}
+	confidenceLevels = []string{"high", "medium", "low"}
+)
+
+// formatRepairs is how many times the model is asked to correct a final answer that doesn't
+// parse or validate before the answer is rejected.
+const formatRepairs = 2
+
+// ResponseFormat asks the models API for structured output matching a JSON schema.
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat is the schema half of a json_schema response format. Strict schemas must
+// list every property as required and can't use open-ended objects.
+type JSONSchemaFormat struct {
+	Name   string      `json:"name"`
+	Strict bool        `json:"strict"`
+	Schema interface{} `json:"schema"`
+}
+
+// responseFormatError is returned when the API rejects a request's response_format (400),
+// which providers without structured output do.
+type responseFormatError struct {
+	body string
+}
+
+func (e *responseFormatError) Error() string {
+	return fmt.Sprintf("response_format not supported: %s", e.body)
+}
+
+// triageResultFormat is the schema of the diagnosis in triage.md.
+var triageResultFormat = &ResponseFormat{
+	Type: "json_schema",
+	JSONSchema: &JSONSchemaFormat{
+		Name:   "triage_result",
+		Strict: true,
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"category":      map[string]interface{}{"type": "string", "enum": triageCategories},
+				"rootCause":     map[string]interface{}{"type": "string"},
+				"suggestedFix":  map[string]interface{}{"type": "string"},
+				"confidence":    map[string]interface{}{"type": "string", "enum": confidenceLevels},
+				"fixable":       map[string]interface{}{"type": "boolean"},
+				"affectedFiles": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+			"required":             []string{"category", "rootCause", "suggestedFix", "confidence", "fixable", "affectedFiles"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// fixResultFormat is the schema of the changes in fix.md. It isn't strict: files and executable
+// are maps keyed by path, which strict schemas can't express.
+var fixResultFormat = &ResponseFormat{
+	Type: "json_schema",
+	JSONSchema: &JSONSchemaFormat{
+		Name: "fix_result",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"edits": map[string]interface{}{
+					"type":  "array",
+					"items": stringObjectSchema("path", "search", "replace"),
+				},
+				"patches": map[string]interface{}{
+					"type":  "array",
+					"items": stringObjectSchema("path", "diff"),
+				},
+				"files": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+				"deletes": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				"renames": map[string]interface{}{
+					"type":  "array",
+					"items": stringObjectSchema("from", "to"),
+				},
+				"executable": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "boolean"},
+				},
+			},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// stringObjectSchema is an object schema whose properties are all required strings.
+func stringObjectSchema(names ...string) map[string]interface{} {
+	properties := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		properties[name] = map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             names,
+		"additionalProperties": false,
+	}
+}
+
+// parseTriageResult parses and validates the model's diagnosis.
+func parseTriageResult(response string) (*TriageResult, error) {
+	var result TriageResult
+	if err := json.Unmarshal([]byte(extractJSON(response)), &result); err != nil {
+		return nil, fmt.Errorf("parsing triage result: %w", err)
+	}
+	if err := result.validate(); err != nil {
+		return nil, fmt.Errorf("invalid triage result: %w", err)
+	}
+	return &result, nil
+}
+
+// validate checks the enums and required fields of a diagnosis. Enum values are normalised to
+// lower case first, since "High" is a formatting slip rather than a wrong answer.
+func (r *TriageResult) validate() error {
+	r.Category = strings.ToLower(strings.TrimSpace(r.Category))
+	r.Confidence = strings.ToLower(strings.TrimSpace(r.Confidence))
+
+	var problems []string
+	if !slices.Contains(triageCategories, r.Category) {
+		problems = append(problems, fmt.Sprintf("category %q is not one of %s", r.Category, strings.Join(triageCategories, ", ")))
+	}
+	if !slices.Contains(confidenceLevels, r.Confidence) {
+		problems = append(problems, fmt.Sprintf("confidence %q is not one of %s", r.Confidence, strings.Join(confidenceLevels, ", ")))
+	}
+	if strings.TrimSpace(r.RootCause) == "" {
+		problems = append(problems, "rootCause is required")
+	}
+	if strings.TrimSpace(r.SuggestedFix) == "" {
+		problems = append(problems, "suggestedFix is required")
+	}
+	if r.Category == "flaky" && r.Fixable {
+		problems = append(problems, "flaky failures are never fixable")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// parseFixResult parses and validates the model's changes.
+func parseFixResult(response string) (*FixResult, error) {
+	var fixResult FixResult
+	if err := json.Unmarshal([]byte(extractJSON(response)), &fixResult); err != nil {
+		return nil, fmt.Errorf("parsing fix result: %w", err)
+	}
+	if err := fixResult.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fix result: %w", err)
+	}
+	return &fixResult, nil
+}
+
+// validate checks that every change names its paths. Whether the changes apply is checked later,
+// against the workspace, by applyFix.
+func (f *FixResult) validate() error {
+	var problems []string
+	for i, e := range f.Edits {
+		if e.Path == "" {
+			problems = append(problems, fmt.Sprintf("edits[%d] has no path", i))
+		}
+	}
+	for i, p := range f.Patches {
+		if p.Path == "" {
+			problems = append(problems, fmt.Sprintf("patches[%d] has no path", i))
+		}
+	}
+	for path := range f.Files {
+		if path == "" {
+			problems = append(problems, "files has an empty path")
+		}
+	}
+	for i, path := range f.Deletes {
+		if path == "" {
+			problems = append(problems, fmt.Sprintf("deletes[%d] is empty", i))
+		}
+	}
+	for i, r := range f.Renames {
+		if r.From == "" || r.To == "" {
+			problems = append(problems, fmt.Sprintf("renames[%d] needs from and to", i))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// formatRepairPrompt asks the model to correct a final answer that failed to parse or validate.
+func formatRepairPrompt(err error) string {
+	return fmt.Sprintf("Your final answer was rejected: %v\n\nRespond again with ONLY the corrected JSON object, "+
+		"following the format in your instructions. Do not call any more tools.", err)
+}
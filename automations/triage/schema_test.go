@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTriageResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  string
+	}{
+		{
+			name:     "valid",
+			response: `{"category":"test","rootCause":"assertion","suggestedFix":"fix it","confidence":"high","fixable":true,"affectedFiles":["a_test.go"]}`,
+		},
+		{
+			name:     "fenced with normalised enums",
+			response: "Here you go:\n```json\n{\"category\":\" Build\",\"rootCause\":\"r\",\"suggestedFix\":\"s\",\"confidence\":\"Medium\",\"fixable\":false,\"affectedFiles\":[]}\n```",
+		},
+		{
+			name:     "not json",
+			response: "I could not work out what failed.",
+			wantErr:  "parsing triage result",
+		},
+		{
+			name:     "unknown category",
+			response: `{"category":"network","rootCause":"r","suggestedFix":"s","confidence":"high","fixable":false,"affectedFiles":[]}`,
+			wantErr:  `category "network" is not one of`,
+		},
+		{
+			name:     "missing root cause and bad confidence",
+			response: `{"category":"lint","rootCause":" ","suggestedFix":"s","confidence":"certain","fixable":false,"affectedFiles":[]}`,
+			wantErr:  "rootCause is required",
+		},
+		{
+			name:     "fixable flaky",
+			response: `{"category":"flaky","rootCause":"r","suggestedFix":"s","confidence":"low","fixable":true,"affectedFiles":[]}`,
+			wantErr:  "flaky failures are never fixable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseTriageResult(tt.response)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Category != strings.ToLower(strings.TrimSpace(result.Category)) || result.Confidence != strings.ToLower(result.Confidence) {
+					t.Errorf("enums not normalised: %+v", result)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseTriageResult error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFixResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  string
+	}{
+		{"edits", `{"edits":[{"path":"a.go","search":"x","replace":"y"}]}`, ""},
+		{"files", `{"files":{"a.go":"package a\n"}}`, ""},
+		{"not json", `no changes needed`, "parsing fix result"},
+		{"edit without path", `{"edits":[{"search":"x","replace":"y"}]}`, "edits[0] has no path"},
+		{"empty file path", `{"files":{"":"x"}}`, "files has an empty path"},
+		{"empty delete", `{"deletes":["a.go",""]}`, "deletes[1] is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFixResult(tt.response)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFixResult error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// modelReply is one canned models API response: an error status and body, or an answer.
+type modelReply struct {
+	status int
+	body   string
+}
+
+func answerReply(content string) modelReply {
+	data, _ := json.Marshal(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{
+			"message":       map[string]string{"role": "assistant", "content": content},
+			"finish_reason": "stop",
+		}},
+	})
+	return modelReply{http.StatusOK, string(data)}
+}
+
+func TestRunToolLoopStructuredOutput(t *testing.T) {
+	valid := `{"category":"test","rootCause":"r","suggestedFix":"s","confidence":"high","fixable":false,"affectedFiles":[]}`
+	unsupported := modelReply{http.StatusBadRequest, `{"error":"unsupported parameter: response_format"}`}
+
+	tests := []struct {
+		name           string
+		replies        []modelReply
+		wantErr        string
+		wantStructured bool
+		wantFormats    []bool // whether each request carried a response_format
+	}{
+		{
+			name:           "structured answer",
+			replies:        []modelReply{answerReply(valid)},
+			wantStructured: true,
+			wantFormats:    []bool{true},
+		},
+		{
+			name:        "falls back to prompt-only JSON",
+			replies:     []modelReply{unsupported, answerReply(valid)},
+			wantFormats: []bool{true, false},
+		},
+		{
+			name:        "fallback hits the token limit",
+			replies:     []modelReply{unsupported, {http.StatusRequestEntityTooLarge, "too large"}, answerReply(valid)},
+			wantFormats: []bool{true, false, false},
+		},
+		{
+			name:        "fallback hits the content filter",
+			replies:     []modelReply{unsupported, {http.StatusBadRequest, `{"error":"content_filter"}`}, answerReply(valid)},
+			wantFormats: []bool{true, false, false},
+		},
+		{
+			name:        "fallback fails",
+			replies:     []modelReply{unsupported, {http.StatusInternalServerError, "boom"}},
+			wantErr:     "status 500",
+			wantFormats: []bool{true, false},
+		},
+		{
+			name:           "invalid answer is repaired",
+			replies:        []modelReply{answerReply(`{"category":"weird"}`), answerReply(valid)},
+			wantStructured: true,
+			wantFormats:    []bool{true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var formats []bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req ChatRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("decoding request: %v", err)
+				}
+				if len(formats) >= len(tt.replies) {
+					t.Errorf("unexpected request %d", len(formats)+1)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				reply := tt.replies[len(formats)]
+				formats = append(formats, req.ResponseFormat != nil)
+				w.WriteHeader(reply.status)
+				fmt.Fprint(w, reply.body)
+			}))
+			defer srv.Close()
+			defer func(url string) { modelsURL = url }(modelsURL)
+			modelsURL = srv.URL
+
+			triage := &Triage{model: &modelSettings{name: "openai/gpt-4o", ModelLimits: defaultModelLimits, structuredOutput: true}}
+			answer, err := triage.runToolLoop(context.Background(), "system", "user", nil, triageResultFormat, func(answer string) error {
+				_, err := parseTriageResult(answer)
+				return err
+			})
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("runToolLoop error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case answer != valid:
+				t.Errorf("answer = %q, want %q", answer, valid)
+			}
+			if triage.model.structuredOutput != tt.wantStructured && tt.wantErr == "" {
+				t.Errorf("structuredOutput = %v, want %v", triage.model.structuredOutput, tt.wantStructured)
+			}
+			if fmt.Sprint(formats) != fmt.Sprint(tt.wantFormats) {
+				t.Errorf("requests with response_format = %v, want %v", formats, tt.wantFormats)
+			}
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TestFailure is a single failing test extracted from CI output.
+type TestFailure struct {
+	Framework string `json:"framework"`
+	Job       string `json:"job,omitempty"`
+	Test      string `json:"test"`
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Panic     bool   `json:"panic,omitempty"`
+}
+
+// maxFailureMessage caps the assertion message kept per failure.
+const maxFailureMessage = 1_000
+
+// logTimestamp matches the timestamp GitHub Actions prefixes to every log line.
+var logTimestamp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?Z ?`)
+
+// stripLogTimestamps removes the Actions timestamp prefix and ANSI colour codes from each line.
+func stripLogTimestamps(logs string) []string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := logTimestamp.ReplaceAllString(scanner.Text(), "")
+		lines = append(lines, ansiEscape.ReplaceAllString(line, ""))
+	}
+	return lines
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// parseTestFailures runs every parser over a job's log and returns the de-duplicated failures.
+func parseTestFailures(logs string) []TestFailure {
+	lines := stripLogTimestamps(logs)
+
+	var failures []TestFailure
+	failures = append(failures, parseGoTestJSON(lines)...)
+	failures = append(failures, parseGoTest(lines)...)
+	failures = append(failures, parseJest(lines)...)
+	failures = append(failures, parsePytest(lines)...)
+	failures = append(failures, parsePlaywright(lines)...)
+	return dedupeFailures(failures)
+}
+
+// dedupeFailures drops repeated failures for the same test, keeping the most detailed one.
+func dedupeFailures(failures []TestFailure) []TestFailure {
+	index := make(map[string]int)
+	var out []TestFailure
+	for _, f := range failures {
+		f.Message = truncateResult(strings.TrimSpace(f.Message), maxFailureMessage)
+		key := f.Job + "\x00" + f.Framework + "\x00" + f.Test
+		if i, ok := index[key]; ok {
+			if out[i].File == "" && f.File != "" {
+				out[i].File, out[i].Line = f.File, f.Line
+			}
+			if len(f.Message) > len(out[i].Message) {
+				out[i].Message = f.Message
+			}
+			out[i].Panic = out[i].Panic || f.Panic
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, f)
+	}
+	return out
+}
+
+var goFileLine = regexp.MustCompile(`^\s*([\w./-]+\.go):(\d+):\s?(.*)$`)
+
+// parseGoTestJSON handles `go test -json` output, collecting each test's output until its fail event.
+func parseGoTestJSON(lines []string) []TestFailure {
+	type event struct {
+		Action  string
+		Package string
+		Test    string
+		Output  string
+	}
+
+	output := make(map[string][]string)
+	var failures []TestFailure
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") || !strings.Contains(line, `"Action"`) {
+			continue
+		}
+		var ev event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Test == "" {
+			continue
+		}
+		key := ev.Package + "." + ev.Test
+		switch ev.Action {
+		case "output":
+			output[key] = append(output[key], strings.TrimRight(ev.Output, "\n"))
+		case "fail":
+			f := TestFailure{Framework: "go", Test: ev.Test}
+			applyGoOutput(&f, output[key])
+			if f.File != "" && ev.Package != "" {
+				f.File = goPackageFile(ev.Package, f.File)
+			}
+			failures = append(failures, f)
+		}
+	}
+	return failures
+}
+
+var (
+	goFailLine   = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+	goPanicFrame = regexp.MustCompile(`^\s+(\S+_test\.go):(\d+)`)
+)
+
+// parseGoTest handles plain `go test` output: "--- FAIL: TestX" followed by indented file:line messages.
+func parseGoTest(lines []string) []TestFailure {
+	var failures []TestFailure
+	for i, line := range lines {
+		m := goFailLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		// Output for a failed test is printed before its "--- FAIL" line when run verbosely and
+		// after it otherwise, so look at both sides until the next test boundary.
+		var body []string
+		for j := i + 1; j < len(lines) && j < i+40; j++ {
+			if strings.HasPrefix(strings.TrimSpace(lines[j]), "--- ") || strings.HasPrefix(lines[j], "=== RUN") || strings.HasPrefix(lines[j], "FAIL") || strings.HasPrefix(lines[j], "ok ") {
+				break
+			}
+			body = append(body, lines[j])
+		}
+		for j := i - 1; j >= 0 && j > i-40; j-- {
+			if strings.HasPrefix(lines[j], "=== RUN") || strings.HasPrefix(strings.TrimSpace(lines[j]), "--- ") {
+				break
+			}
+			body = append([]string{lines[j]}, body...)
+		}
+
+		f := TestFailure{Framework: "go", Test: m[1]}
+		applyGoOutput(&f, body)
+		failures = append(failures, f)
+	}
+
+	// A panic aborts the whole test binary without a "--- FAIL" line for the running test
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "panic: ") {
+			continue
+		}
+		f := TestFailure{Framework: "go", Test: "(panic)", Panic: true, Message: line}
+		for j := i + 1; j < len(lines) && j < i+60; j++ {
+			if strings.HasPrefix(lines[j], "FAIL") {
+				break
+			}
+			if strings.HasPrefix(lines[j], "goroutine ") {
+				continue
+			}
+			if fn, ok := strings.CutSuffix(lines[j], ")"); ok && strings.Contains(fn, ".Test") && f.Test == "(panic)" {
+				if k := strings.LastIndex(fn, ".Test"); k >= 0 {
+					name, _, _ := strings.Cut(fn[k+1:], "(")
+					f.Test = name
+				}
+			}
+			if m := goPanicFrame.FindStringSubmatch(lines[j]); m != nil && f.File == "" {
+				f.File = m[1]
+				f.Line, _ = strconv.Atoi(m[2])
+			}
+		}
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// applyGoOutput pulls the first file:line reference, the assertion messages and any panic out of
+// a Go test's output lines.
+func applyGoOutput(f *TestFailure, output []string) {
+	var messages []string
+	for _, line := range output {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "panic: ") {
+			f.Panic = true
+			messages = append(messages, trimmed)
+			continue
+		}
+		if m := goFileLine.FindStringSubmatch(line); m != nil {
+			if f.File == "" {
+				f.File = m[1]
+				f.Line, _ = strconv.Atoi(m[2])
+			}
+			messages = append(messages, m[3])
+			continue
+		}
+		if len(messages) > 0 && strings.HasPrefix(line, "        ") && trimmed != "" {
+			messages = append(messages, trimmed)
+		}
+	}
+	f.Message = strings.Join(messages, "\n")
+}
+
+// goPackageFile turns a bare test file name into a repo-relative path when the package path
+// shares a suffix with a directory in the workspace.
+func goPackageFile(pkg string, file string) string {
+	if strings.Contains(file, "/") {
+		return file
+	}
+	parts := strings.Split(pkg, "/")
+	for i := range parts {
+		candidate := filepath.Join(filepath.Join(parts[i:]...), file)
+		if _, err := os.Stat(filepath.Join(workspaceDir(), candidate)); err == nil {
+			return filepath.ToSlash(candidate)
+		}
+	}
+	return file
+}
+
+var (
+	jestFailHeader = regexp.MustCompile(`^\s*● (.+?)\s*$`)
+	jestFrame      = regexp.MustCompile(`\(?([\w./@-]+\.[cm]?[jt]sx?):(\d+):\d+\)?\s*$`)
+	jestCodeFrame  = regexp.MustCompile(`^\d+ \|`)
+)
+
+// parseJest handles Jest's "● Suite › test" failure blocks with their stack frames.
+func parseJest(lines []string) []TestFailure {
+	var failures []TestFailure
+	for i, line := range lines {
+		m := jestFailHeader.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(m[1], "Console") {
+			continue
+		}
+		f := TestFailure{Framework: "jest", Test: m[1]}
+		var messages []string
+		for j := i + 1; j < len(lines) && j < i+60; j++ {
+			trimmed := strings.TrimSpace(lines[j])
+			if jestFailHeader.MatchString(lines[j]) || strings.HasPrefix(trimmed, "FAIL ") || strings.HasPrefix(trimmed, "PASS ") || strings.HasPrefix(trimmed, "Test Suites:") {
+				break
+			}
+			if strings.HasPrefix(trimmed, "at ") {
+				if fm := jestFrame.FindStringSubmatch(trimmed); fm != nil && f.File == "" && !strings.Contains(fm[1], "node_modules") {
+					f.File = fm[1]
+					f.Line, _ = strconv.Atoi(fm[2])
+				}
+				continue
+			}
+			if trimmed != "" && !strings.HasPrefix(trimmed, ">") && !jestCodeFrame.MatchString(trimmed) && !strings.HasPrefix(trimmed, "|") {
+				messages = append(messages, trimmed)
+			}
+		}
+		f.Message = strings.Join(messages, "\n")
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+var (
+	pytestFailed   = regexp.MustCompile(`^FAILED (\S+?)::(\S+)(?: - (.*))?$`)
+	pytestLocation = regexp.MustCompile(`^(\S+\.py):(\d+): (\w+(?:Error|Exception)?)`)
+	pytestSection  = regexp.MustCompile(`^_{3,} (\S+) _{3,}$`)
+)
+
+// parsePytest handles pytest's short test summary ("FAILED path::test - msg") and the
+// "path.py:12: AssertionError" location lines in the detailed failure sections.
+func parsePytest(lines []string) []TestFailure {
+	locations := make(map[string]TestFailure)
+	current := ""
+	for _, line := range lines {
+		if m := pytestSection.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			continue
+		}
+		if m := pytestLocation.FindStringSubmatch(line); m != nil && current != "" {
+			n, _ := strconv.Atoi(m[2])
+			locations[current] = TestFailure{File: m[1], Line: n}
+		}
+	}
+
+	var failures []TestFailure
+	for _, line := range lines {
+		m := pytestFailed.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		f := TestFailure{Framework: "pytest", Test: m[2], File: m[1], Message: m[3]}
+		name := m[2]
+		if k := strings.LastIndex(name, "::"); k >= 0 {
+			name = name[k+2:]
+		}
+		if loc, ok := locations[name]; ok && loc.File == f.File {
+			f.Line = loc.Line
+		}
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+var playwrightHeader = regexp.MustCompile(`^\s*\d+\) (?:\[[^\]]+\] › )?([\w./@-]+\.[cm]?[jt]sx?):(\d+):\d+ › (.+?)\s*─*\s*$`)
+
+// parsePlaywright handles Playwright's list/line reporter failure headers and their error lines.
+func parsePlaywright(lines []string) []TestFailure {
+	var failures []TestFailure
+	for i, line := range lines {
+		m := playwrightHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		f := TestFailure{Framework: "playwright", Test: strings.TrimSpace(m[3]), File: m[1], Line: n}
+		var messages []string
+		for j := i + 1; j < len(lines) && j < i+30; j++ {
+			if playwrightHeader.MatchString(lines[j]) {
+				break
+			}
+			trimmed := strings.TrimSpace(lines[j])
+			if strings.HasPrefix(trimmed, "Error:") || strings.HasPrefix(trimmed, "Expected") || strings.HasPrefix(trimmed, "Received") || strings.HasPrefix(trimmed, "Timeout") || strings.HasPrefix(trimmed, "Call log:") {
+				messages = append(messages, trimmed)
+			}
+		}
+		f.Message = strings.Join(messages, "\n")
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// junitSuites covers both <testsuites> and bare <testsuite> report roots.
+type junitSuites struct {
+	Suites []junitSuite `xml:"testsuite"`
+	junitSuite
+}
+
+type junitSuite struct {
+	Name   string          `xml:"name,attr"`
+	Cases  []junitTestCase `xml:"testcase"`
+	Suites []junitSuite    `xml:"testsuite"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Line      int           `xml:"line,attr"`
+	Failures  []junitResult `xml:"failure"`
+	Errors    []junitResult `xml:"error"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// parseJUnitXML extracts failed and errored test cases from a JUnit XML report.
+func parseJUnitXML(data []byte) ([]TestFailure, error) {
+	var root junitSuites
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing junit xml: %w", err)
+	}
+
+	var failures []TestFailure
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, tc := range s.Cases {
+			for _, r := range append(tc.Failures, tc.Errors...) {
+				name := tc.Name
+				if tc.ClassName != "" {
+					name = tc.ClassName + "." + tc.Name
+				}
+				msg := r.Message
+				if body := strings.TrimSpace(r.Body); body != "" {
+					msg = strings.TrimSpace(msg + "\n" + body)
+				}
+				failures = append(failures, TestFailure{
+					Framework: "junit",
+					Test:      name,
+					File:      tc.File,
+					Line:      tc.Line,
+					Message:   msg,
+					Panic:     strings.Contains(strings.ToLower(r.Type), "panic"),
+				})
+			}
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	walk(root.junitSuite)
+	for _, s := range root.Suites {
+		walk(s)
+	}
+	return failures, nil
+}
+
+// junitReportPatterns reads JUNIT_REPORTS, comma- or newline-separated gitignore-style patterns
+// for the JUnit XML reports the failed job writes. Without it no reports are read: files in the
+// checkout that merely look like reports, such as test fixtures, would pass for the run's results.
+func junitReportPatterns() ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, p := range strings.FieldsFunc(os.Getenv("JUNIT_REPORTS"), func(r rune) bool { return r == ',' || r == '\n' }) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		re, err := pathPattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("JUNIT_REPORTS pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// findJUnitReports parses the JUnit XML reports in the workspace that match JUNIT_REPORTS.
+func findJUnitReports(workspace string, patterns []*regexp.Regexp) []TestFailure {
+	if len(patterns) == 0 {
+		return nil
+	}
+	var failures []TestFailure
+	_ = filepath.WalkDir(workspace, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(workspace, p)
+		if err != nil || !strings.HasSuffix(strings.ToLower(d.Name()), ".xml") || !matchesAny(filepath.ToSlash(rel), patterns) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil || !strings.Contains(string(data), "<testcase") {
+			return nil
+		}
+		found, err := parseJUnitXML(data)
+		if err != nil {
+			slog.Warn("skipping unparseable junit report", "path", p, "err", err)
+			return nil
+		}
+		failures = append(failures, found...)
+		return nil
+	})
+	return failures
+}
+
+// pathPattern compiles a gitignore-style path pattern. A pattern with a slash at the start or in
+// the middle is anchored to the repository root, otherwise it matches at any depth; a pattern that
+// matches a directory also matches everything below it, except that "dir/*" only covers the
+// directory's direct children.
+func pathPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	shallow := strings.HasSuffix(pattern, "/*")
+	pattern = strings.TrimPrefix(pattern, "/")
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(?:^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	switch {
+	case dirOnly:
+		b.WriteString("/")
+	case shallow:
+		b.WriteString("$")
+	default:
+		b.WriteString("(?:$|/)")
+	}
+	return regexp.Compile(b.String())
+}
+
+func matchesAny(path string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestFailures parses every failed job's logs plus the JUnit reports JUNIT_REPORTS points at. The result
+// is computed once per run and shared by the get_test_failures tool and the PR comment.
+func (t *Triage) TestFailures(ctx context.Context) []TestFailure {
+	if t.testFailuresLoaded {
+		return t.testFailures
+	}
+
+	failures := []TestFailure{}
+	jobs, err := t.failedJobs(ctx)
+	if err != nil {
+		slog.Warn("error listing failed jobs for test failure extraction", "err", err)
+	}
+	for _, job := range jobs {
+		logs, err := t.downloadJobLogs(ctx, job.GetID())
+		if err != nil {
+			slog.Warn("error downloading logs for test failure extraction", "job", job.GetName(), "err", err)
+			continue
+		}
+		for _, f := range parseTestFailures(logs) {
+			f.Job = job.GetName()
+			failures = append(failures, f)
+		}
+	}
+	failures = append(failures, findJUnitReports(workspaceDir(), t.junitReports)...)
+	t.testFailures = dedupeFailures(failures)
+	t.testFailuresLoaded = true
+
+	slog.Info("extracted test failures", "count", len(t.testFailures))
+	return t.testFailures
+}
+
+func (t *Triage) toolGetTestFailures(ctx context.Context) string {
+	failures := t.TestFailures(ctx)
+	if len(failures) == 0 {
+		return "no test failures recognised in the job logs; use get_job_logs to inspect the raw output"
+	}
+	b, _ := json.Marshal(failures)
+	return string(b)
+}
+
+// maxFailureRows caps the failure table in the PR comment.
+const maxFailureRows = 20
+
+// failureTable renders test failures as a markdown table for the PR comment.
+func failureTable(failures []TestFailure) string {
+	var b strings.Builder
+	b.WriteString("| Test | Location | Job | Error |\n|---|---|---|---|\n")
+	for i, f := range failures {
+		if i == maxFailureRows {
+			fmt.Fprintf(&b, "\n*…and %d more*\n", len(failures)-maxFailureRows)
+			break
+		}
+
+		location := f.File
+		if location != "" && f.Line > 0 {
+			location = fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		if location != "" {
+			location = "`" + location + "`"
+		}
+
+		message, _, _ := strings.Cut(f.Message, "\n")
+		if len(message) > 150 {
+			message = cutAtRune(message, 150) + "…"
+		}
+		if f.Panic {
+			message = "💥 " + message
+		}
+
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n",
+			tableCell(f.Test), location, tableCell(f.Job), tableCell(message))
+	}
+	return b.String()
+}
+
+// tableCell escapes characters that would break a markdown table cell.
+func tableCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ", "`", "'").Replace(s)
+}
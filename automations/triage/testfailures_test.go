@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v79/github"
+)
+
+func TestParseTestFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		logs string
+		want []TestFailure // Message is checked as a substring
+	}{
+		{
+			name: "go test",
+			logs: `2024-05-01T10:00:00.0000000Z === RUN   TestParse
+2024-05-01T10:00:00.0000000Z     parse_test.go:42: got 3, want 4
+2024-05-01T10:00:00.0000000Z --- FAIL: TestParse (0.00s)
+2024-05-01T10:00:00.0000000Z FAIL`,
+			want: []TestFailure{{Framework: "go", Test: "TestParse", File: "parse_test.go", Line: 42, Message: "got 3, want 4"}},
+		},
+		{
+			name: "go test -json",
+			logs: `{"Action":"run","Package":"example.com/none","Test":"TestJSON"}
+{"Action":"output","Package":"example.com/none","Test":"TestJSON","Output":"    json_test.go:7: unexpected end of input\n"}
+{"Action":"fail","Package":"example.com/none","Test":"TestJSON","Elapsed":0}`,
+			want: []TestFailure{{Framework: "go", Test: "TestJSON", File: "json_test.go", Line: 7, Message: "unexpected end of input"}},
+		},
+		{
+			name: "go panic",
+			logs: `panic: runtime error: index out of range [3] with length 3
+
+goroutine 7 [running]:
+example.com/pkg.TestIndex(0xc000102820)
+	/home/runner/work/pkg/index_test.go:15 +0x1d
+FAIL	example.com/pkg	0.012s`,
+			want: []TestFailure{{Framework: "go", Test: "TestIndex", File: "/home/runner/work/pkg/index_test.go", Line: 15, Message: "index out of range"}},
+		},
+		{
+			name: "jest",
+			logs: `FAIL src/sum.test.ts
+  ● sum › adds numbers
+
+    expect(received).toBe(expected)
+
+    Expected: 4
+    Received: 5
+
+      at Object.<anonymous> (src/sum.test.ts:12:17)
+
+Test Suites: 1 failed, 1 total`,
+			want: []TestFailure{{Framework: "jest", Test: "sum › adds numbers", File: "src/sum.test.ts", Line: 12, Message: "Expected: 4"}},
+		},
+		{
+			name: "pytest",
+			logs: `_________________________________ test_total _________________________________
+tests/test_cart.py:21: AssertionError
+=========================== short test summary info ============================
+FAILED tests/test_cart.py::test_total - assert 10 == 12`,
+			want: []TestFailure{{Framework: "pytest", Test: "test_total", File: "tests/test_cart.py", Line: 21, Message: "assert 10 == 12"}},
+		},
+		{
+			name: "playwright",
+			logs: `  1) [chromium] › tests/login.spec.ts:8:5 › login › shows an error ──────────────
+
+    Error: Timed out 5000ms waiting for expect(locator).toBeVisible()`,
+			want: []TestFailure{{Framework: "playwright", Test: "login › shows an error", File: "tests/login.spec.ts", Line: 8, Message: "Timed out 5000ms"}},
+		},
+		{
+			name: "passing run",
+			logs: "ok  \texample.com/pkg\t0.01s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTestFailures(tt.logs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d failures, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.Framework != want.Framework || g.Test != want.Test || g.File != want.File || g.Line != want.Line {
+					t.Errorf("got %s %q at %s:%d, want %s %q at %s:%d", g.Framework, g.Test, g.File, g.Line, want.Framework, want.Test, want.File, want.Line)
+				}
+				if !strings.Contains(g.Message, want.Message) {
+					t.Errorf("message %q doesn't contain %q", g.Message, want.Message)
+				}
+			}
+		})
+	}
+}
+
+const junitReport = `<testsuite name="pkg"><testcase name="TestA"><failure message="boom">trace</failure></testcase></testsuite>`
+
+func TestFindJUnitReports(t *testing.T) {
+	workspace := t.TempDir()
+	for path, content := range map[string]string{
+		"build/test-results/TEST-pkg.xml":    junitReport,
+		"build/test-results/nested/more.xml": junitReport,
+		"testdata/fixtures/junit.xml":        junitReport,
+		"reports/junit.xml":                  junitReport,
+		"reports/coverage.xml":               `<coverage/>`,
+	} {
+		full := filepath.Join(workspace, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		patterns string
+		want     int
+	}{
+		{"no patterns", "", 0},
+		{"directory", "build/test-results/", 2},
+		{"shallow glob", "build/test-results/*.xml", 1},
+		{"any depth", "**/TEST-*.xml", 1},
+		{"several patterns", "reports/*.xml,\nbuild/test-results/**/*.xml", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JUNIT_REPORTS", tt.patterns)
+			patterns, err := junitReportPatterns()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := findJUnitReports(workspace, patterns); len(got) != tt.want {
+				t.Errorf("found %d failures, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFailureTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure TestFailure
+		want    string
+	}{
+		{
+			name:    "location and first line",
+			failure: TestFailure{Test: "TestA", File: "pkg/a_test.go", Line: 12, Job: "test", Message: "got 1\nwant 2"},
+			want:    "| `TestA` | `pkg/a_test.go:12` | test | got 1 |",
+		},
+		{
+			name:    "panic",
+			failure: TestFailure{Test: "TestB", Job: "test", Message: "nil map", Panic: true},
+			want:    "| `TestB` |  | test | 💥 nil map |",
+		},
+		{
+			name:    "long multi-byte message is cut on a character",
+			failure: TestFailure{Test: "TestC", Job: "test", Message: "x" + strings.Repeat("é", 100)},
+			want:    "| `TestC` |  | test | x" + strings.Repeat("é", 74) + "… |",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := failureTable([]TestFailure{tt.failure})
+			if !utf8.ValidString(got) {
+				t.Errorf("table is not valid UTF-8: %q", got)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("table doesn't contain %q:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTestFailuresParsedOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		logs string
+		want int
+	}{
+		{"failures", "--- FAIL: TestA (0.00s)\n    a_test.go:5: boom\nFAIL", 1},
+		{"no failures", "ok  \texample.com/pkg\t0.01s", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"total_count":1,"jobs":[{"id":7,"name":"test","conclusion":"failure"}]}`))
+			}))
+			defer srv.Close()
+			client := github.NewClient(srv.Client())
+			client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			triage := &Triage{github: client, owner: "o", repo: "r", runID: 1, logCache: map[int64]string{7: tt.logs}}
+			for range 3 {
+				if got := triage.TestFailures(context.Background()); len(got) != tt.want {
+					t.Errorf("got %d failures, want %d", len(got), tt.want)
+				}
+			}
+			if calls != 1 {
+				t.Errorf("listed jobs %d times, want once", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultToolsConfig is where a repository's tool configuration is read from when
+// TRIAGE_TOOLS_CONFIG isn't set.
+const defaultToolsConfig = ".github/triage-tools.json"
+
+const defaultCommandTimeout = 2 * time.Minute
+
+// Tool is something the model can call from runToolLoop: the schema it is offered and the
+// executor that produces the result. Errors are returned as result text for the model to read.
+type Tool interface {
+	Definition() ToolDef
+	Execute(ctx context.Context, argsJSON string) string
+}
+
+// toolScope is the conversation a tool is offered in.
+type toolScope string
+
+const (
+	scopeTriage toolScope = "triage"
+	scopeFix    toolScope = "fix"
+)
+
+// funcTool is a Tool backed by a Go function.
+type funcTool struct {
+	def  ToolDef
+	exec func(ctx context.Context, argsJSON string) string
+}
+
+func newFuncTool(name string, description string, parameters map[string]interface{}, exec func(ctx context.Context, argsJSON string) string) *funcTool {
+	return &funcTool{
+		def: ToolDef{
+			Type:     "function",
+			Function: FunctionDef{Name: name, Description: description, Parameters: parameters},
+		},
+		exec: exec,
+	}
+}
+
+func (f *funcTool) Definition() ToolDef { return f.def }
+
+func (f *funcTool) Execute(ctx context.Context, argsJSON string) string {
+	return f.exec(ctx, argsJSON)
+}
+
+// noParameters is the schema for tools that take no arguments.
+var noParameters = map[string]interface{}{
+	"type":       "object",
+	"properties": map[string]interface{}{},
+}
+
+// ToolRegistry holds the tools available to the model and the conversations each is offered in.
+type ToolRegistry struct {
+	tools  map[string]Tool
+	scopes map[string][]toolScope
+	order  []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool), scopes: make(map[string][]toolScope)}
+}
+
+// Register adds a tool, offered in the given scopes. Tool names must be unique.
+func (r *ToolRegistry) Register(tool Tool, scopes ...toolScope) error {
+	name := tool.Definition().Function.Name
+	if _, ok := r.tools[name]; ok {
+		return fmt.Errorf("tool %s is already registered", name)
+	}
+	r.tools[name] = tool
+	r.scopes[name] = scopes
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Remove drops a tool if it is registered.
+func (r *ToolRegistry) Remove(name string) {
+	if _, ok := r.tools[name]; !ok {
+		return
+	}
+	delete(r.tools, name)
+	delete(r.scopes, name)
+	r.order = slices.DeleteFunc(r.order, func(n string) bool { return n == name })
+}
+
+// Definitions returns the schemas of the tools offered in scope, in registration order.
+func (r *ToolRegistry) Definitions(scope toolScope) []ToolDef {
+	var defs []ToolDef
+	for _, name := range r.order {
+		if slices.Contains(r.scopes[name], scope) {
+			defs = append(defs, r.tools[name].Definition())
+		}
+	}
+	return defs
+}
+
+// Execute runs a tool call and returns the result string.
+func (r *ToolRegistry) Execute(ctx context.Context, name string, argsJSON string) string {
+	tool, ok := r.tools[name]
+	if !ok {
+		return fmt.Sprintf("unknown tool: %s", name)
+	}
+	return tool.Execute(ctx, argsJSON)
+}
+
+// registerBuiltinTools adds the tools that ship with triage.
+func (t *Triage) registerBuiltinTools(r *ToolRegistry) error {
+	tools := []struct {
+		tool   Tool
+		scopes []toolScope
+	}{
+		{newFuncTool("list_failed_jobs",
+			"List all failed jobs in the current workflow run. Returns job names and IDs.",
+			noParameters,
+			func(ctx context.Context, _ string) string { return t.toolListFailedJobs(ctx) },
+		), []toolScope{scopeTriage}},
+		{newFuncTool("get_job_logs",
+			"Get the last N lines of logs for a specific failed job. Use list_failed_jobs first to get job IDs.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"job_id": map[string]interface{}{
+						"type":        "integer",
+						"description": "The job ID to fetch logs for",
+					},
+					"tail_lines": map[string]interface{}{
+						"type":        "integer",
+						"description": "Number of lines from the end to return (default 200, max 1000)",
+					},
+				},
+				"required": []string{"job_id"},
+			},
+			t.toolGetJobLogs,
+		), []toolScope{scopeTriage}},
+		{newFuncTool("get_test_failures",
+			"Get a structured summary of failing tests parsed from every failed job's logs (go test, Jest, pytest, Playwright, JUnit XML): test names, files, lines, assertion messages and panics.",
+			noParameters,
+			func(ctx context.Context, _ string) string { return t.toolGetTestFailures(ctx) },
+		), []toolScope{scopeTriage}},
+		{newFuncTool("read_file",
+			"Read the contents of a file in the repository checkout. Use this to inspect source files mentioned in error messages.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "Relative file path from the repository root",
+					},
+				},
+				"required": []string{"path"},
+			},
+			func(_ context.Context, argsJSON string) string { return t.toolReadFile(argsJSON) },
+		), []toolScope{scopeTriage, scopeFix}},
+		{newFuncTool("get_workflow_run_info",
+			"Get metadata about the current workflow run: branch, commit SHA, event type, workflow name.",
+			noParameters,
+			func(ctx context.Context, _ string) string { return t.toolGetWorkflowRunInfo(ctx) },
+		), []toolScope{scopeTriage}},
+	}
+
+	for _, b := range tools {
+		if err := r.Register(b.tool, b.scopes...); err != nil {
+			return err
+		}
+	}
+	for _, tool := range t.workspaceTools() {
+		if err := r.Register(tool, scopeTriage, scopeFix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToolsConfig is a repository's tool configuration. Enable, when set, keeps only the listed
+// built-in tools; Disable removes built-in tools; Custom adds command tools.
+type ToolsConfig struct {
+	Enable  []string             `json:"enable,omitempty"`
+	Disable []string             `json:"disable,omitempty"`
+	Custom  []*CommandToolConfig `json:"custom,omitempty"`
+}
+
+// CommandToolConfig declares a tool that runs a fixed command in the workspace. The model can
+// only fill in the declared arguments, each checked against its pattern and passed as a single
+// argv element, so it can't run anything the config doesn't allow.
+type CommandToolConfig struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Command     []string                   `json:"command"` // argv; "{arg}" is replaced by the argument's value
+	Args        map[string]*CommandToolArg `json:"args,omitempty"`
+	Timeout     string                     `json:"timeout,omitempty"` // e.g. "2m"
+	Scopes      []toolScope                `json:"scopes,omitempty"`  // default: triage
+}
+
+// CommandToolArg is one argument the model may pass to a command tool.
+type CommandToolArg struct {
+	Description string `json:"description"`
+	Pattern     string `json:"pattern"` // the whole value must match
+	Required    bool   `json:"required,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// loadToolsConfig reads TRIAGE_TOOLS_CONFIG, relative to the workspace, or
+// .github/triage-tools.json if present. A missing default file means no configuration.
+func loadToolsConfig() (*ToolsConfig, error) {
+	path := os.Getenv("TRIAGE_TOOLS_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		path = defaultToolsConfig
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceDir(), path)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return &ToolsConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading tools config: %w", err)
+	}
+
+	var cfg ToolsConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing tools config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// newToolRegistry registers the built-in tools, applies the repository's enable and disable
+// lists, then adds its custom command tools.
+func (t *Triage) newToolRegistry(cfg *ToolsConfig) (*ToolRegistry, error) {
+	r := NewToolRegistry()
+	if err := t.registerBuiltinTools(r); err != nil {
+		return nil, err
+	}
+
+	for _, name := range append(append([]string{}, cfg.Enable...), cfg.Disable...) {
+		if _, ok := r.tools[name]; !ok {
+			return nil, fmt.Errorf("tools config refers to unknown tool %s", name)
+		}
+	}
+	if len(cfg.Enable) > 0 {
+		for _, name := range slices.Clone(r.order) {
+			if !slices.Contains(cfg.Enable, name) {
+				r.Remove(name)
+			}
+		}
+	}
+	for _, name := range cfg.Disable {
+		r.Remove(name)
+	}
+
+	for _, c := range cfg.Custom {
+		tool, err := newCommandTool(c, t.model.MaxResultChars)
+		if err != nil {
+			return nil, err
+		}
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = []toolScope{scopeTriage}
+		}
+		if err := r.Register(tool, scopes...); err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info("registered tools", "triage", len(r.Definitions(scopeTriage)), "fix", len(r.Definitions(scopeFix)), "custom", len(cfg.Custom))
+	return r, nil
+}
+
+// commandTool runs a configured command, see CommandToolConfig.
+type commandTool struct {
+	config   *CommandToolConfig
+	timeout  time.Duration
+	maxChars int
+}
+
+func newCommandTool(c *CommandToolConfig, maxChars int) (*commandTool, error) {
+	if !toolNamePattern.MatchString(c.Name) {
+		return nil, fmt.Errorf("custom tool name %q must be 1-64 letters, digits, _ or -", c.Name)
+	}
+	if c.Description == "" || len(c.Command) == 0 {
+		return nil, fmt.Errorf("custom tool %s needs a description and a command", c.Name)
+	}
+	if strings.Contains(c.Command[0], "{") {
+		return nil, fmt.Errorf("custom tool %s: the program to run can't come from an argument", c.Name)
+	}
+	for _, s := range c.Scopes {
+		if s != scopeTriage && s != scopeFix {
+			return nil, fmt.Errorf("custom tool %s: scope must be triage or fix, got %s", c.Name, s)
+		}
+	}
+	for name, arg := range c.Args {
+		if arg.Pattern == "" {
+			return nil, fmt.Errorf("custom tool %s: argument %s needs a pattern", c.Name, name)
+		}
+		var err error
+		if arg.pattern, err = regexp.Compile(`^(?:` + arg.Pattern + `)$`); err != nil {
+			return nil, fmt.Errorf("custom tool %s: argument %s: invalid pattern: %w", c.Name, name, err)
+		}
+	}
+
+	timeout := defaultCommandTimeout
+	if c.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, fmt.Errorf("custom tool %s: invalid timeout: %w", c.Name, err)
+		}
+	}
+	return &commandTool{config: c, timeout: timeout, maxChars: maxChars}, nil
+}
+
+func (c *commandTool) Definition() ToolDef {
+	properties := make(map[string]interface{})
+	var required []string
+	for name, arg := range c.config.Args {
+		properties[name] = map[string]interface{}{
+			"type":        "string",
+			"description": arg.Description,
+		}
+		if arg.Required {
+			required = append(required, name)
+		}
+	}
+	slices.Sort(required)
+
+	parameters := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		parameters["required"] = required
+	}
+	return ToolDef{
+		Type:     "function",
+		Function: FunctionDef{Name: c.config.Name, Description: c.config.Description, Parameters: parameters},
+	}
+}
+
+func (c *commandTool) Execute(ctx context.Context, argsJSON string) string {
+	args := make(map[string]string)
+	if argsJSON != "" {
+		if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+			return fmt.Sprintf("error parsing arguments: %v", err)
+		}
+	}
+	for name, value := range args {
+		arg, ok := c.config.Args[name]
+		if !ok {
+			return fmt.Sprintf("error: unknown argument %s", name)
+		}
+		if !arg.pattern.MatchString(value) {
+			return fmt.Sprintf("error: argument %s must match %s", name, arg.Pattern)
+		}
+	}
+	for name, arg := range c.config.Args {
+		if _, ok := args[name]; !ok && arg.Required {
+			return fmt.Sprintf("error: argument %s is required", name)
+		}
+	}
+
+	argv := make([]string, 0, len(c.config.Command))
+	for _, part := range c.config.Command {
+		// An element that is only an omitted optional argument is dropped rather than passed empty
+		if name, ok := strings.CutPrefix(part, "{"); ok {
+			if name, ok = strings.CutSuffix(name, "}"); ok && c.config.Args[name] != nil && args[name] == "" {
+				continue
+			}
+		}
+		for name := range c.config.Args {
+			part = strings.ReplaceAll(part, "{"+name+"}", args[name])
+		}
+		argv = append(argv, part)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	slog.Info("running custom tool command", "tool", c.config.Name, "argv", argv)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = workspaceDir()
+	cmd.Env = commandEnv()
+	cmd.WaitDelay = commandWaitDelay
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	result := truncateResult(output.String(), c.maxChars)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result += fmt.Sprintf("\n(command timed out after %s)", c.timeout)
+	case err != nil:
+		result += fmt.Sprintf("\n(command failed: %v)", err)
+	}
+	if strings.TrimSpace(result) == "" {
+		return "(no output)"
+	}
+	return result
+}
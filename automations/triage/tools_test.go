@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewCommandTool(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  CommandToolConfig
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			config: CommandToolConfig{Name: "lint", Description: "Run the linter", Command: []string{"make", "lint", "PKG={pkg}"}, Args: map[string]*CommandToolArg{"pkg": {Pattern: `[a-z/]+`}}, Timeout: "30s", Scopes: []toolScope{scopeFix}},
+		},
+		{
+			name:    "bad name",
+			config:  CommandToolConfig{Name: "run lint", Description: "d", Command: []string{"make"}},
+			wantErr: "must be 1-64 letters",
+		},
+		{
+			name:    "no command",
+			config:  CommandToolConfig{Name: "lint", Description: "d"},
+			wantErr: "needs a description and a command",
+		},
+		{
+			name:    "program from an argument",
+			config:  CommandToolConfig{Name: "lint", Description: "d", Command: []string{"{tool}", "run"}, Args: map[string]*CommandToolArg{"tool": {Pattern: `\w+`}}},
+			wantErr: "program to run can't come from an argument",
+		},
+		{
+			name:    "unknown scope",
+			config:  CommandToolConfig{Name: "lint", Description: "d", Command: []string{"make"}, Scopes: []toolScope{"deploy"}},
+			wantErr: "scope must be triage or fix",
+		},
+		{
+			name:    "argument without pattern",
+			config:  CommandToolConfig{Name: "lint", Description: "d", Command: []string{"make", "{pkg}"}, Args: map[string]*CommandToolArg{"pkg": {}}},
+			wantErr: "needs a pattern",
+		},
+		{
+			name:    "invalid pattern",
+			config:  CommandToolConfig{Name: "lint", Description: "d", Command: []string{"make", "{pkg}"}, Args: map[string]*CommandToolArg{"pkg": {Pattern: `[a-z`}}},
+			wantErr: "invalid pattern",
+		},
+		{
+			name:    "invalid timeout",
+			config:  CommandToolConfig{Name: "lint", Description: "d", Command: []string{"make"}, Timeout: "soon"},
+			wantErr: "invalid timeout",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newCommandTool(&tt.config, 1000)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandToolArguments(t *testing.T) {
+	t.Setenv("GITHUB_WORKSPACE", t.TempDir())
+	tool, err := newCommandTool(&CommandToolConfig{
+		Name:        "echo_args",
+		Description: "Print the arguments",
+		Command:     []string{"echo", "test", "{pkg}", "-run={test}"},
+		Args: map[string]*CommandToolArg{
+			"pkg":  {Pattern: `\./[a-z/.]+`, Required: true},
+			"test": {Pattern: `Test\w+`},
+		},
+	}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"required and optional", `{"pkg":"./api/...","test":"TestLogin"}`, "test ./api/... -run=TestLogin\n"},
+		{"optional left out", `{"pkg":"./api/..."}`, "test ./api/... -run=\n"},
+		{"missing required", `{"test":"TestLogin"}`, "error: argument pkg is required"},
+		{"unknown argument", `{"pkg":"./api","flags":"-v"}`, "error: unknown argument flags"},
+		{"shell metacharacters", `{"pkg":"./api; rm -rf /"}`, "error: argument pkg must match"},
+		{"option instead of a value", `{"pkg":"-exec=/bin/sh"}`, "error: argument pkg must match"},
+		{"pattern must match the whole value", `{"pkg":"./api","test":"TestLogin|.*"}`, "error: argument test must match"},
+		{"newline", `{"pkg":"./api\n./cmd"}`, "error: argument pkg must match"},
+		{"not an object", `["./api"]`, "error parsing arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tool.Execute(context.Background(), tt.args); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got %q, want it to start with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandToolDropsOmittedArgument(t *testing.T) {
+	t.Setenv("GITHUB_WORKSPACE", t.TempDir())
+	tool, err := newCommandTool(&CommandToolConfig{
+		Name:        "count_args",
+		Description: "Count the arguments",
+		Command:     []string{"sh", "-c", `echo "$#"`, "sh", "{pkg}", "{test}"},
+		Args: map[string]*CommandToolArg{
+			"pkg":  {Pattern: `[a-z]+`},
+			"test": {Pattern: `Test\w+`},
+		},
+	}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tool.Execute(context.Background(), `{"pkg":"api"}`); got != "1\n" {
+		t.Errorf("got %q arguments, want 1: an omitted argument shouldn't be passed as \"\"", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVerifyRepairs = 2
+	defaultVerifyTimeout = 10 * time.Minute
+	verifyOutputLines    = 200
+)
+
+// commandSecretEnv are variables that verification and other repository-controlled commands
+// never see.
+var commandSecretEnv = []string{
+	"GITHUB_TOKEN", "FIX_TOKEN", "ACTIONS_RUNTIME_TOKEN", "ACTIONS_ID_TOKEN_REQUEST_TOKEN",
+	"SLACK_WEBHOOK_URL", "SLACK_BOT_TOKEN", "TEAMS_WEBHOOK_URL", "DISCORD_WEBHOOK_URL", "NOTIFY_WEBHOOK_URL", "NOTIFY_WEBHOOK_SECRET",
+}
+
+// commandWaitDelay is how long a timed-out command's output pipes are kept open after it is
+// killed. Children that outlive it and still hold the pipes would otherwise block Wait forever.
+const commandWaitDelay = 10 * time.Second
+
+// commandEnv is the environment for commands the repository or the model controls: the job's
+// environment without commandSecretEnv.
+func commandEnv() []string {
+	return slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		return slices.Contains(commandSecretEnv, name)
+	})
+}
+
+// Verification records the outcome of running the project's verification command against a fix.
+type Verification struct {
+	Command  string
+	Passed   bool
+	ExitCode int
+	Output   string
+	Duration time.Duration
+	Attempts int
+}
+
+// verificationError is returned when a fix still fails verification after every repair attempt.
+type verificationError struct {
+	verification *Verification
+}
+
+func (e *verificationError) Error() string {
+	return fmt.Sprintf("fix failed verification (`%s` exited %d) after %d attempt(s)",
+		e.verification.Command, e.verification.ExitCode, e.verification.Attempts)
+}
+
+// runVerification runs the configured verification command in the workspace with a timeout and
+// captures the tail of its combined output.
+func (t *Triage) runVerification(ctx context.Context) *Verification {
+	ctx, cancel := context.WithTimeout(ctx, t.verifyTimeout)
+	defer cancel()
+
+	slog.Info("running verification command", "command", t.verifyCommand)
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, "bash", "-o", "pipefail", "-c", t.verifyCommand)
+	cmd.Dir = workspaceDir()
+	// The command runs code the model wrote, so it gets none of the tokens or webhook secrets
+	cmd.Env = commandEnv()
+	cmd.WaitDelay = commandWaitDelay
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+
+	v := &Verification{
+		Command:  t.verifyCommand,
+		Passed:   err == nil,
+		Duration: time.Since(start).Round(time.Second),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		v.ExitCode = exitErr.ExitCode()
+	default:
+		v.ExitCode = -1
+		output.WriteString("\n" + err.Error())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		output.WriteString(fmt.Sprintf("\nverification timed out after %s", t.verifyTimeout))
+	}
+
+	v.Output = truncateResult(t.redactor.Redact(truncateLogs(output.String(), verifyOutputLines)), t.model.MaxResultChars)
+
+	slog.Info("verification finished", "passed", v.Passed, "exitCode", v.ExitCode, "duration", v.Duration)
+	return v
+}
+
+// repairPrompt asks the model to correct a fix that failed verification.
+func repairPrompt(originalPrompt string, v *Verification) string {
+	return fmt.Sprintf("%s\n\n**Verification Failed:**\nYour previous fix has been written to the workspace, but the verification command `%s` failed with exit code %d:\n\n```\n%s\n```\n\n"+
+		"Use read_file to look at the current state of the files, then respond with your final JSON containing the further changes needed to make verification pass.",
+		originalPrompt, v.Command, v.ExitCode, v.Output)
+}
+
+// workspaceSnapshot remembers the original state of files a fix touches, and the directories
+// it creates, so a fix that never passes verification can be rolled back.
+type workspaceSnapshot struct {
+	workspace   string
+	originals   map[string]*fileState // nil value means the file didn't exist
+	createdDirs []string
+}
+
+type fileState struct {
+	content []byte
+	mode    os.FileMode
+}
+
+func newWorkspaceSnapshot(workspace string) *workspaceSnapshot {
+	return &workspaceSnapshot{workspace: workspace, originals: make(map[string]*fileState)}
+}
+
+// save records the current state of path the first time it's about to be changed.
+func (s *workspaceSnapshot) save(path string) {
+	if _, ok := s.originals[path]; ok {
+		return
+	}
+	fullPath := filepath.Join(s.workspace, path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		s.originals[path] = nil
+		return
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		s.originals[path] = nil
+		return
+	}
+	s.originals[path] = &fileState{content: content, mode: info.Mode().Perm()}
+}
+
+// mkdirAll creates dir and any missing parents inside the workspace, remembering which ones it made.
+func (s *workspaceSnapshot) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; d != "." && d != string(filepath.Separator); d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(s.workspace, d)); err == nil {
+			break
+		}
+		missing = append(missing, d)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(filepath.Join(s.workspace, missing[i]), 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		s.createdDirs = append(s.createdDirs, missing[i])
+	}
+	return nil
+}
+
+// restore puts every saved file back the way it was and removes directories the fix created.
+func (s *workspaceSnapshot) restore() {
+	for path, state := range s.originals {
+		fullPath := filepath.Join(s.workspace, path)
+		var err error
+		if state == nil {
+			err = os.Remove(fullPath)
+		} else if err = os.WriteFile(fullPath, state.content, state.mode); err == nil {
+			err = os.Chmod(fullPath, state.mode)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("error restoring file after failed fix", "path", path, "err", err)
+		}
+	}
+	for i := len(s.createdDirs) - 1; i >= 0; i-- {
+		_ = os.Remove(filepath.Join(s.workspace, s.createdDirs[i]))
+	}
+	slog.Info("restored workspace after failed fix", "files", len(s.originals), "dirs", len(s.createdDirs))
+}
+
+// verificationSummary renders the verification outcome for the fix PR body.
+func verificationSummary(v *Verification) string {
+	if v == nil {
+		return "\n\n### Verification\n\n⚠️ No verification command is configured; this fix has not been built or tested."
+	}
+
+	status := "✅ Passed"
+	if !v.Passed {
+		status = fmt.Sprintf("❌ Failed (exit code %d)", v.ExitCode)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n### Verification\n\n%s: `%s` (%s, %d attempt(s))\n", status, v.Command, v.Duration, v.Attempts)
+	if v.Output != "" {
+		fmt.Fprintf(&b, "\n<details><summary>Output</summary>\n\n```\n%s\n```\n\n</details>", truncateLogs(v.Output, 50))
+	}
+	return b.String()
+}
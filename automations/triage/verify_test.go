@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommandEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		kept bool
+	}{
+		{"GITHUB_TOKEN", false},
+		{"FIX_TOKEN", false},
+		{"ACTIONS_RUNTIME_TOKEN", false},
+		{"SLACK_WEBHOOK_URL", false},
+		{"GITHUB_REPOSITORY", true},
+		{"GOFLAGS", true},
+		{"MY_GITHUB_TOKEN_HINT", true},
+	}
+	for _, tt := range tests {
+		t.Setenv(tt.name, "value-of-"+tt.name)
+	}
+	env := commandEnv()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found bool
+			for _, kv := range env {
+				if strings.HasPrefix(kv, tt.name+"=") {
+					found = true
+				}
+			}
+			if found != tt.kept {
+				t.Errorf("%s in command environment = %v, want %v", tt.name, found, tt.kept)
+			}
+		})
+	}
+}
+
+func TestWorkspaceSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]string // "" for absent
+		after  map[string]string // written by the fix, "" to delete
+	}{
+		{
+			name:   "modified file",
+			before: map[string]string{"app.go": "original"},
+			after:  map[string]string{"app.go": "fixed"},
+		},
+		{
+			name:   "created file",
+			before: map[string]string{"new.go": ""},
+			after:  map[string]string{"new.go": "created"},
+		},
+		{
+			name:   "deleted file",
+			before: map[string]string{"old.go": "original"},
+			after:  map[string]string{"old.go": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspace := t.TempDir()
+			for path, content := range tt.before {
+				if content != "" {
+					if err := os.WriteFile(filepath.Join(workspace, path), []byte(content), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			snapshot := newWorkspaceSnapshot(workspace)
+			for path, content := range tt.after {
+				snapshot.save(path)
+				full := filepath.Join(workspace, path)
+				var err error
+				if content == "" {
+					err = os.Remove(full)
+				} else {
+					err = os.WriteFile(full, []byte(content), 0o644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			snapshot.restore()
+
+			for path, want := range tt.before {
+				data, err := os.ReadFile(filepath.Join(workspace, path))
+				if got := string(data); got != want || (want == "" && err == nil) {
+					t.Errorf("%s = %q after restore, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
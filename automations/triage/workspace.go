@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v79/github"
+)
+
+const (
+	defaultSearchResults = 50
+	maxSearchResults     = 200
+	maxSearchFileBytes   = 1 << 20
+	maxSearchLineChars   = 300
+	maxDirectoryEntries  = 500
+	defaultLogCount      = 10
+	maxLogCount          = 50
+	maxBlameLines        = 400
+	maxDiffChars         = 20_000
+	gitTimeout           = 30 * time.Second
+)
+
+// skipDirs are never searched or listed: version control internals and dependency trees.
+var skipDirs = map[string]bool{".git": true, "node_modules": true, "vendor": true, ".venv": true}
+
+// workspaceTools are the tools that explore the local checkout beyond read_file.
+func (t *Triage) workspaceTools() []Tool {
+	return []Tool{
+		newFuncTool("search_code",
+			"Search the repository checkout with a regular expression (RE2 syntax). Returns matching lines as path:line: text.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"pattern": map[string]interface{}{
+						"type":        "string",
+						"description": "Regular expression to search for, e.g. func \\w+Handler or TODO",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "Directory to search, relative to the repository root (default: whole repository)",
+					},
+					"glob": map[string]interface{}{
+						"type":        "string",
+						"description": "Only search files whose name matches this glob, e.g. *.go or *_test.ts",
+					},
+					"max_results": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of matching lines to return (default 50, max 200)",
+					},
+				},
+				"required": []string{"pattern"},
+			},
+			func(_ context.Context, argsJSON string) string { return t.toolSearchCode(argsJSON) },
+		),
+		newFuncTool("list_directory",
+			"List the files and subdirectories of a directory in the repository checkout. Directories end with /.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "Directory relative to the repository root (default: the root)",
+					},
+				},
+			},
+			func(_ context.Context, argsJSON string) string { return t.toolListDirectory(argsJSON) },
+		),
+		newFuncTool("git_log",
+			"Show the recent commits that touched a file or directory: short SHA, date, author and subject.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "File or directory relative to the repository root",
+					},
+					"max_count": map[string]interface{}{
+						"type":        "integer",
+						"description": "Number of commits to return (default 10, max 50)",
+					},
+				},
+				"required": []string{"path"},
+			},
+			t.toolGitLog,
+		),
+		newFuncTool("git_blame",
+			"Show which commit last changed each line of a file, optionally for a line range.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "File relative to the repository root",
+					},
+					"start_line": map[string]interface{}{
+						"type":        "integer",
+						"description": "First line to blame (default 1)",
+					},
+					"end_line": map[string]interface{}{
+						"type":        "integer",
+						"description": "Last line to blame (default start_line + 400)",
+					},
+				},
+				"required": []string{"path"},
+			},
+			t.toolGitBlame,
+		),
+		newFuncTool("get_commit_diff",
+			"Get the diff of the run's head commit against its parent, or against the pull request base to see every change in the PR.",
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"against": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"parent", "pr_base"},
+						"description": "What to diff against (default parent)",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "Only show changes under this file or directory",
+					},
+				},
+			},
+			t.toolGetCommitDiff,
+		),
+	}
+}
+
+func (t *Triage) toolSearchCode(argsJSON string) string {
+	var args struct {
+		Pattern    string `json:"pattern"`
+		Path       string `json:"path"`
+		Glob       string `json:"glob"`
+		MaxResults int    `json:"max_results"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Sprintf("error parsing arguments: %v", err)
+	}
+
+	re, err := regexp.Compile(args.Pattern)
+	if err != nil {
+		return fmt.Sprintf("error: invalid pattern: %v", err)
+	}
+	if args.Glob != "" {
+		if _, err := filepath.Match(args.Glob, ""); err != nil {
+			return fmt.Sprintf("error: invalid glob: %v", err)
+		}
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	dir, ok := cleanRelPath(args.Path)
+	if !ok {
+		return "error: path must be relative and within the repository"
+	}
+	if err := checkSymlinks(workspaceDir(), dir); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	limit := args.MaxResults
+	if limit <= 0 {
+		limit = defaultSearchResults
+	}
+	limit = min(limit, maxSearchResults)
+
+	workspace := workspaceDir()
+	var results []string
+	walkErr := filepath.WalkDir(filepath.Join(workspace, dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil // symlinks could point outside the repository
+		}
+		if args.Glob != "" {
+			if ok, _ := filepath.Match(args.Glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		rel, _ := filepath.Rel(workspace, path)
+		results = append(results, searchFile(path, rel, re, limit-len(results))...)
+		if len(results) >= limit {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return fmt.Sprintf("error searching: %v", walkErr)
+	}
+
+	if len(results) == 0 {
+		return "no matches"
+	}
+	out := strings.Join(results, "\n")
+	if len(results) >= limit {
+		out += fmt.Sprintf("\n... (stopped after %d matches; narrow the pattern, path or glob)", limit)
+	}
+	return out
+}
+
+// searchFile returns up to limit matching lines of a text file as "path:line: text".
+func searchFile(path string, rel string, re *regexp.Regexp, limit int) []string {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxSearchFileBytes {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil // unreadable or binary
+	}
+
+	var matches []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSearchFileBytes)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(line) > maxSearchLineChars {
+			line = line[:maxSearchLineChars] + "..."
+		}
+		matches = append(matches, fmt.Sprintf("%s:%d: %s", rel, n, line))
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+func (t *Triage) toolListDirectory(argsJSON string) string {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Sprintf("error parsing arguments: %v", err)
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	dir, ok := cleanRelPath(args.Path)
+	if !ok {
+		return "error: path must be relative and within the repository"
+	}
+	if err := checkSymlinks(workspaceDir(), dir); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(workspaceDir(), dir))
+	if err != nil {
+		return fmt.Sprintf("error listing directory: %v", err)
+	}
+
+	var b strings.Builder
+	for i, e := range entries {
+		if i == maxDirectoryEntries {
+			fmt.Fprintf(&b, "... (%d more entries)\n", len(entries)-i)
+			break
+		}
+		switch {
+		case e.IsDir():
+			fmt.Fprintf(&b, "%s/\n", e.Name())
+		default:
+			if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+				fmt.Fprintf(&b, "%s (%d bytes)\n", e.Name(), info.Size())
+			} else {
+				fmt.Fprintf(&b, "%s\n", e.Name())
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return "empty directory"
+	}
+	return b.String()
+}
+
+// runGit runs git in the workspace and returns its standard output. The checkout's config is
+// the repository's, so git runs without the job's secrets and with the fsmonitor hook disabled.
+func runGit(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.fsmonitor=false"}, args...)...)
+	cmd.Dir = workspaceDir()
+	cmd.Env = commandEnv()
+	cmd.WaitDelay = commandWaitDelay
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// shallowNote warns the model that history may be cut off by a shallow checkout.
+func shallowNote(ctx context.Context) string {
+	if out, err := runGit(ctx, "rev-parse", "--is-shallow-repository"); err == nil && strings.TrimSpace(out) == "true" {
+		return "\n(note: this is a shallow clone, so older history is missing)"
+	}
+	return ""
+}
+
+func (t *Triage) toolGitLog(ctx context.Context, argsJSON string) string {
+	var args struct {
+		Path     string `json:"path"`
+		MaxCount int    `json:"max_count"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Sprintf("error parsing arguments: %v", err)
+	}
+	path, ok := cleanRelPath(args.Path)
+	if !ok {
+		return "error: path must be relative and within the repository"
+	}
+	count := args.MaxCount
+	if count <= 0 {
+		count = defaultLogCount
+	}
+	count = min(count, maxLogCount)
+
+	out, err := runGit(ctx, "log", fmt.Sprintf("--max-count=%d", count), "--date=short", "--format=%h %ad %an: %s", "--", path)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	if out == "" {
+		return "no commits found for " + path + shallowNote(ctx)
+	}
+	return out + shallowNote(ctx)
+}
+
+func (t *Triage) toolGitBlame(ctx context.Context, argsJSON string) string {
+	var args struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"`
+		EndLine   int    `json:"end_line"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Sprintf("error parsing arguments: %v", err)
+	}
+	path, ok := cleanRelPath(args.Path)
+	if !ok {
+		return "error: path must be relative and within the repository"
+	}
+	if err := checkSymlinks(workspaceDir(), path); err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	start := max(args.StartLine, 1)
+	end := args.EndLine
+	if end < start || end-start >= maxBlameLines {
+		end = start + maxBlameLines - 1
+	}
+	// git blame rejects ranges past the end of the file, so clamp to its length
+	if content, err := os.ReadFile(filepath.Join(workspaceDir(), path)); err == nil {
+		end = min(end, len(splitLines(string(content))))
+	}
+	if start > end {
+		return fmt.Sprintf("error: file has only %d lines", end)
+	}
+
+	out, err := runGit(ctx, "blame", "--no-textconv", "--date=short", "-L", fmt.Sprintf("%d,%d", start, end), "--", path)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return out + shallowNote(ctx)
+}
+
+// diffArgs are the git diff arguments for the get_commit_diff tool. Diff drivers and textconv
+// filters from the repository's config would run arbitrary commands, so both are off.
+func diffArgs(revisions string, path string) []string {
+	args := []string{"diff", "--no-ext-diff", "--no-textconv", "--stat", "--patch", revisions}
+	if path != "" {
+		args = append(args, "--", path)
+	}
+	return args
+}
+
+func (t *Triage) toolGetCommitDiff(ctx context.Context, argsJSON string) string {
+	var args struct {
+		Against string `json:"against"`
+		Path    string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Sprintf("error parsing arguments: %v", err)
+	}
+	var path string
+	if args.Path != "" {
+		var ok bool
+		if path, ok = cleanRelPath(args.Path); !ok {
+			return "error: path must be relative and within the repository"
+		}
+	}
+
+	run, _, err := t.github.Actions.GetWorkflowRunByID(ctx, t.owner, t.repo, t.runID)
+	if err != nil {
+		return fmt.Sprintf("error getting workflow run: %v", err)
+	}
+	head := run.GetHeadSHA()
+
+	// Against the PR base, "..." diffs from the merge base like the PR's Files tab does
+	var base, revisions string
+	switch args.Against {
+	case "", "parent":
+		base = head + "^"
+		revisions = base + ".." + head
+	case "pr_base":
+		if len(run.PullRequests) == 0 {
+			return "error: this run has no associated pull request; use against=parent"
+		}
+		base = run.PullRequests[0].GetBase().GetSHA()
+		revisions = base + "..." + head
+	default:
+		return "error: against must be parent or pr_base"
+	}
+
+	diff, err := runGit(ctx, diffArgs(revisions, path)...)
+	if err != nil {
+		// The checkout is often shallow or a merge ref, so fetch the diff from GitHub instead
+		diff, err = t.apiCommitDiff(ctx, base, head, args.Against == "pr_base", path)
+		if err != nil {
+			return fmt.Sprintf("error getting diff: %v", err)
+		}
+	}
+
+	if diff == "" {
+		return "no changes"
+	}
+	return truncateResult(diff, maxDiffChars)
+}
+
+// apiCommitDiff fetches a diff from GitHub when the local checkout lacks the objects for it,
+// keeping only the files under path when one is given.
+func (t *Triage) apiCommitDiff(ctx context.Context, base string, head string, againstBase bool, path string) (string, error) {
+	var diff string
+	var err error
+	if againstBase {
+		diff, _, err = t.github.Repositories.CompareCommitsRaw(ctx, t.owner, t.repo, base, head, github.RawOptions{Type: github.Diff})
+	} else {
+		diff, _, err = t.github.Repositories.GetCommitRaw(ctx, t.owner, t.repo, head, github.RawOptions{Type: github.Diff})
+	}
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return diff, nil
+	}
+
+	var b strings.Builder
+	keep := false
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			_, target, _ := strings.Cut(strings.TrimSpace(line), " b/")
+			keep = target == path || strings.HasPrefix(target, path+"/")
+		}
+		if keep {
+			b.WriteString(line)
+		}
+	}
+	return b.String(), nil
+}
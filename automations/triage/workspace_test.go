@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWorkspace creates a git checkout with one committed file, a directory symlink and a file
+// symlink pointing outside it, and makes it the workspace.
+func testWorkspace(t *testing.T) (workspace string, outside string) {
+	t.Helper()
+	root := t.TempDir()
+	workspace = filepath.Join(root, "workspace")
+	outside = filepath.Join(root, "outside")
+	for path, content := range map[string]string{
+		"workspace/src/app.go": "package app\n\nfunc App() {}\n",
+		"outside/secret.txt":   "the secret\n",
+	} {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(workspace, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workspace, "linkfile")); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "src/app.go"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workspace
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	t.Setenv("GITHUB_WORKSPACE", workspace)
+	return workspace, outside
+}
+
+func TestWorkspaceToolsRefuseSymlinks(t *testing.T) {
+	testWorkspace(t)
+	triage := &Triage{}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() string
+		want string
+	}{
+		{"read file", func() string { return triage.toolReadFile(`{"path":"src/app.go"}`) }, "func App()"},
+		{"read symlinked file", func() string { return triage.toolReadFile(`{"path":"linkfile"}`) }, "is a symlink"},
+		{"read through symlinked directory", func() string { return triage.toolReadFile(`{"path":"linkdir/secret.txt"}`) }, "is a symlink"},
+		{"list directory", func() string { return triage.toolListDirectory(`{"path":"src"}`) }, "app.go"},
+		{"list symlinked directory", func() string { return triage.toolListDirectory(`{"path":"linkdir"}`) }, "is a symlink"},
+		{"search symlinked directory", func() string { return triage.toolSearchCode(`{"pattern":"secret","path":"linkdir"}`) }, "is a symlink"},
+		{"search skips symlinks", func() string { return triage.toolSearchCode(`{"pattern":"secret"}`) }, "no matches"},
+		{"blame", func() string { return triage.toolGitBlame(ctx, `{"path":"src/app.go"}`) }, "func App()"},
+		{"blame through symlinked directory", func() string { return triage.toolGitBlame(ctx, `{"path":"linkdir/secret.txt"}`) }, "is a symlink"},
+		{"read git directory", func() string { return triage.toolReadFile(`{"path":".git/config"}`) }, "within the repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.call()
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got %q, want it to contain %q", got, tt.want)
+			}
+			if strings.Contains(got, "the secret") {
+				t.Errorf("result leaks a file outside the workspace: %q", got)
+			}
+		})
+	}
+}
+
+func TestRunGitIgnoresRepositoryCommands(t *testing.T) {
+	workspace, _ := testWorkspace(t)
+	t.Setenv("GITHUB_TOKEN", "ghs_not_for_git")
+	marker := filepath.Join(t.TempDir(), "ran")
+	script := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nenv > "+marker+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace, "src/app.go"), []byte("package app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace, ".gitattributes"), []byte("*.go diff=test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config []string
+		args   []string
+	}{
+		{"external diff", []string{"diff.external", script}, diffArgs("HEAD", "")},
+		{"textconv", []string{"diff.test.textconv", script}, diffArgs("HEAD", "src/app.go")},
+		{"fsmonitor", []string{"core.fsmonitor", script}, []string{"status", "--short"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command("git", append([]string{"config"}, tt.config...)...)
+			cmd.Dir = workspace
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git config: %v: %s", err, out)
+			}
+			defer exec.Command("git", "-C", workspace, "config", "--unset", tt.config[0]).Run()
+
+			if _, err := runGit(context.Background(), tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Errorf("git ran the repository's %s", tt.config[0])
+			}
+		})
+	}
+
+	// Commands git does run from the repository's config must not see the job's secrets
+	cmd := exec.Command("git", "config", "alias.showenv", "!env")
+	cmd.Dir = workspace
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config: %v: %s", err, out)
+	}
+	out, err := runGit(context.Background(), "showenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "ghs_not_for_git") {
+		t.Error("git was run with GITHUB_TOKEN in its environment")
+	}
+}